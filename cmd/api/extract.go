@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/latreon/file-compressor/pkg/archiver"
+	"github.com/latreon/file-compressor/pkg/utils"
+)
+
+// Extraction limits of the server. The form fields of /api/extract can lower them, not raise them.
+const (
+	maxExtractedSize     = 1024 * 1024 * 1024 // 1GB extracted from one archive
+	maxExtractedFileSize = 512 * 1024 * 1024  // 512MB for a single file
+	maxExtractedEntries  = 10000
+	maxExtractRatio      = 100 // extracted bytes per archive byte
+	maxExtractDepth      = 32
+)
+
+// checkLimits bound the data decompressed to list or test an archive without extracting it
+var checkLimits = archiver.ExtractLimits{MaxTotalSize: maxExtractedSize, MaxRatio: maxExtractRatio}
+
+// ExtractResponse lists the files extracted from an uploaded archive, or links to their re-packed archive
+type ExtractResponse struct {
+	Success      bool            `json:"success"`
+	Message      string          `json:"message,omitempty"`
+	ExtractionID string          `json:"extractionId,omitempty"`
+	Files        []ExtractedFile `json:"files,omitempty"`
+	DownloadLink string          `json:"downloadLink,omitempty"`
+	ArchiveID    string          `json:"archiveId,omitempty"`
+	OutputSize   int64           `json:"outputSize,omitempty"`
+	InputSize    int64           `json:"inputSize,omitempty"`
+	Skipped      []string        `json:"skipped,omitempty"`
+	Renamed      []string        `json:"renamed,omitempty"`
+	Unlinked     []string        `json:"unlinked,omitempty"`
+}
+
+// ExtractedFile is a file of an extraction, served at DownloadLink until the cleanup removes it
+type ExtractedFile struct {
+	Name         string `json:"name"`
+	Size         int64  `json:"size"`
+	DownloadLink string `json:"downloadLink"`
+}
+
+// handleExtractArchive extracts an uploaded archive with the limits of the server and safe links.
+// It takes the optional "password", "include", "exclude", "path" and "overwrite" fields of the
+// CLI, and limits fields named like its flags ("maxSize", "maxFileSize", "maxEntries", "maxRatio",
+// "maxDepth"). The response lists the extracted files, or with a "repack" format field links
+// to an archive of them instead.
+func handleExtractArchive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Enforce size limit
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		log.Printf("Error parsing multipart form: %v", err)
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("File too large or invalid form: %v", err))
+		return
+	}
+
+	file, handler, err := r.FormFile("file")
+	if err != nil {
+		log.Printf("Error retrieving file: %v", err)
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Error retrieving the file: %v", err))
+		return
+	}
+	defer file.Close()
+	log.Printf("Received archive: %s (%d bytes)", handler.Filename, handler.Size)
+
+	opts, err := extractOptionsFromForm(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Re-packing stores the extracted directory, which only archive formats can hold
+	repack := r.FormValue("repack")
+	if repack != "" {
+		selected, ok := archiver.Lookup(repack)
+		repackCaps := archiver.CanCompress | archiver.CanBundle
+		if !ok || selected.Capabilities()&repackCaps != repackCaps {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported repack format: %s", repack))
+			return
+		}
+		repack = selected.Name()
+	}
+
+	// The upload is only needed while it is extracted
+	timestamp := time.Now().UnixNano()
+	uploadPath, err := saveUpload(file, handler.Filename, timestamp)
+	if err != nil {
+		log.Printf("Error saving uploaded file: %v", err)
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error saving the file: %v", err))
+		return
+	}
+	defer os.Remove(uploadPath)
+
+	if f, err := archiver.DetectFormat(uploadPath); err != nil || f.Capabilities()&archiver.CanExtract == 0 {
+		respondWithError(w, http.StatusBadRequest, "File is not an archive that can be extracted")
+		return
+	}
+
+	// Every extraction gets its own directory, removed as a whole by the cleanup
+	baseName := strings.TrimSuffix(filepath.Base(handler.Filename), filepath.Ext(handler.Filename))
+	id := fmt.Sprintf("%d_%s", timestamp, baseName)
+	destPath := filepath.Join(extractedDir, id)
+
+	var summary archiver.ExtractSummary
+	opts.Summary = &summary
+
+	// A client that disconnects cancels the request context and stops the extraction
+	log.Printf("Extracting %s to %s", handler.Filename, destPath)
+	err = archiver.ExtractContext(r.Context(), uploadPath, destPath, opts, nil)
+	if err != nil {
+		os.RemoveAll(destPath)
+		if errors.Is(err, context.Canceled) {
+			// Nobody is left to read the response
+			return
+		}
+		log.Printf("Error extracting archive: %v", err)
+		code, message := extractionError(err)
+		respondWithError(w, code, message)
+		return
+	}
+
+	resp := ExtractResponse{
+		Success:      true,
+		Message:      "Archive extracted successfully",
+		ExtractionID: id,
+		InputSize:    handler.Size,
+		Skipped:      summary.Skipped,
+		Unlinked:     summary.Unlinked,
+	}
+	for _, renamed := range summary.Renamed {
+		resp.Renamed = append(resp.Renamed, renamed.Name)
+	}
+
+	if repack != "" {
+		err = repackExtraction(r.Context(), destPath, id, repack, &resp)
+		// The extracted files are only needed to build the archive
+		os.RemoveAll(destPath)
+		resp.ExtractionID = ""
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if err != nil {
+			code, message := compressionError(err)
+			respondWithError(w, code, message)
+			return
+		}
+	} else if resp.Files, err = listExtractedFiles(destPath, id); err != nil {
+		log.Printf("Error listing extracted files: %v", err)
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error listing extracted files: %v", err))
+		return
+	}
+
+	log.Printf("Extracted %s: %d files, %d skipped, %d renamed", handler.Filename, len(resp.Files), len(resp.Skipped), len(resp.Renamed))
+	json.NewEncoder(w).Encode(resp)
+}
+
+// extractOptionsFromForm reads the extraction options of the form. Links are always checked,
+// permissions and owners stored in the archive are ignored, and the limits default to the server's.
+func extractOptionsFromForm(r *http.Request) (archiver.ExtractOptions, error) {
+	opts := archiver.ExtractOptions{
+		Include:           r.MultipartForm.Value["include"],
+		Exclude:           r.MultipartForm.Value["exclude"],
+		Paths:             r.MultipartForm.Value["path"],
+		Password:          r.FormValue("password"),
+		SafeLinks:         true,
+		IgnorePermissions: true,
+		Limits: archiver.ExtractLimits{
+			MaxTotalSize: maxExtractedSize,
+			MaxFileSize:  maxExtractedFileSize,
+			MaxEntries:   maxExtractedEntries,
+			MaxRatio:     maxExtractRatio,
+			MaxDepth:     maxExtractDepth,
+		},
+	}
+
+	var err error
+	if opts.Overwrite, err = archiver.ParseOverwritePolicy(r.FormValue("overwrite")); err != nil {
+		return opts, err
+	}
+	if opts.Overwrite == archiver.OverwriteAsk {
+		// There is no one to ask about conflicts over HTTP
+		return opts, fmt.Errorf("overwrite policy %s is not available", opts.Overwrite)
+	}
+
+	// Limits of the form only apply if they are stricter than the server's
+	for _, field := range []string{"maxSize", "maxFileSize"} {
+		value := r.FormValue(field)
+		if value == "" {
+			continue
+		}
+		size, err := utils.ParseSize(value)
+		if err != nil || size <= 0 {
+			return opts, fmt.Errorf("invalid %s: %s", field, value)
+		}
+		limit := &opts.Limits.MaxTotalSize
+		if field == "maxFileSize" {
+			limit = &opts.Limits.MaxFileSize
+		}
+		*limit = min(*limit, size)
+	}
+	for _, field := range []string{"maxEntries", "maxDepth"} {
+		value := r.FormValue(field)
+		if value == "" {
+			continue
+		}
+		count, err := strconv.Atoi(value)
+		if err != nil || count <= 0 {
+			return opts, fmt.Errorf("invalid %s: %s", field, value)
+		}
+		limit := &opts.Limits.MaxEntries
+		if field == "maxDepth" {
+			limit = &opts.Limits.MaxDepth
+		}
+		*limit = min(*limit, count)
+	}
+	if value := r.FormValue("maxRatio"); value != "" {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio <= 0 {
+			return opts, fmt.Errorf("invalid maxRatio: %s", value)
+		}
+		opts.Limits.MaxRatio = min(opts.Limits.MaxRatio, ratio)
+	}
+	return opts, nil
+}
+
+// extractionError returns the status code and message reporting a failed extraction
+func extractionError(err error) (int, string) {
+	switch {
+	case errors.Is(err, archiver.ErrLimitExceeded):
+		return http.StatusRequestEntityTooLarge, err.Error()
+	case errors.Is(err, archiver.ErrPasswordRequired), errors.Is(err, archiver.ErrWrongPassword):
+		return http.StatusUnauthorized, err.Error()
+	case errors.Is(err, archiver.ErrFileExists):
+		return http.StatusConflict, err.Error()
+	case errors.Is(err, archiver.ErrIllegalPath), errors.Is(err, archiver.ErrUnsafePath), errors.Is(err, archiver.ErrChecksum):
+		return http.StatusUnprocessableEntity, err.Error()
+	}
+	return http.StatusInternalServerError, fmt.Sprintf("Error extracting archive: %v", err)
+}
+
+// repackExtraction compresses the extracted directory destPath into an archive of the format
+// in the compressed directory, and fills in its download link and size
+func repackExtraction(ctx context.Context, destPath, id, format string, resp *ExtractResponse) error {
+	outputFilename := fmt.Sprintf("%s_extracted.%s", id, format)
+	outputPath := filepath.Join(compressedDir, outputFilename)
+
+	sources := []archiver.Source{{Path: destPath, Prefix: "."}}
+	if err := archiver.CompressSourcesContext(ctx, sources, outputPath, format, archiver.CompressOptions{}, nil); err != nil {
+		log.Printf("Error re-packing extracted files: %v", err)
+		return err
+	}
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return fmt.Errorf("error reading compressed file info: %w", err)
+	}
+
+	resp.DownloadLink = fmt.Sprintf("/download/%s", outputFilename)
+	resp.ArchiveID = outputFilename
+	resp.OutputSize = info.Size()
+	return nil
+}
+
+// listExtractedFiles returns the regular files below the extraction directory destPath
+func listExtractedFiles(destPath, id string) ([]ExtractedFile, error) {
+	var files []ExtractedFile
+	err := filepath.WalkDir(destPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(destPath, filePath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		files = append(files, ExtractedFile{
+			Name:         name,
+			Size:         info.Size(),
+			DownloadLink: extractedFileLink(id, name),
+		})
+		return nil
+	})
+	return files, err
+}
+
+// extractedFileLink returns the download link of the file name of an extraction
+func extractedFileLink(id, name string) string {
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		elems[i] = url.PathEscape(elem)
+	}
+	return fmt.Sprintf("/download/%s/%s", url.PathEscape(id), strings.Join(elems, "/"))
+}
+
+// handleDownloadExtracted serves a file of an extraction listed by /api/extract
+func handleDownloadExtracted(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	name := vars["path"]
+
+	// Validate the ID and path to prevent directory traversal
+	if filepath.Base(id) != id || id == "." || id == ".." || !fs.ValidPath(name) {
+		http.Error(w, "Invalid filename", http.StatusBadRequest)
+		return
+	}
+
+	// Only regular files are served, links are not followed
+	filePath := filepath.Join(extractedDir, id, filepath.FromSlash(name))
+	info, err := os.Lstat(filePath)
+	if err != nil || !info.Mode().IsRegular() {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	// Set appropriate headers for download
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(name)))
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	// ServeContent rather than ServeFile, which redirects files named index.html
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
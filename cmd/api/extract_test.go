@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/latreon/file-compressor/pkg/archiver"
+)
+
+// serverLimits are the limits of extractions without limits in the form
+var serverLimits = archiver.ExtractLimits{
+	MaxTotalSize: maxExtractedSize,
+	MaxFileSize:  maxExtractedFileSize,
+	MaxEntries:   maxExtractedEntries,
+	MaxRatio:     maxExtractRatio,
+	MaxDepth:     maxExtractDepth,
+}
+
+func TestExtractOptionsFromForm(t *testing.T) {
+	tests := []struct {
+		name   string
+		form   url.Values
+		limits archiver.ExtractLimits
+		err    bool
+	}{
+		{name: "defaults", form: url.Values{}, limits: serverLimits},
+		{
+			name: "lower",
+			form: url.Values{"maxSize": {"1M"}, "maxFileSize": {"1K"}, "maxEntries": {"10"}, "maxRatio": {"2.5"}, "maxDepth": {"3"}},
+			limits: archiver.ExtractLimits{
+				MaxTotalSize: 1 << 20, MaxFileSize: 1 << 10, MaxEntries: 10, MaxRatio: 2.5, MaxDepth: 3,
+			},
+		},
+		{
+			name:   "higher",
+			form:   url.Values{"maxSize": {"10G"}, "maxFileSize": {"2G"}, "maxEntries": {"1000000"}, "maxRatio": {"1000"}, "maxDepth": {"1000"}},
+			limits: serverLimits,
+		},
+		{name: "zero", form: url.Values{"maxEntries": {"0"}}, err: true},
+		{name: "negative", form: url.Values{"maxRatio": {"-1"}}, err: true},
+		{name: "malformed", form: url.Values{"maxSize": {"lots"}}, err: true},
+		{name: "ask", form: url.Values{"overwrite": {"ask"}}, err: true},
+		{name: "unknown policy", form: url.Values{"overwrite": {"sometimes"}}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/extract", nil)
+			r.Form = tt.form
+			r.MultipartForm = &multipart.Form{Value: tt.form}
+
+			opts, err := extractOptionsFromForm(r)
+			if tt.err {
+				if err == nil {
+					t.Errorf("options %+v, want an error", opts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("extractOptionsFromForm: %v", err)
+			}
+			if opts.Limits != tt.limits {
+				t.Errorf("limits %+v, want %+v", opts.Limits, tt.limits)
+			}
+			if !opts.SafeLinks || !opts.IgnorePermissions {
+				t.Errorf("options %+v, want safe links and ignored permissions", opts)
+			}
+		})
+	}
+}
+
+// compressedUpload returns the files as a tar.gz archive to upload
+func compressedUpload(t *testing.T, files ...archiver.ReaderSource) uploadFile {
+	t.Helper()
+	var archive bytes.Buffer
+	if err := archiver.CompressReaders(files, &archive, "tar.gz", archiver.CompressOptions{}); err != nil {
+		t.Fatalf("CompressReaders: %v", err)
+	}
+	return uploadFile{"upload.tar.gz", archive.Bytes()}
+}
+
+// checkNothingExtracted fails if an extraction directory is left behind
+func checkNothingExtracted(t *testing.T) {
+	t.Helper()
+	if entries, err := os.ReadDir(extractedDir); err != nil || len(entries) != 0 {
+		t.Errorf("extracted directory holds %v (%v), want nothing", entries, err)
+	}
+}
+
+func TestExtractArchive(t *testing.T) {
+	server := newTestServer(t).URL
+	upload := compressedUpload(t,
+		archiver.ReaderSource{Name: "docs/read me.txt", Reader: strings.NewReader("read me")},
+		archiver.ReaderSource{Name: "docs/notes.log", Reader: strings.NewReader("log")},
+	)
+
+	var resp ExtractResponse
+	decodeResponse(t, postForm(t, server+"/api/extract", map[string]string{"exclude": "*.log"}, upload), http.StatusOK, &resp)
+	if len(resp.Files) != 1 || resp.Files[0].Name != "docs/read me.txt" || resp.Files[0].Size != 7 {
+		t.Fatalf("files %+v, want docs/read me.txt", resp.Files)
+	}
+
+	download := sendRequest(t, http.MethodGet, server+resp.Files[0].DownloadLink)
+	defer download.Body.Close()
+	content, err := io.ReadAll(download.Body)
+	if err != nil || download.StatusCode != http.StatusOK || string(content) != "read me" {
+		t.Errorf("download: status %d, content %q (%v)", download.StatusCode, content, err)
+	}
+
+	// A re-packed extraction keeps only the archive
+	var repacked ExtractResponse
+	decodeResponse(t, postForm(t, server+"/api/extract", map[string]string{"repack": "zip"}, upload), http.StatusOK, &repacked)
+	if repacked.DownloadLink == "" || repacked.ExtractionID != "" || len(repacked.Files) != 0 {
+		t.Errorf("response %+v, want a download link and no extraction", repacked)
+	}
+	if _, err := os.Stat("compressed/" + repacked.ArchiveID); err != nil {
+		t.Errorf("re-packed archive: %v", err)
+	}
+	if _, err := os.Stat("extracted/" + resp.ExtractionID); err != nil {
+		t.Errorf("first extraction: %v", err)
+	}
+}
+
+func TestExtractArchiveRejected(t *testing.T) {
+	server := newTestServer(t).URL
+	small := compressedUpload(t, archiver.ReaderSource{Name: "a.txt", Reader: strings.NewReader(strings.Repeat("a", 100))})
+
+	// Zeros compress far beyond the ratio limit of the server
+	bomb := compressedUpload(t, archiver.ReaderSource{Name: "zeros", Reader: bytes.NewReader(make([]byte, 4<<20))})
+
+	tests := []struct {
+		name   string
+		fields map[string]string
+		upload uploadFile
+		status int
+	}{
+		{"ask", map[string]string{"overwrite": "ask"}, small, http.StatusBadRequest},
+		{"form limit", map[string]string{"maxFileSize": "10"}, small, http.StatusRequestEntityTooLarge},
+		{"raised limit", map[string]string{"maxRatio": "100000"}, bomb, http.StatusRequestEntityTooLarge},
+		{
+			"link outside",
+			nil,
+			compressedUpload(t, archiver.ReaderSource{Name: "evil", Link: "../../outside"}),
+			http.StatusUnprocessableEntity,
+		},
+		{"not an archive", nil, uploadFile{"notes.txt", []byte("just text")}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp CompressResponse
+			decodeResponse(t, postForm(t, server+"/api/extract", tt.fields, tt.upload), tt.status, &resp)
+			if resp.Success || resp.Message == "" {
+				t.Errorf("response %+v, want an error message", resp)
+			}
+			checkNothingExtracted(t)
+		})
+	}
+}
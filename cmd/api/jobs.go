@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/latreon/file-compressor/pkg/archiver"
+)
+
+// Job configuration
+const (
+	jobWorkers    = 2  // compressions running at the same time
+	maxQueuedJobs = 16 // jobs waiting for a worker before new ones are refused
+
+	eventKeepAlive = 15 * time.Second // interval of the comments sent on idle event streams
+)
+
+// States of a job
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+)
+
+// Job is a compression running in the background, created by POST /api/jobs
+type Job struct {
+	ID       string            `json:"id"`
+	State    string            `json:"state"`
+	Progress ProgressUpdate    `json:"progress"`
+	Result   *CompressResponse `json:"result,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	// Created and Finished are the times the job was submitted and left the running state
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	request *compressRequest
+	ctx     context.Context
+	cancel  context.CancelFunc
+	// watchers receive a copy of the job on every change, they are closed once it finishes
+	watchers map[chan Job]struct{}
+}
+
+// JobResponse reports the state of a job
+type JobResponse struct {
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	Job     *Job   `json:"job,omitempty"`
+}
+
+// jobStore holds the jobs until the cleanup routine expires them
+type jobStore struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	queue chan *Job
+}
+
+// jobs is the store of the server, its workers are started by startJobWorkers
+var jobs = &jobStore{
+	jobs:  make(map[string]*Job),
+	queue: make(chan *Job, maxQueuedJobs),
+}
+
+// startJobWorkers starts the goroutines running the queued jobs
+func (s *jobStore) startJobWorkers(count int) {
+	for i := 0; i < count; i++ {
+		go func() {
+			for job := range s.queue {
+				s.run(job)
+			}
+		}()
+	}
+}
+
+// submit queues a job for req. It returns false if the queue is full.
+func (s *jobStore) submit(req *compressRequest) (*Job, bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:      newJobID(),
+		State:   jobQueued,
+		Created: time.Now(),
+		request: req,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case s.queue <- job:
+	default:
+		cancel()
+		return nil, false
+	}
+	s.jobs[job.ID] = job
+	return job, true
+}
+
+// run compresses the files of a queued job, unless it was canceled while it waited
+func (s *jobStore) run(job *Job) {
+	s.mu.Lock()
+	if job.State != jobQueued {
+		s.mu.Unlock()
+		return
+	}
+	job.State = jobRunning
+	s.mu.Unlock()
+
+	progressTracker := archiver.NewProgressTracker(func(event archiver.ProgressEvent) {
+		logProgress(event)
+		s.update(job, func() {
+			job.Progress = ProgressUpdate{
+				Percentage: event.Fraction() * 100,
+				Stage:      event.Stage,
+				FileName:   event.File,
+			}
+		})
+	}, time.Second)
+
+	resp, err := runCompression(job.ctx, job.request, progressTracker)
+	job.cancel()
+
+	state := jobDone
+	switch {
+	case errors.Is(err, context.Canceled):
+		state = jobCanceled
+	case err != nil:
+		state = jobFailed
+	}
+	s.update(job, func() {
+		now := time.Now()
+		job.State = state
+		job.Finished = &now
+		if err != nil && state == jobFailed {
+			_, job.Error = compressionError(err)
+		} else if err == nil {
+			job.Result = &resp
+		}
+	})
+	log.Printf("Job %s %s", job.ID, state)
+}
+
+// update changes job with the store locked and sends it to its watchers
+func (s *jobStore) update(job *Job, change func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change()
+	s.publishLocked(job)
+}
+
+// publishLocked sends a copy of job to its watchers, replacing the copy a slow watcher has not
+// taken yet, and closes them once the job is finished
+func (s *jobStore) publishLocked(job *Job) {
+	snapshot := *job
+	for watcher := range job.watchers {
+		select {
+		case <-watcher:
+		default:
+		}
+		watcher <- snapshot
+		if job.Finished != nil {
+			close(watcher)
+		}
+	}
+	if job.Finished != nil {
+		job.watchers = nil
+	}
+}
+
+// watch returns a copy of the job id and, unless the job is finished, a channel receiving its
+// changes. stop must be called once the caller no longer reads the channel.
+func (s *jobStore) watch(id string) (job Job, changes <-chan Job, stop func(), ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.jobs[id]
+	if !ok {
+		return Job{}, nil, func() {}, false
+	}
+	if current.Finished != nil {
+		return *current, nil, func() {}, true
+	}
+
+	watcher := make(chan Job, 1)
+	if current.watchers == nil {
+		current.watchers = make(map[chan Job]struct{})
+	}
+	current.watchers[watcher] = struct{}{}
+	stop = func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(current.watchers, watcher)
+	}
+	return *current, watcher, stop, true
+}
+
+// get returns a copy of the job id, safe to encode while the job runs
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// cancel stops the job id if it is queued or running. It returns false if there is no such job.
+func (s *jobStore) cancel(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	switch job.State {
+	case jobQueued:
+		// The worker taking it passes over it
+		now := time.Now()
+		job.State = jobCanceled
+		job.Finished = &now
+		job.cancel()
+		s.publishLocked(job)
+	case jobRunning:
+		// The worker reports the cancellation once the compression stops
+		job.cancel()
+	}
+	return *job, true
+}
+
+// removeExpired forgets the jobs that finished before cutoff, their files are removed by cleanup
+func (s *jobStore) removeExpired(cutoff time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, job := range s.jobs {
+		if job.Finished != nil && job.Finished.Before(cutoff) {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// newJobID returns a random job ID
+func newJobID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// handleCreateJob starts compressing the uploaded files in the background. It takes the
+// form fields of /api/compress and responds with the job to poll at /api/jobs/{id}.
+func handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	req, ok := parseCompressRequest(w, r)
+	if !ok {
+		return
+	}
+
+	job, ok := jobs.submit(req)
+	if !ok {
+		respondWithJobError(w, http.StatusServiceUnavailable, "Too many jobs queued, try again later")
+		return
+	}
+	log.Printf("Job %s queued: %d files to %s", job.ID, len(req.sources), req.format)
+
+	snapshot, _ := jobs.get(job.ID)
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(JobResponse{Success: true, Job: &snapshot})
+}
+
+// handleGetJob reports the state, progress and result of a job
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	job, ok := jobs.get(mux.Vars(r)["id"])
+	if !ok {
+		respondWithJobError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	json.NewEncoder(w).Encode(JobResponse{Success: true, Job: &job})
+}
+
+// handleCancelJob cancels a queued or running job. Finished jobs are left as they are.
+func handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	job, ok := jobs.cancel(mux.Vars(r)["id"])
+	if !ok {
+		respondWithJobError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	switch job.State {
+	case jobRunning:
+		// The job is canceled once the compression stops
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(JobResponse{Success: true, Message: "Job cancellation requested", Job: &job})
+	case jobCanceled:
+		json.NewEncoder(w).Encode(JobResponse{Success: true, Message: "Job canceled", Job: &job})
+	default:
+		respondWithJobError(w, http.StatusConflict, "Job already finished")
+	}
+}
+
+// handleJobEvents streams the progress of a job as Server-Sent Events. A "progress" event carrying
+// a ProgressUpdate is sent on connection and whenever the progress changes, and a last event named
+// after the final state ("done", "failed" or "canceled") carries the job with its result or error.
+func handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		respondWithJobError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	job, changes, stop, ok := jobs.watch(mux.Vars(r)["id"])
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		respondWithJobError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if job.Finished != nil {
+		writeEvent(w, job.State, job)
+		flusher.Flush()
+		return
+	}
+	if err := writeEvent(w, "progress", job.Progress); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case changed, open := <-changes:
+			if !open {
+				return
+			}
+			if changed.Finished != nil {
+				writeEvent(w, changed.State, changed)
+				flusher.Flush()
+				return
+			}
+			if changed.Progress == job.Progress {
+				continue
+			}
+			job = changed
+			if err := writeEvent(w, "progress", job.Progress); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			// Comments keep proxies from closing an idle stream while a job waits in the queue
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes a Server-Sent Event with data encoded as JSON
+func writeEvent(w io.Writer, name string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, encoded)
+	return err
+}
+
+// respondWithJobError sends an error response of the job endpoints
+func respondWithJobError(w http.ResponseWriter, code int, message string) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(JobResponse{Success: false, Message: message})
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// submitJob posts a compression job of the files and returns it as accepted
+func submitJob(t *testing.T, server string, fields map[string]string, files ...uploadFile) Job {
+	t.Helper()
+	resp := postForm(t, server+"/api/jobs", fields, files...)
+	if location := resp.Header.Get("Location"); resp.StatusCode == http.StatusAccepted && location == "" {
+		t.Error("accepted job without a Location header")
+	}
+	var created JobResponse
+	decodeResponse(t, resp, http.StatusAccepted, &created)
+	if created.Job == nil || created.Job.ID == "" {
+		t.Fatalf("response %+v without a job", created)
+	}
+	return *created.Job
+}
+
+// getJob returns the job id as reported by the API
+func getJob(t *testing.T, server, id string) Job {
+	t.Helper()
+	var resp JobResponse
+	decodeResponse(t, sendRequest(t, http.MethodGet, server+"/api/jobs/"+id), http.StatusOK, &resp)
+	return *resp.Job
+}
+
+// waitForJob polls the job id until it is finished
+func waitForJob(t *testing.T, server, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if job := getJob(t, server, id); job.Finished != nil {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s still not finished", id)
+	return Job{}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	server := newTestServer(t).URL
+
+	job := submitJob(t, server, map[string]string{"format": "zip"}, uploadFile{"notes.txt", []byte("notes\n")})
+	if job.State != jobQueued {
+		t.Errorf("new job is %s, want %s", job.State, jobQueued)
+	}
+
+	jobs.startJobWorkers(1)
+	job = waitForJob(t, server, job.ID)
+	if job.State != jobDone || job.Result == nil || job.Error != "" {
+		t.Fatalf("job %+v, want done with a result", job)
+	}
+	if _, err := os.Stat("compressed/" + job.Result.ArchiveID); err != nil {
+		t.Errorf("archive of the job: %v", err)
+	}
+
+	// Finished jobs cannot be canceled
+	var resp JobResponse
+	decodeResponse(t, sendRequest(t, http.MethodDelete, server+"/api/jobs/"+job.ID), http.StatusConflict, &resp)
+	decodeResponse(t, sendRequest(t, http.MethodGet, server+"/api/jobs/unknown"), http.StatusNotFound, &resp)
+}
+
+func TestCancelQueuedJob(t *testing.T) {
+	server := newTestServer(t).URL
+
+	job := submitJob(t, server, map[string]string{"format": "zip"}, uploadFile{"notes.txt", []byte("notes\n")})
+	var resp JobResponse
+	decodeResponse(t, sendRequest(t, http.MethodDelete, server+"/api/jobs/"+job.ID), http.StatusOK, &resp)
+	if resp.Job.State != jobCanceled || resp.Job.Finished == nil {
+		t.Fatalf("job %+v, want canceled", resp.Job)
+	}
+
+	// The worker taking the job from the queue passes over it
+	jobs.startJobWorkers(1)
+	next := submitJob(t, server, map[string]string{"format": "zip"}, uploadFile{"notes.txt", []byte("notes\n")})
+	waitForJob(t, server, next.ID)
+	if job := getJob(t, server, job.ID); job.State != jobCanceled || job.Result != nil {
+		t.Errorf("job %+v, want canceled without a result", job)
+	}
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	server := newTestServer(t).URL
+
+	// Random data compresses slowly enough to be canceled halfway
+	data := make([]byte, 16<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	job := submitJob(t, server, map[string]string{"format": "tar.gz", "level": "maximum"}, uploadFile{"random.bin", data})
+
+	_, changes, stop, ok := jobs.watch(job.ID)
+	if !ok {
+		t.Fatal("submitted job not found")
+	}
+	defer stop()
+	jobs.startJobWorkers(1)
+	for changed := range changes {
+		if changed.State == jobRunning {
+			break
+		}
+	}
+
+	var resp JobResponse
+	decodeResponse(t, sendRequest(t, http.MethodDelete, server+"/api/jobs/"+job.ID), http.StatusAccepted, &resp)
+	job = waitForJob(t, server, job.ID)
+	if job.State != jobCanceled || job.Result != nil {
+		t.Fatalf("job %+v, want canceled without a result", job)
+	}
+	if entries, err := os.ReadDir("compressed"); err != nil || len(entries) != 0 {
+		t.Errorf("compressed directory holds %v (%v), want nothing", entries, err)
+	}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	newTestServer(t)
+	for i := 0; i < maxQueuedJobs; i++ {
+		if _, ok := jobs.submit(&compressRequest{}); !ok {
+			t.Fatalf("job %d refused, want %d queued", i+1, maxQueuedJobs)
+		}
+	}
+	if _, ok := jobs.submit(&compressRequest{}); ok {
+		t.Error("job queued beyond the limit")
+	}
+}
+
+// jobEvent is a Server-Sent Event of /api/jobs/{id}/events
+type jobEvent struct {
+	name string
+	data string
+}
+
+// nextEvent reads the next event of a stream, skipping comments
+func nextEvent(r *bufio.Reader) (jobEvent, error) {
+	var event jobEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return event, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event.name != "":
+			return event, nil
+		case strings.HasPrefix(line, "event: "):
+			event.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// openEvents opens the event stream of the job id
+func openEvents(t *testing.T, server, id string) *bufio.Reader {
+	t.Helper()
+	resp := sendRequest(t, http.MethodGet, server+"/api/jobs/"+id+"/events")
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("events: status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func TestJobEventsOrder(t *testing.T) {
+	server := newTestServer(t).URL
+	job := submitJob(t, server, map[string]string{"format": "tar.gz"}, uploadFile{"a.txt", []byte("a")}, uploadFile{"b.txt", []byte("b")})
+
+	// The progress of the queued job is sent on connection
+	events := openEvents(t, server, job.ID)
+	first, err := nextEvent(events)
+	if err != nil || first.name != "progress" {
+		t.Fatalf("first event %+v (%v), want progress", first, err)
+	}
+
+	jobs.startJobWorkers(1)
+	var received []jobEvent
+	for {
+		event, err := nextEvent(events)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, event)
+	}
+
+	// Progress only grows until the last event, which closes the stream
+	if len(received) == 0 {
+		t.Fatal("stream closed without a final event")
+	}
+	last := received[len(received)-1]
+	percentage := 0.0
+	for _, event := range received[:len(received)-1] {
+		var progress ProgressUpdate
+		if err := json.Unmarshal([]byte(event.data), &progress); event.name != "progress" || err != nil {
+			t.Fatalf("event %+v (%v) before the final one, want progress", event, err)
+		}
+		if progress.Percentage < percentage {
+			t.Errorf("progress went back from %.1f%% to %.1f%%", percentage, progress.Percentage)
+		}
+		percentage = progress.Percentage
+	}
+	var finished Job
+	if err := json.Unmarshal([]byte(last.data), &finished); last.name != jobDone || err != nil || finished.Result == nil {
+		t.Fatalf("final event %+v (%v), want done with the result", last, err)
+	}
+
+	// A finished job sends its final event alone
+	events = openEvents(t, server, job.ID)
+	if event, err := nextEvent(events); err != nil || event.name != jobDone {
+		t.Errorf("event of the finished job %+v (%v), want done", event, err)
+	}
+	if event, err := nextEvent(events); err != io.EOF {
+		t.Errorf("event %+v (%v) after the final one, want the end of the stream", event, err)
+	}
+}
+
+func TestJobEventsCanceled(t *testing.T) {
+	server := newTestServer(t).URL
+	job := submitJob(t, server, map[string]string{"format": "zip"}, uploadFile{"a.txt", []byte("a")})
+
+	events := openEvents(t, server, job.ID)
+	if event, err := nextEvent(events); err != nil || event.name != "progress" {
+		t.Fatalf("first event %+v (%v), want progress", event, err)
+	}
+	var resp JobResponse
+	decodeResponse(t, sendRequest(t, http.MethodDelete, server+"/api/jobs/"+job.ID), http.StatusOK, &resp)
+
+	event, err := nextEvent(events)
+	var canceled Job
+	if err == nil {
+		err = json.Unmarshal([]byte(event.data), &canceled)
+	}
+	if event.name != jobCanceled || err != nil || canceled.State != jobCanceled {
+		t.Errorf("event %+v (%v), want canceled", event, err)
+	}
+	if event, err := nextEvent(events); err != io.EOF {
+		t.Errorf("event %+v (%v) after the final one, want the end of the stream", event, err)
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -13,6 +16,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/latreon/file-compressor/pkg/archiver"
+	"github.com/latreon/file-compressor/pkg/utils"
 	"github.com/rs/cors"
 )
 
@@ -20,7 +24,9 @@ import (
 const (
 	uploadDir       = "./uploads"
 	compressedDir   = "./compressed"
+	extractedDir    = "./extracted"
 	maxUploadSize   = 1024 * 1024 * 100 // 100MB
+	maxBufferSize   = 1024 * 1024 * 64  // 64MB, upper bound for the bufferSize form field
 	serverPort      = 8080
 	cleanupInterval = 1 * time.Hour // Cleanup uploaded files after 1 hour
 )
@@ -29,11 +35,33 @@ type CompressResponse struct {
 	Success      bool   `json:"success"`
 	Message      string `json:"message,omitempty"`
 	DownloadLink string `json:"downloadLink,omitempty"`
+	ArchiveID    string `json:"archiveId,omitempty"`
 	OutputSize   int64  `json:"outputSize,omitempty"`
 	InputSize    int64  `json:"inputSize,omitempty"`
 }
 
-// Progress structure for websocket updates
+// TestResponse reports the integrity of an uploaded archive
+type TestResponse struct {
+	Success  bool          `json:"success"`
+	Valid    bool          `json:"valid"`
+	Message  string        `json:"message,omitempty"`
+	Tested   int           `json:"tested"`
+	Failures []TestFailure `json:"failures,omitempty"`
+}
+
+// TestFailure is a damaged entry of a tested archive
+type TestFailure struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// EntriesResponse lists the contents of a compressed archive
+type EntriesResponse struct {
+	Success bool             `json:"success"`
+	Entries []archiver.Entry `json:"entries"`
+}
+
+// ProgressUpdate is the progress of a job, streamed by /api/jobs/{id}/events
 type ProgressUpdate struct {
 	Percentage float64 `json:"percentage"`
 	Stage      string  `json:"stage"`
@@ -47,31 +75,46 @@ func main() {
 	// Start cleanup routine
 	go cleanupRoutine()
 
-	// Create router and add routes
-	r := mux.NewRouter()
-
-	// API routes
-	r.HandleFunc("/api/compress", handleCompressFile).Methods("POST")
-	r.HandleFunc("/api/formats", handleGetFormats).Methods("GET")
-	r.HandleFunc("/download/{filename}", handleDownload).Methods("GET")
-
-	// Serve the Next.js app later (if we want to serve it from the same Go server)
-	// r.PathPrefix("/").Handler(http.FileServer(http.Dir("./web-ui/out")))
+	// Start the workers of the compression jobs
+	jobs.startJobWorkers(jobWorkers)
 
 	// Apply CORS middleware
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000"},
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Content-Type", "Authorization"},
 		AllowCredentials: true,
 	})
-	handler := c.Handler(r)
+	handler := c.Handler(newRouter())
 
 	// Start server
 	fmt.Printf("API server running at http://localhost:%d\n", serverPort)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", serverPort), handler))
 }
 
+// newRouter returns the routes of the API and of the downloads
+func newRouter() *mux.Router {
+	r := mux.NewRouter()
+
+	// API routes
+	r.HandleFunc("/api/compress", handleCompressFile).Methods("POST")
+	r.HandleFunc("/api/formats", handleGetFormats).Methods("GET")
+	r.HandleFunc("/api/archives/{id}/entries", handleListEntries).Methods("GET")
+	r.HandleFunc("/api/test", handleTestArchive).Methods("POST")
+	r.HandleFunc("/api/extract", handleExtractArchive).Methods("POST")
+	r.HandleFunc("/api/jobs", handleCreateJob).Methods("POST")
+	r.HandleFunc("/api/jobs/{id}", handleGetJob).Methods("GET")
+	r.HandleFunc("/api/jobs/{id}", handleCancelJob).Methods("DELETE")
+	r.HandleFunc("/api/jobs/{id}/events", handleJobEvents).Methods("GET")
+	r.HandleFunc("/download/{filename}", handleDownload).Methods("GET")
+	r.HandleFunc("/download/{id}/{path:.+}", handleDownloadExtracted).Methods("GET")
+
+	// Serve the Next.js app later (if we want to serve it from the same Go server)
+	// r.PathPrefix("/").Handler(http.FileServer(http.Dir("./web-ui/out")))
+
+	return r
+}
+
 // ensureDirectories creates necessary directories if they don't exist
 func ensureDirectories() {
 	log.Println("Creating necessary directories...")
@@ -96,6 +139,11 @@ func ensureDirectories() {
 	}
 	log.Printf("Compressed directory created at: %s", absCompressedPath)
 
+	// Create extracted directory
+	if err := os.MkdirAll(extractedDir, 0755); err != nil {
+		log.Fatalf("Could not create extracted directory: %v", err)
+	}
+
 	// Verify write permissions
 	testUploadPath := filepath.Join(uploadDir, "test_write_permissions.txt")
 	testFile, err := os.Create(testUploadPath)
@@ -124,10 +172,12 @@ func cleanupRoutine() {
 	}
 }
 
-// cleanup removes files older than the cleanup interval
+// cleanup removes files and extractions older than the cleanup interval, and the jobs that produced them
 func cleanup() {
 	removeOldFiles(uploadDir)
 	removeOldFiles(compressedDir)
+	removeOldExtractions(extractedDir)
+	jobs.removeExpired(time.Now().Add(-cleanupInterval))
 }
 
 // removeOldFiles deletes files older than the cleanup interval
@@ -153,151 +203,423 @@ func removeOldFiles(dir string) {
 	}
 }
 
+// removeOldExtractions deletes the extraction directories created before the cleanup interval.
+// Their files keep the times stored in the archive, so only the directory itself is dated.
+func removeOldExtractions(dir string) {
+	cutoff := time.Now().Add(-cleanupInterval)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Error reading directory %s: %v", dir, err)
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("Error removing old extraction %s: %v", path, err)
+		} else {
+			log.Printf("Removed old extraction: %s", path)
+		}
+	}
+}
+
 // handleGetFormats returns the supported compression formats
 func handleGetFormats(w http.ResponseWriter, r *http.Request) {
-	formats := []string{"pdf", "zip", "png", "jpg", "jpeg"}
+	formats := archiver.FormatNames(archiver.CanCompress)
+
+	// There is no one to ask about conflicts over HTTP
+	var overwrite []string
+	for _, name := range archiver.OverwritePolicyNames() {
+		if name != archiver.OverwriteAsk.String() {
+			overwrite = append(overwrite, name)
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"formats": formats,
+		"formats":   formats,
+		"encrypted": archiver.FormatNames(archiver.CanEncrypt),
+		"extract":   archiver.FormatNames(archiver.CanExtract),
+		"repack":    archiver.FormatNames(archiver.CanCompress | archiver.CanBundle),
+		"levels":    archiver.LevelNames(),
+		"methods":   []string{"deflate", "store"},
+		"overwrite": overwrite,
 	})
 }
 
-// handleCompressFile compresses an uploaded file
+// compressRequest is a validated compression of uploaded files, run by handleCompressFile or by a job
+type compressRequest struct {
+	sources        []archiver.Source
+	format         string
+	opts           archiver.CompressOptions
+	outputFilename string
+	inputSize      int64
+}
+
+// handleCompressFile compresses an uploaded file, or bundles several "file" fields into one archive.
+// Optional "prefix" fields, in the order of the files, set their paths in the archive.
 func handleCompressFile(w http.ResponseWriter, r *http.Request) {
 	// Set JSON content type
 	w.Header().Set("Content-Type", "application/json")
 
+	req, ok := parseCompressRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Create progress tracker, logging stages, file ratios and at most one update per second
+	progressTracker := archiver.NewProgressTracker(logProgress, time.Second)
+
+	// A client that disconnects cancels the request context and stops the compression
+	resp, err := runCompression(r.Context(), req, progressTracker)
+	if errors.Is(err, context.Canceled) {
+		// Nobody is left to read the response
+		return
+	}
+	if err != nil {
+		code, message := compressionError(err)
+		respondWithError(w, code, message)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// parseCompressRequest saves the uploaded files of a compression request and checks its
+// options. It responds with an error and returns false if the request is invalid.
+func parseCompressRequest(w http.ResponseWriter, r *http.Request) (*compressRequest, bool) {
 	// Enforce size limit
 	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
 		log.Printf("Error parsing multipart form: %v", err)
 		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("File too large or invalid form: %v", err))
-		return
+		return nil, false
 	}
 
-	// Get the file from the request
-	file, handler, err := r.FormFile("file")
+	// Get the files from the request
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		log.Printf("Error retrieving file: %v", http.ErrMissingFile)
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Error retrieving the file: %v", http.ErrMissingFile))
+		return nil, false
+	}
+	prefixes := r.MultipartForm.Value["prefix"]
+	if len(prefixes) > len(files) {
+		respondWithError(w, http.StatusBadRequest, "More prefixes than files")
+		return nil, false
+	}
+
+	// Generate secure uploaded filenames (using timestamp to avoid collisions)
+	timestamp := time.Now().UnixNano()
+	var sources []archiver.Source
+	var inputSize int64
+	for i, handler := range files {
+		log.Printf("Received file: %s (%d bytes)", handler.Filename, handler.Size)
+
+		// Uploads with the same name are numbered apart, they are stored under their own name
+		filename := handler.Filename
+		if len(files) > 1 {
+			filename = fmt.Sprintf("%d_%s", i, filename)
+		}
+		uploadPath, err := saveFormFile(handler, filename, timestamp)
+		if err != nil {
+			log.Printf("Error saving uploaded file: %v", err)
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error saving the file: %v", err))
+			return nil, false
+		}
+
+		source := archiver.Source{Path: uploadPath, Prefix: filepath.Base(handler.Filename)}
+		if i < len(prefixes) && prefixes[i] != "" {
+			source.Prefix = prefixes[i]
+		}
+		sources = append(sources, source)
+
+		// Get the original file size
+		fileInfo, err := os.Stat(uploadPath)
+		if err != nil {
+			log.Printf("Error reading file info: %v", err)
+			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error reading file info: %v", err))
+			return nil, false
+		}
+		inputSize += fileInfo.Size()
+	}
+
+	// Sniff the uploaded content of a single file, the file name is only used as a hint
+	var detected archiver.Format
+	detectErr := errors.New("several files uploaded")
+	if len(sources) == 1 {
+		detected, detectErr = archiver.DetectFormat(sources[0].Path)
+	}
+
+	// Compression options from the form, empty fields keep the defaults
+	opts, err := compressOptionsFromForm(r)
 	if err != nil {
-		log.Printf("Error retrieving file: %v", err)
-		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Error retrieving the file: %v", err))
-		return
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return nil, false
 	}
-	defer file.Close()
 
 	// Get compression format from form, if not specified, determine from file type
 	format := r.FormValue("format")
 	if format == "" {
-		// If no format specified (auto-select), use the original file extension
-		ext := strings.ToLower(filepath.Ext(handler.Filename))
-		if ext != "" {
-			format = ext[1:] // Remove the dot from extension
+		// If no format specified (auto-select), keep the detected format when it can be written
+		requiredCaps := archiver.CanCompress
+		if opts.Password != "" {
+			requiredCaps |= archiver.CanEncrypt
+		}
+		if detectErr == nil && detected.Capabilities()&requiredCaps == requiredCaps {
+			format = detected.Name()
 		} else {
-			format = "zip" // Fallback to zip if no extension
+			format = "zip" // Fallback to zip for unrecognized content
 		}
 	}
 
 	log.Printf("Using compression format: %s", format)
 
-	// Check if the file is a PDF when PDF compression is selected
-	if format == "pdf" && !strings.HasSuffix(strings.ToLower(handler.Filename), ".pdf") {
-		respondWithError(w, http.StatusBadRequest, "PDF compression can only be used with PDF files")
-		return
+	// Reject formats the archiver doesn't know about
+	selected, ok := archiver.Lookup(format)
+	if !ok || selected.Capabilities()&archiver.CanCompress == 0 {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Unsupported compression format: %s", format))
+		return nil, false
 	}
 
-	// Check if the file is an image when image compression is selected
-	if (format == "png" || format == "jpg" || format == "jpeg") && 
-	   !strings.HasSuffix(strings.ToLower(handler.Filename), "."+format) {
-		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s compression can only be used with %s files", format, format))
-		return
+	if len(sources) > 1 && selected.Capabilities()&archiver.CanBundle == 0 {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s compression takes a single file", strings.ToUpper(selected.Name())))
+		return nil, false
 	}
 
-	log.Printf("Received file: %s (%d bytes)", handler.Filename, handler.Size)
+	if opts.Password != "" && selected.Capabilities()&archiver.CanEncrypt == 0 {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s archives cannot be password protected", strings.ToUpper(selected.Name())))
+		return nil, false
+	}
 
-	// Generate secure uploaded filename (using timestamp to avoid collisions)
-	timestamp := time.Now().UnixNano()
-	uploadPath := filepath.Join(uploadDir, fmt.Sprintf("%d_%s", timestamp, handler.Filename))
+	// PDF and image compression can only be applied to files of the same type
+	if selected.Capabilities()&archiver.CanExtract == 0 && (detectErr != nil || detected.Name() != selected.Name()) {
+		name := strings.ToUpper(selected.Name())
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("%s compression can only be used with %s files", name, name))
+		return nil, false
+	}
 
-	// Save the uploaded file
-	outFile, err := os.Create(uploadPath)
+	// Generate output filename with "compressed" prefix, named after the first file
+	baseName := strings.TrimSuffix(files[0].Filename, filepath.Ext(files[0].Filename))
+	return &compressRequest{
+		sources:        sources,
+		format:         format,
+		opts:           opts,
+		outputFilename: fmt.Sprintf("%d_%s_compressed.%s", timestamp, baseName, format),
+		inputSize:      inputSize,
+	}, true
+}
+
+// runCompression compresses the files of req until ctx is canceled and describes the result
+func runCompression(ctx context.Context, req *compressRequest, progressTracker *archiver.ProgressTracker) (CompressResponse, error) {
+	outputPath := filepath.Join(compressedDir, req.outputFilename)
+
+	// Compress the files
+	log.Printf("Compressing %d files to %s with format %s (level %s)", len(req.sources), outputPath, req.format, req.opts.Level)
+	err := archiver.CompressSourcesContext(ctx, req.sources, outputPath, req.format, req.opts, progressTracker)
 	if err != nil {
-		log.Printf("Error creating temporary file: %v", err)
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error saving the file: %v", err))
+		log.Printf("Error compressing file: %v", err)
+		return CompressResponse{}, err
+	}
+
+	// Get the compressed file size
+	compressedInfo, err := os.Stat(outputPath)
+	if err != nil {
+		log.Printf("Error reading compressed file info: %v", err)
+		return CompressResponse{}, fmt.Errorf("error reading compressed file info: %w", err)
+	}
+	outputSize := compressedInfo.Size()
+
+	log.Printf("Successfully compressed %d files to %s. Original: %d bytes, Compressed: %d bytes",
+		len(req.sources), outputPath, req.inputSize, outputSize)
+
+	// Return success response with the download link
+	return CompressResponse{
+		Success:      true,
+		Message:      "File compressed successfully",
+		DownloadLink: fmt.Sprintf("/download/%s", req.outputFilename),
+		ArchiveID:    req.outputFilename,
+		InputSize:    req.inputSize,
+		OutputSize:   outputSize,
+	}, nil
+}
+
+// compressionError returns the status code and message reporting a failed compression
+func compressionError(err error) (int, string) {
+	// Try to get more detailed error information
+	if errors.Is(err, archiver.ErrDuplicateEntry) {
+		return http.StatusBadRequest, err.Error()
+	} else if os.IsNotExist(err) {
+		return http.StatusInternalServerError, "Source file not found"
+	} else if os.IsPermission(err) {
+		return http.StatusInternalServerError, "Permission denied while compressing file"
+	}
+	return http.StatusInternalServerError, fmt.Sprintf("Error compressing file: %v", err)
+}
+
+// logProgress logs the stages, file ratios and progress updates of a compression
+func logProgress(event archiver.ProgressEvent) {
+	switch event.Kind {
+	case archiver.StageStarted:
+		log.Printf("Compression stage %d/%d: %s", event.StageIndex, event.StageCount, event.Stage)
+	case archiver.FileFinished:
+		if ratio := event.FileRatio(); ratio > 0 {
+			log.Printf("Compressed %s: %d -> %d bytes (%.1f%%)", event.File, event.FileSize, event.FileStoredSize, ratio*100)
+		}
+	case archiver.ProgressUpdate:
+		if event.BytesTotal > 0 {
+			log.Printf("Compression progress: %.2f%% (%d/%d bytes, %d/%d files, %.0f B/s, ETA %s)",
+				event.Percent(), event.BytesDone, event.BytesTotal, event.FilesDone, event.FilesTotal,
+				event.Speed, event.ETA.Round(time.Second))
+		}
+	}
+}
+
+// handleTestArchive checks the integrity of an uploaded archive without extracting it
+func handleTestArchive(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	// Enforce size limit
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		log.Printf("Error parsing multipart form: %v", err)
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("File too large or invalid form: %v", err))
 		return
 	}
-	defer outFile.Close()
 
-	// Copy the file to our local file
-	_, err = io.Copy(outFile, file)
+	file, handler, err := r.FormFile("file")
 	if err != nil {
-		log.Printf("Error saving uploaded file: %v", err)
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error saving the file: %v", err))
+		log.Printf("Error retrieving file: %v", err)
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Error retrieving the file: %v", err))
 		return
 	}
+	defer file.Close()
 
-	// Get the original file size
-	fileInfo, err := os.Stat(uploadPath)
+	// The upload is only needed while it is tested
+	uploadPath, err := saveUpload(file, handler.Filename, time.Now().UnixNano())
 	if err != nil {
-		log.Printf("Error reading file info: %v", err)
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error reading file info: %v", err))
+		log.Printf("Error saving uploaded file: %v", err)
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error saving the file: %v", err))
 		return
 	}
-	inputSize := fileInfo.Size()
+	defer os.Remove(uploadPath)
 
-	// Generate output filename with "compressed" prefix
-	baseName := strings.TrimSuffix(handler.Filename, filepath.Ext(handler.Filename))
-	outputFilename := fmt.Sprintf("%d_%s_compressed.%s", timestamp, baseName, format)
-	outputPath := filepath.Join(compressedDir, outputFilename)
+	if f, err := archiver.DetectFormat(uploadPath); err != nil || f.Capabilities()&archiver.CanExtract == 0 {
+		respondWithError(w, http.StatusBadRequest, "File is not an archive that can be tested")
+		return
+	}
 
-	// Create progress tracker
-	progressTracker := archiver.NewProgressCallback(func(bytesWritten, totalSize int64) {
-		if totalSize > 0 {
-			percentage := float64(bytesWritten) / float64(totalSize) * 100
-			log.Printf("Compression progress: %.2f%% (%d/%d bytes)", percentage, bytesWritten, totalSize)
+	result, err := archiver.TestWithLimits(uploadPath, r.FormValue("password"), checkLimits)
+	if errors.Is(err, archiver.ErrLimitExceeded) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	resp := TestResponse{Success: true, Valid: err == nil && result.OK()}
+	if result != nil {
+		resp.Tested = result.Tested
+		for _, failure := range result.Failures {
+			resp.Failures = append(resp.Failures, TestFailure{Name: failure.Name, Error: failure.Err.Error()})
 		}
-	})
+	}
+	if err != nil {
+		resp.Message = err.Error()
+	}
+	log.Printf("Tested %s: %d entries, %d failures, valid: %t", handler.Filename, resp.Tested, len(resp.Failures), resp.Valid)
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// saveUpload copies an uploaded file to the upload directory, prefixing its name with timestamp
+func saveUpload(file io.Reader, filename string, timestamp int64) (string, error) {
+	uploadPath := filepath.Join(uploadDir, fmt.Sprintf("%d_%s", timestamp, filepath.Base(filename)))
 
-	// Compress the file
-	log.Printf("Compressing file from %s to %s with format %s", uploadPath, outputPath, format)
-	err = archiver.CompressWithProgress(uploadPath, outputPath, format, progressTracker)
+	outFile, err := os.Create(uploadPath)
 	if err != nil {
-		log.Printf("Error compressing file: %v", err)
-		// Try to get more detailed error information
-		if os.IsNotExist(err) {
-			respondWithError(w, http.StatusInternalServerError, "Source file not found")
-		} else if os.IsPermission(err) {
-			respondWithError(w, http.StatusInternalServerError, "Permission denied while compressing file")
-		} else {
-			respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error compressing file: %v", err))
-		}
-		return
+		return "", err
 	}
+	defer outFile.Close()
 
-	// Get the compressed file size
-	compressedInfo, err := os.Stat(outputPath)
+	if _, err := io.Copy(outFile, file); err != nil {
+		return "", err
+	}
+	return uploadPath, nil
+}
+
+// saveFormFile saves an uploaded file of a multipart form like saveUpload
+func saveFormFile(handler *multipart.FileHeader, filename string, timestamp int64) (string, error) {
+	file, err := handler.Open()
 	if err != nil {
-		log.Printf("Error reading compressed file info: %v", err)
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error reading compressed file info: %v", err))
+		return "", err
+	}
+	defer file.Close()
+	return saveUpload(file, filename, timestamp)
+}
+
+// compressOptionsFromForm reads the optional "level", "method", "bufferSize" and "password" form fields
+func compressOptionsFromForm(r *http.Request) (archiver.CompressOptions, error) {
+	var opts archiver.CompressOptions
+	var err error
+	if opts.Level, err = archiver.ParseLevel(r.FormValue("level")); err != nil {
+		return opts, err
+	}
+	if opts.Method, err = archiver.ParseMethod(r.FormValue("method")); err != nil {
+		return opts, err
+	}
+	if value := r.FormValue("bufferSize"); value != "" {
+		size, err := utils.ParseSize(value)
+		if err != nil || size > maxBufferSize {
+			return opts, fmt.Errorf("invalid buffer size: %s", value)
+		}
+		opts.BufferSize = int(size)
+	}
+	opts.Password = r.FormValue("password")
+	return opts, nil
+}
+
+// handleListEntries lists the entries of a compressed archive. The archive ID is
+// the file name returned as archiveId by the compress endpoint.
+func handleListEntries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := mux.Vars(r)["id"]
+
+	// Validate the ID to prevent directory traversal
+	if filepath.Base(id) != id {
+		respondWithError(w, http.StatusBadRequest, "Invalid archive ID")
 		return
 	}
-	outputSize := compressedInfo.Size()
 
-	log.Printf("Successfully compressed %s to %s. Original: %d bytes, Compressed: %d bytes",
-		handler.Filename, outputPath, inputSize, outputSize)
+	archivePath := filepath.Join(compressedDir, id)
+	if _, err := os.Stat(archivePath); os.IsNotExist(err) {
+		respondWithError(w, http.StatusNotFound, "Archive not found")
+		return
+	}
 
-	// Create download link
-	downloadLink := fmt.Sprintf("/download/%s", outputFilename)
+	// Compressed PDFs and images have no entries
+	if f, err := archiver.DetectFormat(archivePath); err != nil || f.Capabilities()&archiver.CanList == 0 {
+		respondWithError(w, http.StatusBadRequest, "File is not an archive that can be listed")
+		return
+	}
 
-	// Return success response
-	resp := CompressResponse{
-		Success:      true,
-		Message:      "File compressed successfully",
-		DownloadLink: downloadLink,
-		InputSize:    inputSize,
-		OutputSize:   outputSize,
+	entries, err := archiver.ListWithLimits(archivePath, checkLimits)
+	if errors.Is(err, archiver.ErrLimitExceeded) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error listing archive %s: %v", id, err)
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Error listing archive: %v", err))
+		return
 	}
 
-	json.NewEncoder(w).Encode(resp)
+	json.NewEncoder(w).Encode(EntriesResponse{
+		Success: true,
+		Entries: entries,
+	})
 }
 
 // handleDownload serves a compressed file for download
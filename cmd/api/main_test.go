@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// The handlers log every request
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTestServer serves the API from the working directories of a temporary directory,
+// with an empty job store whose workers are started by the test
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	ensureDirectories()
+
+	previous := jobs
+	jobs = &jobStore{jobs: make(map[string]*Job), queue: make(chan *Job, maxQueuedJobs)}
+	t.Cleanup(func() { jobs = previous })
+
+	server := httptest.NewServer(newRouter())
+	t.Cleanup(server.Close)
+	return server
+}
+
+// uploadFile is a file field of a multipart request
+type uploadFile struct {
+	name    string
+	content []byte
+}
+
+// postForm posts the fields and files, all named "file", as a multipart form to url
+func postForm(t *testing.T, url string, fields map[string]string, files ...uploadFile) *http.Response {
+	t.Helper()
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if err := writer.WriteField(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range files {
+		part, err := writer.CreateFormFile("file", file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := part.Write(file.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(url, writer.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// sendRequest sends a request without a body to url
+func sendRequest(t *testing.T, method, url string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// decodeResponse checks the status code of resp and decodes its JSON body into v
+func decodeResponse(t *testing.T, resp *http.Response, status int, v any) {
+	t.Helper()
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: status %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, status, body)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatalf("invalid response %s: %v", body, err)
+	}
+}
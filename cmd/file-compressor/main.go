@@ -1,11 +1,19 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/latreon/file-compressor/pkg/archiver"
+	"github.com/latreon/file-compressor/pkg/utils"
 )
 
 func main() {
@@ -23,51 +31,454 @@ func main() {
 	command := os.Args[1]
 	switch command {
 	case "compress":
-		if len(os.Args) < 4 {
-			fmt.Println("Insufficient arguments for compression")
-			printUsage()
-			return
+		compressCommand(os.Args[2:])
+
+	case "extract":
+		extractCommand(os.Args[2:])
+
+	case "list":
+		listCommand(os.Args[2:])
+
+	case "test":
+		testCommand(os.Args[2:])
+
+	default:
+		fmt.Printf("Unknown command: %s\n", command)
+		printUsage()
+	}
+}
+
+// compressCommand runs "compress <archive> <source>... [options]"
+// or "compress <source> <destination> [format] [options]"
+func compressCommand(args []string) {
+	fs := flag.NewFlagSet("compress", flag.ExitOnError)
+	format := fs.String("format", "", "output format (default: the archive extension or positional format argument, else zip)")
+	level := fs.String("level", "", "compression level: "+strings.Join(archiver.LevelNames(), ", ")+" (default maximum)")
+	method := fs.String("method", "", "force the ZIP entry method: deflate or store")
+	bufferSize := fs.String("buffer-size", "", "copy buffer size, e.g. 512K or 8M (default 4M)")
+	password := fs.String("password", "", "encrypt the archive with AES-256 (ZIP only)")
+	workers := fs.Int("workers", 0, "number of files compressed in parallel (ZIP only, default: number of CPUs)")
+	var opts archiver.CompressOptions
+	fs.Var((*stringList)(&opts.Include), "include", "compress only files matching the pattern (repeatable)")
+	fs.Var((*stringList)(&opts.Exclude), "exclude", "leave out files and directories matching the pattern (repeatable)")
+	var excludeFrom stringList
+	fs.Var(&excludeFrom, "exclude-from", "leave out the patterns listed in the file, one per line (repeatable)")
+	fs.BoolVar(&opts.UseIgnoreFiles, "use-ignore-files", false, "leave out what .gitignore and .compressignore files ignore, and .git directories")
+	minSize := fs.String("min-size", "", "leave out files smaller than this size, e.g. 1K")
+	maxSize := fs.String("max-size", "", "leave out files larger than this size, e.g. 10M")
+	newerThan := fs.String("newer-than", "", "compress only files modified after this date or age, e.g. 2024-01-31 or 7d")
+	olderThan := fs.String("older-than", "", "compress only files modified before this date or age")
+	fs.Usage = printUsage
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) < 2 {
+		fmt.Println("Insufficient arguments for compression")
+		printUsage()
+		return
+	}
+	sources, destPath, positionalFormat := compressArguments(positional)
+
+	// Default to the format of the archive name, or ZIP if not specified
+	if *format == "" {
+		*format = positionalFormat
+	}
+
+	var err error
+	if opts.Level, err = archiver.ParseLevel(*level); err != nil {
+		log.Fatal(err)
+	}
+	if opts.Method, err = archiver.ParseMethod(*method); err != nil {
+		log.Fatal(err)
+	}
+	if *bufferSize != "" {
+		size, err := utils.ParseSize(*bufferSize)
+		if err != nil {
+			log.Fatalf("Invalid buffer size: %v", err)
 		}
-		sourcePath := os.Args[2]
-		destPath := os.Args[3]
+		opts.BufferSize = int(size)
+	}
+	opts.Password = *password
+	opts.Workers = *workers
 
-		// Default to ZIP format if not specified
+	for _, file := range excludeFrom {
+		patterns, err := archiver.ReadPatterns(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Exclude = append(opts.Exclude, patterns...)
+	}
+	if *minSize != "" {
+		if opts.MinSize, err = utils.ParseSize(*minSize); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *maxSize != "" {
+		if opts.MaxSize, err = utils.ParseSize(*maxSize); err != nil {
+			log.Fatal(err)
+		}
+	}
+	now := time.Now()
+	if *newerThan != "" {
+		if opts.ModifiedAfter, err = utils.ParseTime(*newerThan, now); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *olderThan != "" {
+		if opts.ModifiedBefore, err = utils.ParseTime(*olderThan, now); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	err = archiver.CompressSources(sources, destPath, *format, opts)
+	if err != nil {
+		log.Fatalf("Compression failed: %v", err)
+	}
+	fmt.Println("Compression completed successfully")
+}
+
+// compressArguments splits the positional arguments of compress into the sources, the archive
+// and the format. With a single source and a destination, optionally followed by a format, the
+// contents of a directory are stored at the root of the archive. Otherwise the archive comes
+// first and every source is stored under its name, or under prefix for "path=prefix".
+func compressArguments(positional []string) ([]archiver.Source, string, string) {
+	if !archiveFirst(positional) {
 		format := "zip"
-		if len(os.Args) > 4 {
-			format = os.Args[4]
+		if len(positional) > 2 {
+			format = positional[2]
 		}
+		return []archiver.Source{{Path: positional[0], Prefix: "."}}, positional[1], format
+	}
 
-		err := archiver.Compress(sourcePath, destPath, format)
-		if err != nil {
-			log.Fatalf("Compression failed: %v", err)
+	var sources []archiver.Source
+	for _, arg := range positional[1:] {
+		sources = append(sources, parseSource(arg))
+	}
+	format := "zip"
+	if f, ok := archiver.ForPath(positional[0]); ok && f.Capabilities()&archiver.CanCompress != 0 {
+		format = f.Name()
+	}
+	return sources, positional[0], format
+}
+
+// archiveFirst reports whether the positional arguments of compress name the archive first.
+// "<source> <destination> <format>" is recognized by its format name, two arguments by which
+// of them exists, or else by the extension of an archive that can hold several sources.
+func archiveFirst(positional []string) bool {
+	switch {
+	case len(positional) > 3:
+		return true
+	case len(positional) == 3:
+		_, isFormat := archiver.Lookup(positional[2])
+		return !isFormat || pathExists(positional[2])
+	}
+
+	first, second := pathExists(positional[0]), pathExists(parseSource(positional[1]).Path)
+	if first != second {
+		return second
+	}
+	isBundle := func(name string) bool {
+		f, ok := archiver.ForPath(name)
+		return ok && f.Capabilities()&archiver.CanBundle != 0
+	}
+	return isBundle(positional[0]) && !isBundle(positional[1])
+}
+
+// parseSource reads a source argument, "path=prefix" stores path under prefix in the archive.
+// Arguments naming an existing file are taken as they are.
+func parseSource(arg string) archiver.Source {
+	if i := strings.LastIndex(arg, "="); i > 0 && !pathExists(arg) {
+		return archiver.Source{Path: arg[:i], Prefix: arg[i+1:]}
+	}
+	return archiver.Source{Path: arg}
+}
+
+// pathExists reports whether a file, directory or symlink exists at path
+func pathExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// extractCommand runs "extract <source> <destination> [entry...] [options]"
+func extractCommand(args []string) {
+	fs := flag.NewFlagSet("extract", flag.ExitOnError)
+	var opts archiver.ExtractOptions
+	fs.Var((*stringList)(&opts.Include), "include", "extract only entries matching the pattern (repeatable)")
+	fs.Var((*stringList)(&opts.Exclude), "exclude", "skip entries matching the pattern (repeatable)")
+	fs.StringVar(&opts.Password, "password", "", "password of an encrypted archive")
+	overwrite := fs.String("overwrite", "", "what to do with existing files: "+strings.Join(archiver.OverwritePolicyNames(), ", ")+" (default overwrite)")
+	maxSize := fs.String("max-size", "", "largest total size of the extracted files, e.g. 10G")
+	maxFileSize := fs.String("max-file-size", "", "largest size of a single extracted file, e.g. 1G")
+	fs.IntVar(&opts.Limits.MaxEntries, "max-entries", 0, "largest number of extracted entries")
+	fs.Float64Var(&opts.Limits.MaxRatio, "max-ratio", 0, "largest ratio of extracted bytes to the archive size")
+	fs.IntVar(&opts.Limits.MaxDepth, "max-depth", 0, "largest directory depth of an entry")
+	fs.BoolVar(&opts.SafeLinks, "safe-links", false, "refuse entries leading outside the destination through symlinks already in the destination")
+	fs.BoolVar(&opts.IgnorePermissions, "ignore-permissions", false, "create files with default permissions instead of the stored ones")
+	fs.BoolVar(&opts.PreserveOwner, "preserve-owner", false, "restore the numeric owner of entries (usually requires root)")
+	fs.BoolVar(&opts.PreserveSpecialBits, "preserve-special-bits", false, "restore the setuid, setgid and sticky bits of entries")
+	fs.Usage = printUsage
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) < 2 {
+		fmt.Println("Insufficient arguments for extraction")
+		printUsage()
+		return
+	}
+	sourcePath := positional[0]
+	destPath := positional[1]
+	// Any further arguments name the entries to extract
+	opts.Paths = positional[2:]
+
+	var err error
+	if opts.Overwrite, err = archiver.ParseOverwritePolicy(*overwrite); err != nil {
+		log.Fatal(err)
+	}
+	if *maxSize != "" {
+		if opts.Limits.MaxTotalSize, err = utils.ParseSize(*maxSize); err != nil {
+			log.Fatalf("Invalid maximum size: %v", err)
+		}
+	}
+	if *maxFileSize != "" {
+		if opts.Limits.MaxFileSize, err = utils.ParseSize(*maxFileSize); err != nil {
+			log.Fatalf("Invalid maximum file size: %v", err)
 		}
-		fmt.Println("Compression completed successfully")
+	}
+	if opts.Overwrite == archiver.OverwriteAsk {
+		opts.OnConflict = newConflictPrompt(os.Stdin, os.Stdout)
+	}
+	var summary archiver.ExtractSummary
+	opts.Summary = &summary
 
-	case "extract":
-		if len(os.Args) < 4 {
-			fmt.Println("Insufficient arguments for extraction")
-			printUsage()
-			return
+	err = archiver.Extract(sourcePath, destPath, opts)
+	printExtractSummary(summary)
+	if err != nil {
+		log.Fatalf("Extraction failed: %v", err)
+	}
+	fmt.Println("Extraction completed successfully")
+}
+
+// newConflictPrompt returns a ConflictFunc asking on in and out what to do with each existing file.
+// Upper case answers apply to all remaining conflicts.
+func newConflictPrompt(in io.Reader, out io.Writer) archiver.ConflictFunc {
+	reader := bufio.NewReader(in)
+	answers := map[string]archiver.OverwritePolicy{
+		"o": archiver.OverwriteAlways,
+		"s": archiver.OverwriteSkip,
+		"r": archiver.OverwriteRename,
+		"n": archiver.OverwriteIfNewer,
+		"f": archiver.OverwriteFail,
+	}
+	var all *archiver.OverwritePolicy
+
+	return func(conflict archiver.Conflict) archiver.OverwritePolicy {
+		if all != nil {
+			return *all
+		}
+		for {
+			fmt.Fprintf(out, "\n%s already exists. [o]verwrite, [s]kip, [r]ename, if [n]ewer or [f]ail? (upper case for all) ", conflict.Path)
+			line, err := reader.ReadString('\n')
+			answer := strings.TrimSpace(line)
+			if policy, ok := answers[strings.ToLower(answer)]; ok {
+				if answer != strings.ToLower(answer) {
+					all = &policy
+				}
+				return policy
+			}
+			if err != nil {
+				// No one left to answer
+				return archiver.OverwriteFail
+			}
 		}
-		sourcePath := os.Args[2]
-		destPath := os.Args[3]
+	}
+}
 
-		err := archiver.Extract(sourcePath, destPath)
-		if err != nil {
-			log.Fatalf("Extraction failed: %v", err)
+// printExtractSummary reports the entries skipped or renamed because their destination existed,
+// and the hard links left out with their target
+func printExtractSummary(summary archiver.ExtractSummary) {
+	if len(summary.Skipped) > 0 {
+		fmt.Printf("Skipped %d existing file(s):\n", len(summary.Skipped))
+		for _, name := range summary.Skipped {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+	if len(summary.Renamed) > 0 {
+		fmt.Printf("Renamed %d file(s) to avoid overwriting:\n", len(summary.Renamed))
+		for _, entry := range summary.Renamed {
+			fmt.Printf("  %s -> %s\n", entry.Name, entry.Path)
 		}
-		fmt.Println("Extraction completed successfully")
+	}
+	if len(summary.Unlinked) > 0 {
+		fmt.Printf("Skipped %d hard link(s) to entries not extracted:\n", len(summary.Unlinked))
+		for _, name := range summary.Unlinked {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+}
 
-	default:
-		fmt.Printf("Unknown command: %s\n", command)
+// listCommand runs "list <archive> [--json]"
+func listCommand(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the entries as JSON")
+	fs.Usage = printUsage
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) < 1 {
+		fmt.Println("Insufficient arguments for listing")
 		printUsage()
+		return
+	}
+
+	entries, err := archiver.List(positional[0])
+	if err != nil {
+		log.Fatalf("Listing failed: %v", err)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(entries); err != nil {
+			log.Fatalf("Listing failed: %v", err)
+		}
+		return
+	}
+	printEntries(os.Stdout, entries)
+}
+
+// testCommand runs "test <archive> [--password <password>]" and exits with status 1
+// if the archive is damaged
+func testCommand(args []string) {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	password := fs.String("password", "", "password of an encrypted archive")
+	fs.Usage = printUsage
+
+	positional := parseInterspersed(fs, args)
+	if len(positional) < 1 {
+		fmt.Println("Insufficient arguments for testing")
+		printUsage()
+		return
+	}
+
+	result, err := archiver.Test(positional[0], *password)
+	if result != nil {
+		for _, failure := range result.Failures {
+			fmt.Printf("FAILED  %s\n", failure)
+		}
+	}
+	if err != nil {
+		log.Fatalf("Test failed: %v", err)
+	}
+	if !result.OK() {
+		fmt.Printf("%d of %d entries failed\n", len(result.Failures), result.Tested)
+		os.Exit(1)
+	}
+	fmt.Printf("No errors found in %d entries\n", result.Tested)
+}
+
+// printEntries writes entries as a table followed by their totals
+func printEntries(out io.Writer, entries []archiver.Entry) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	// The name is not a cell of its own, so it stays left aligned
+	fmt.Fprintln(w, "Mode\tSize\tCompressed\tMethod\tCRC-32\tModified\t  Name")
+
+	var size, compressed int64
+	for _, entry := range entries {
+		crc := "-"
+		if entry.CRC32 != 0 {
+			crc = fmt.Sprintf("%08x", entry.CRC32)
+		}
+		name := entry.Name
+		if entry.Encrypted {
+			// Marked like in the listings of zip tools
+			name += " *"
+		}
+		modified := "-"
+		if !entry.Modified.IsZero() {
+			modified = entry.Modified.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t  %s\n",
+			entry.Mode, entry.Size, entry.CompressedSize, entry.Method, crc, modified, name)
+		size += entry.Size
+		compressed += entry.CompressedSize
+	}
+	fmt.Fprintf(w, "\t%d\t%d\t\t\t\t  %d entries\n", size, compressed, len(entries))
+	w.Flush()
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeatable flag
+type stringList []string
+
+func (l *stringList) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseInterspersed parses the flags of fs wherever they appear in args,
+// so options may follow the positional arguments. It returns the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		// ExitOnError flag sets exit on their own when parsing fails
+		fs.Parse(args)
+		rest := fs.Args()
+
+		// Everything after "--" is positional
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(positional, rest...)
+		}
+		if len(rest) == 0 {
+			return positional
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
 	}
 }
 
 func printUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("  file-compressor compress <source> <destination> [format]")
-	fmt.Println("  file-compressor extract <source> <destination>")
+	fmt.Println("  file-compressor compress <archive> <source>... [options]")
+	fmt.Println("  file-compressor compress <source> <destination> [format] [options]")
+	fmt.Println("  file-compressor extract <source> <destination> [entry...] [options]")
+	fmt.Println("  file-compressor list <archive> [--json]")
+	fmt.Println("  file-compressor test <archive> [--password <password>]")
+	fmt.Println()
+	fmt.Println("Compress options:")
+	fmt.Println("  --format <name>        output format, instead of the archive extension or positional argument")
+	fmt.Printf("  --level <level>        %s (default maximum)\n", strings.Join(archiver.LevelNames(), ", "))
+	fmt.Println("  --method <method>      force the ZIP entry method: deflate or store")
+	fmt.Println("  --buffer-size <size>   copy buffer size, e.g. 512K or 8M (default 4M)")
+	fmt.Println("  --password <password>  encrypt the archive with AES-256 (ZIP only)")
+	fmt.Println("  --workers <n>          files compressed in parallel (ZIP only, default: number of CPUs)")
+	fmt.Println("  --include <pattern>    compress only matching files of a directory (repeatable)")
+	fmt.Println("  --exclude <pattern>    leave out matching files and directories, e.g. 'node_modules' (repeatable)")
+	fmt.Println("  --exclude-from <file>  leave out the patterns listed in the file (repeatable)")
+	fmt.Println("  --use-ignore-files     honor .gitignore and .compressignore files, and leave out .git")
+	fmt.Println("  --min-size <size>      leave out files smaller than size")
+	fmt.Println("  --max-size <size>      leave out files larger than size")
+	fmt.Println("  --newer-than <time>    only files modified after a date or age, e.g. 2024-01-31 or 7d")
+	fmt.Println("  --older-than <time>    only files modified before a date or age")
+	fmt.Println("  A source written as path=prefix is stored under prefix in the archive, e.g. ../lib=vendor/lib")
+	fmt.Println()
+	fmt.Println("Extract options:")
+	fmt.Println("  --include <pattern>    extract only matching entries, e.g. '*.txt' or 'docs/**' (repeatable)")
+	fmt.Println("  --exclude <pattern>    skip matching entries, even if included (repeatable)")
+	fmt.Println("  --password <password>  password of an encrypted archive")
+	fmt.Printf("  --overwrite <policy>   existing files: %s (default overwrite)\n", strings.Join(archiver.OverwritePolicyNames(), ", "))
+	fmt.Println("  --max-size <size>      stop if the extracted files exceed this total size, e.g. 10G")
+	fmt.Println("  --max-file-size <size> stop if a single file exceeds this size")
+	fmt.Println("  --max-entries <n>      stop after this many entries")
+	fmt.Println("  --max-ratio <ratio>    stop if more than ratio times the archive size is extracted")
+	fmt.Println("  --max-depth <n>        stop at entries nested deeper than n directories")
+	fmt.Println("  --safe-links           refuse entries leading outside the destination through symlinks already there")
+	fmt.Println("  --ignore-permissions   use default permissions instead of the stored ones")
+	fmt.Println("  --preserve-owner       restore the numeric owner of entries (usually requires root)")
+	fmt.Println("  --preserve-special-bits restore the setuid, setgid and sticky bits")
 	fmt.Println()
-	fmt.Println("Supported formats: zip, pdf, png, jpg, jpeg")
+	fmt.Printf("Supported formats: %s\n", strings.Join(archiver.FormatNames(archiver.CanCompress), ", "))
+	fmt.Printf("Extractable formats: %s\n", strings.Join(archiver.FormatNames(archiver.CanExtract), ", "))
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/theme"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/latreon/file-compressor/pkg/archiver"
+)
+
+// showArchiveContents displays the entries of the archive at sourcePath as a browsable tree
+func showArchiveContents(sourcePath string, window fyne.Window) {
+	entries, err := archiver.List(sourcePath)
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("failed to list archive: %w", err), window)
+		return
+	}
+	entryTree := newEntryTree(entries)
+
+	tree := widget.NewTree(
+		func(id widget.TreeNodeID) []widget.TreeNodeID {
+			return entryTree.children[id]
+		},
+		func(id widget.TreeNodeID) bool {
+			return len(entryTree.children[id]) > 0
+		},
+		func(branch bool) fyne.CanvasObject {
+			details := widget.NewLabel("")
+			details.Alignment = fyne.TextAlignTrailing
+			return container.NewBorder(nil, nil, widget.NewIcon(nil), details, widget.NewLabel(""))
+		},
+		func(id widget.TreeNodeID, branch bool, object fyne.CanvasObject) {
+			row := object.(*fyne.Container)
+			name := row.Objects[0].(*widget.Label)
+			icon := row.Objects[1].(*widget.Icon)
+			details := row.Objects[2].(*widget.Label)
+
+			entry, ok := entryTree.entries[id]
+			name.SetText(path.Base(id))
+			if branch || (ok && entry.IsDir()) {
+				icon.SetResource(theme.FolderIcon())
+			} else {
+				icon.SetResource(theme.FileIcon())
+			}
+			if ok && !entry.IsDir() {
+				details.SetText(entryDetails(entry))
+			} else {
+				details.SetText("")
+			}
+		},
+	)
+
+	// Totals of the archive above the tree
+	var size, compressed int64
+	for _, entry := range entries {
+		size += entry.Size
+		compressed += entry.CompressedSize
+	}
+	summary := fmt.Sprintf("%d entries, %s", len(entries), formatSize(size))
+	if compressed > 0 {
+		summary += fmt.Sprintf(" (%s compressed)", formatSize(compressed))
+	}
+
+	content := container.NewBorder(widget.NewLabel(summary), nil, nil, nil, tree)
+	d := dialog.NewCustom(path.Base(sourcePath), "Close", content, window)
+	d.Resize(fyne.NewSize(700, 500))
+	d.Show()
+}
+
+// entryDetails describes the size, method and date of a file entry
+func entryDetails(entry archiver.Entry) string {
+	parts := []string{formatSize(entry.Size)}
+	if entry.Method != "" {
+		parts = append(parts, entry.Method)
+	}
+	if entry.Encrypted {
+		parts = append(parts, "encrypted")
+	}
+	if !entry.Modified.IsZero() {
+		parts = append(parts, entry.Modified.Local().Format("2006-01-02 15:04"))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// formatSize formats a byte count with a binary unit
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/container"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/latreon/file-compressor/pkg/archiver"
+)
+
+// newConflictDialog returns a ConflictFunc asking the user what to do with each existing file.
+// It is called from the extraction goroutine and waits until the dialog is answered.
+func newConflictDialog(window fyne.Window) archiver.ConflictFunc {
+	var all *archiver.OverwritePolicy
+
+	return func(conflict archiver.Conflict) archiver.OverwritePolicy {
+		if all != nil {
+			return *all
+		}
+
+		answer := make(chan archiver.OverwritePolicy, 1)
+		applyToAll := widget.NewCheck("Apply to all remaining files", nil)
+		message := widget.NewLabel(fmt.Sprintf("%s already exists.\nModified %s, extracting a version from %s.",
+			conflict.Path,
+			conflict.Existing.ModTime().Format("2006-01-02 15:04"),
+			formatEntryTime(conflict)))
+		message.Wrapping = fyne.TextWrapWord
+
+		d := dialog.NewCustomWithoutButtons("File Exists", container.NewVBox(message, applyToAll), window)
+		choose := func(policy archiver.OverwritePolicy) func() {
+			return func() {
+				if applyToAll.Checked {
+					all = &policy
+				}
+				answer <- policy
+				d.Hide()
+			}
+		}
+		d.SetButtons([]fyne.CanvasObject{
+			widget.NewButton("Overwrite", choose(archiver.OverwriteAlways)),
+			widget.NewButton("Skip", choose(archiver.OverwriteSkip)),
+			widget.NewButton("Rename", choose(archiver.OverwriteRename)),
+			widget.NewButton("Stop", choose(archiver.OverwriteFail)),
+		})
+		d.Resize(fyne.NewSize(500, 200))
+		d.Show()
+
+		return <-answer
+	}
+}
+
+// formatEntryTime describes the modification time of the conflicting entry
+func formatEntryTime(conflict archiver.Conflict) string {
+	if conflict.Modified.IsZero() {
+		return "an unknown date"
+	}
+	return conflict.Modified.Format("2006-01-02 15:04")
+}
+
+// maxSummaryLines bounds the number of files listed per group in the extraction summary
+const maxSummaryLines = 10
+
+// extractSummaryText describes the entries skipped or renamed during an extraction
+func extractSummaryText(summary archiver.ExtractSummary) string {
+	var b strings.Builder
+	if len(summary.Skipped) > 0 {
+		fmt.Fprintf(&b, "\n\nSkipped %d existing file(s):", len(summary.Skipped))
+		for i, name := range summary.Skipped {
+			if i == maxSummaryLines {
+				fmt.Fprintf(&b, "\n... and %d more", len(summary.Skipped)-i)
+				break
+			}
+			fmt.Fprintf(&b, "\n%s", name)
+		}
+	}
+	if len(summary.Renamed) > 0 {
+		fmt.Fprintf(&b, "\n\nRenamed %d file(s):", len(summary.Renamed))
+		for i, entry := range summary.Renamed {
+			if i == maxSummaryLines {
+				fmt.Fprintf(&b, "\n... and %d more", len(summary.Renamed)-i)
+				break
+			}
+			fmt.Fprintf(&b, "\n%s -> %s", entry.Name, entry.Path)
+		}
+	}
+	if len(summary.Unlinked) > 0 {
+		fmt.Fprintf(&b, "\n\nSkipped %d hard link(s) to entries not extracted:", len(summary.Unlinked))
+		for i, name := range summary.Unlinked {
+			if i == maxSummaryLines {
+				fmt.Fprintf(&b, "\n... and %d more", len(summary.Unlinked)-i)
+				break
+			}
+			fmt.Fprintf(&b, "\n%s", name)
+		}
+	}
+	return b.String()
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -21,10 +24,16 @@ import (
 // UI state variables
 type appState struct {
 	sourcePath      string
+	sourcePaths     []string // every file or folder to compress, starting with sourcePath
 	destinationPath string
 	format          string
+	level           archiver.CompressionLevel
+	password        string
+	selectedPaths   []string // entries to extract, nil for the whole archive
+	overwrite       archiver.OverwritePolicy
 	compressing     bool
-	progressChan    chan float64
+	cancel          context.CancelFunc // stops the running compression or extraction
+	progressChan    chan archiver.ProgressEvent
 }
 
 func main() {
@@ -36,7 +45,8 @@ func main() {
 	// Initialize application state
 	state := &appState{
 		format:       "zip",
-		progressChan: make(chan float64),
+		overwrite:    archiver.OverwriteAsk,
+		progressChan: make(chan archiver.ProgressEvent),
 	}
 
 	// Create UI components
@@ -69,11 +79,25 @@ func main() {
 			}
 			path := reader.URI().Path()
 			reader.Close()
-			state.sourcePath = path
-			sourceEntry.SetText(path)
+			setSources(state, []string{path}, sourceEntry, destEntry)
+		}, w)
+		fd.Show()
+	})
 
-			// Auto-generate destination path
-			autoGenerateDestPath(state, path, destEntry)
+	// Add more files to bundle them in one archive
+	addButton := widget.NewButton("Add", func() {
+		fd := dialog.NewFileOpen(func(reader fyne.URIReadCloser, err error) {
+			if err != nil || reader == nil {
+				return
+			}
+			path := reader.URI().Path()
+			reader.Close()
+			for _, selected := range state.sourcePaths {
+				if selected == path {
+					return
+				}
+			}
+			setSources(state, append(state.sourcePaths, path), sourceEntry, destEntry)
 		}, w)
 		fd.Show()
 	})
@@ -93,16 +117,42 @@ func main() {
 
 	// Format selection
 	formatLabel := widget.NewLabel("Compression Format:")
-	formatSelect := widget.NewSelect([]string{"zip", "pdf", "png", "jpg", "jpeg"}, func(value string) {
+	formatSelect := widget.NewSelect(archiver.FormatNames(archiver.CanCompress), func(value string) {
 		state.format = value
 
 		// Update destination extension if we have a source
 		if state.sourcePath != "" {
-			autoGenerateDestPath(state, state.sourcePath, destEntry)
+			autoGenerateDestPath(state, destEntry)
 		}
 	})
 	formatSelect.SetSelected("zip")
 
+	// Compression level selection
+	levelLabel := widget.NewLabel("Compression Level:")
+	levelSelect := widget.NewSelect(archiver.LevelNames(), func(value string) {
+		if level, err := archiver.ParseLevel(value); err == nil {
+			state.level = level
+		}
+	})
+	levelSelect.SetSelected(archiver.LevelMaximum.String())
+
+	// Optional password, used to encrypt ZIP archives and to open encrypted archives
+	passwordLabel := widget.NewLabel("Password:")
+	passwordEntry := widget.NewPasswordEntry()
+	passwordEntry.SetPlaceHolder("Optional, ZIP archives only")
+	passwordEntry.OnChanged = func(value string) {
+		state.password = value
+	}
+
+	// What extraction does with files that already exist
+	overwriteLabel := widget.NewLabel("Existing Files:")
+	overwriteSelect := widget.NewSelect(archiver.OverwritePolicyNames(), func(value string) {
+		if policy, err := archiver.ParseOverwritePolicy(value); err == nil {
+			state.overwrite = policy
+		}
+	})
+	overwriteSelect.SetSelected(archiver.OverwriteAsk.String())
+
 	// Drop area with instructions
 	dropLabel := widget.NewLabelWithStyle(
 		"Drag and drop files or folders here",
@@ -129,6 +179,15 @@ func main() {
 	progressLabel := widget.NewLabel("Ready")
 	progressLabel.Alignment = fyne.TextAlignCenter
 
+	// Stops the running operation, enabled while there is one
+	cancelButton := widget.NewButtonWithIcon("Cancel", theme.CancelIcon(), func() {
+		if state.cancel != nil {
+			progressLabel.SetText("Canceling...")
+			state.cancel()
+		}
+	})
+	cancelButton.Disable()
+
 	// Action buttons
 	compressButton := widget.NewButton("Compress", func() {
 		if state.compressing {
@@ -144,7 +203,7 @@ func main() {
 		}
 
 		// Start compression in a goroutine
-		go startCompression(state, progressLabel, progress, w)
+		go startCompression(state, progressLabel, progress, cancelButton, w)
 	})
 	compressButton.Importance = widget.HighImportance
 
@@ -152,8 +211,8 @@ func main() {
 		if state.compressing {
 			return
 		}
-		if state.sourcePath == "" {
-			dialog.ShowError(fmt.Errorf("no archive file selected"), w)
+		if _, err := archivePath(state); err != nil {
+			dialog.ShowError(err, w)
 			return
 		}
 		if state.destinationPath == "" {
@@ -162,10 +221,40 @@ func main() {
 		}
 
 		// Start extraction in a goroutine
-		go startExtraction(state, progressLabel, progress, w)
+		go startExtraction(state, progressLabel, progress, cancelButton, w)
 	})
 	extractButton.Importance = widget.HighImportance
 
+	// Browse the archive without extracting it
+	contentsButton := widget.NewButton("View Contents", func() {
+		path, err := archivePath(state)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		showArchiveContents(path, w)
+	})
+
+	// Pick the entries to extract instead of the whole archive
+	selectButton := widget.NewButton("Select Entries...", func() {
+		if state.compressing {
+			return
+		}
+		path, err := archivePath(state)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		showEntrySelection(path, w, func(paths []string) {
+			state.selectedPaths = paths
+			if paths == nil {
+				progressLabel.SetText("All entries selected")
+			} else {
+				progressLabel.SetText(fmt.Sprintf("%d entries selected", len(paths)))
+			}
+		})
+	})
+
 	// Setup file drop handling
 	w.Canvas().SetOnTypedKey(func(ke *fyne.KeyEvent) {
 		// This is a placeholder, we're only interested in file drops
@@ -176,24 +265,30 @@ func main() {
 			return
 		}
 
-		// Get the first dropped URI
-		uri := files[0]
-		path := uri.Path()
-		state.sourcePath = path
-		sourceEntry.SetText(path)
-
-		// Auto-generate destination path
-		autoGenerateDestPath(state, path, destEntry)
+		// Several dropped files and folders are compressed into one archive
+		paths := make([]string, len(files))
+		for i, uri := range files {
+			paths[i] = uri.Path()
+		}
+		setSources(state, paths, sourceEntry, destEntry)
 
 		// Update the drop zone visual
 		dropIcon.SetResource(theme.DocumentIcon())
-		dropLabel.SetText(filepath.Base(path))
+		if len(paths) == 1 {
+			dropLabel.SetText(filepath.Base(paths[0]))
+		} else {
+			dropLabel.SetText(fmt.Sprintf("%d items", len(paths)))
+		}
 	})
 
 	// Monitor progress updates in a separate goroutine
 	go func() {
-		for p := range state.progressChan {
-			progress.SetValue(p)
+		for event := range state.progressChan {
+			progress.SetValue(event.Fraction())
+			// The result of the operation replaces the text once it is done
+			if !event.Done {
+				progressLabel.SetText(progressText(event))
+			}
 		}
 	}()
 
@@ -204,9 +299,12 @@ func main() {
 	)
 
 	formContent := container.NewVBox(
-		container.New(layout.NewFormLayout(), sourceLabel, container.NewBorder(nil, nil, nil, sourceButton, sourceEntry)),
+		container.New(layout.NewFormLayout(), sourceLabel, container.NewBorder(nil, nil, nil, container.NewHBox(sourceButton, addButton), sourceEntry)),
 		container.New(layout.NewFormLayout(), destLabel, container.NewBorder(nil, nil, nil, destButton, destEntry)),
 		container.New(layout.NewFormLayout(), formatLabel, formatSelect),
+		container.New(layout.NewFormLayout(), levelLabel, levelSelect),
+		container.New(layout.NewFormLayout(), passwordLabel, passwordEntry),
+		container.New(layout.NewFormLayout(), overwriteLabel, overwriteSelect),
 	)
 
 	// Action buttons in a horizontal container
@@ -214,6 +312,9 @@ func main() {
 		layout.NewSpacer(),
 		compressButton,
 		extractButton,
+		selectButton,
+		contentsButton,
+		cancelButton,
 		layout.NewSpacer(),
 	)
 
@@ -233,20 +334,59 @@ func main() {
 	w.ShowAndRun()
 }
 
-// autoGenerateDestPath generates a destination path based on the source path
-func autoGenerateDestPath(state *appState, sourcePath string, destEntry *widget.Entry) {
-	// Check if the source is an archive (for extraction)
-	ext := strings.ToLower(filepath.Ext(sourcePath))
-	if ext == ".zip" || ext == ".tar" || ext == ".gz" || ext == ".bz2" || ext == ".xz" {
+// setSources selects the files and folders to compress, or the archive to extract
+func setSources(state *appState, paths []string, sourceEntry, destEntry *widget.Entry) {
+	state.sourcePath = paths[0]
+	state.sourcePaths = paths
+	state.selectedPaths = nil
+	if len(paths) == 1 {
+		sourceEntry.SetText(paths[0])
+	} else {
+		names := make([]string, len(paths))
+		for i, path := range paths {
+			names[i] = filepath.Base(path)
+		}
+		sourceEntry.SetText(fmt.Sprintf("%d items: %s", len(paths), strings.Join(names, ", ")))
+	}
+
+	// Auto-generate destination path
+	autoGenerateDestPath(state, destEntry)
+}
+
+// archivePath returns the selected archive, extraction and listing take a single source
+func archivePath(state *appState) (string, error) {
+	if state.sourcePath == "" {
+		return "", fmt.Errorf("no archive file selected")
+	}
+	if len(state.sourcePaths) > 1 {
+		return "", fmt.Errorf("%d items are selected, select a single archive", len(state.sourcePaths))
+	}
+	return state.sourcePath, nil
+}
+
+// autoGenerateDestPath generates a destination path based on the selected sources
+func autoGenerateDestPath(state *appState, destEntry *widget.Entry) {
+	sourcePath := state.sourcePath
+	bundle := len(state.sourcePaths) > 1
+	if bundle {
+		// Several sources are bundled into an archive named after the folder of the first one
+		dir := filepath.Dir(sourcePath)
+		sourcePath = filepath.Join(dir, filepath.Base(dir))
+	}
+
+	// Check if a single source is an archive (for extraction), judging by its content
+	if f, err := archiver.DetectFormat(sourcePath); err == nil && !bundle && f.Capabilities()&archiver.CanExtract != 0 {
 		// For extraction, set destination to a folder with the same name without extension
-		baseName := filepath.Base(sourcePath)
-		baseName = baseName[:len(baseName)-len(ext)]
+		baseName := trimFormatExtension(filepath.Base(sourcePath), f)
 		destPath := filepath.Join(filepath.Dir(sourcePath), baseName+"-extracted")
 		state.destinationPath = destPath
 		destEntry.SetText(destPath)
 	} else {
 		// For compression, add the format extension
 		ext := "." + state.format
+		if f, ok := archiver.Lookup(state.format); ok {
+			ext = f.Extensions()[0]
+		}
 		if !strings.HasSuffix(sourcePath, ext) {
 			baseName := filepath.Base(sourcePath)
 			destPath := filepath.Join(filepath.Dir(sourcePath), baseName+ext)
@@ -256,29 +396,85 @@ func autoGenerateDestPath(state *appState, sourcePath string, destEntry *widget.
 	}
 }
 
+// trimFormatExtension removes the longest extension of format from name,
+// falling back to the last extension for archives with an unexpected name
+func trimFormatExtension(name string, format archiver.Format) string {
+	lower := strings.ToLower(name)
+	trimmed := strings.TrimSuffix(name, filepath.Ext(name))
+	for _, ext := range format.Extensions() {
+		if strings.HasSuffix(lower, ext) && len(name)-len(ext) < len(trimmed) {
+			trimmed = name[:len(name)-len(ext)]
+		}
+	}
+	return trimmed
+}
+
+// progressText describes a progress event for the progress label, e.g.
+// "Compressing docs/a.txt (3/10 files) 1.2 MB/s, 4s left" or "Optimizing (step 4/4)"
+func progressText(event archiver.ProgressEvent) string {
+	text := "Working"
+	if event.Stage != "" {
+		text = strings.ToUpper(event.Stage[:1]) + event.Stage[1:]
+	}
+	if event.StageCount > 1 && event.StageIndex > 0 {
+		text += fmt.Sprintf(" (step %d/%d)", event.StageIndex, event.StageCount)
+	}
+	if event.File != "" {
+		text += " " + event.File
+	}
+	if event.FilesTotal > 0 {
+		text += fmt.Sprintf(" (%d/%d files)", event.FilesDone, event.FilesTotal)
+	}
+	if event.Speed > 0 {
+		text += fmt.Sprintf(" %s/s", formatSize(int64(event.Speed)))
+	}
+	if event.ETA > 0 {
+		text += fmt.Sprintf(", %s left", event.ETA.Round(time.Second))
+	}
+	return text
+}
+
 // startCompression handles the compression process
-func startCompression(state *appState, progressLabel *widget.Label, progressBar *widget.ProgressBar, window fyne.Window) {
+func startCompression(state *appState, progressLabel *widget.Label, progressBar *widget.ProgressBar, cancelButton *widget.Button, window fyne.Window) {
 	state.compressing = true
 	progressLabel.SetText("Compressing...")
 	progressBar.SetValue(0)
 
-	// Create a custom progress writer that can update the UI
-	originalProgressWriter := archiver.NewProgressCallback(
-		func(bytesWritten, totalSize int64) {
-			if totalSize > 0 {
-				// Calculate the percentage
-				percentage := float64(bytesWritten) / float64(totalSize)
-				// Send progress update
-				state.progressChan <- percentage
-			}
-		},
-	)
+	// The Cancel button stops the compression through ctx
+	ctx, cancel := startOperation(state, cancelButton)
+	defer finishOperation(state, cancel, cancelButton)
+
+	// Create a custom progress tracker that can update the UI
+	progressTracker := archiver.NewProgressTracker(func(event archiver.ProgressEvent) {
+		// The label shows the current file of the updates, events of every file are not needed
+		if event.Kind != archiver.FileStarted && event.Kind != archiver.FileFinished {
+			state.progressChan <- event
+		}
+	}, 0)
 
 	// Start compression
-	err := archiver.CompressWithProgress(state.sourcePath, state.destinationPath, state.format, originalProgressWriter)
+	opts := archiver.CompressOptions{
+		Level:    state.level,
+		Password: state.password,
+	}
+	var err error
+	if len(state.sourcePaths) > 1 {
+		// Every source keeps its name in the archive
+		sources := make([]archiver.Source, len(state.sourcePaths))
+		for i, path := range state.sourcePaths {
+			sources[i] = archiver.Source{Path: path}
+		}
+		err = archiver.CompressSourcesContext(ctx, sources, state.destinationPath, state.format, opts, progressTracker)
+	} else {
+		err = archiver.CompressContext(ctx, state.sourcePath, state.destinationPath, state.format, opts, progressTracker)
+	}
 
 	// Update UI based on compression result
-	if err != nil {
+	if errors.Is(err, context.Canceled) {
+		// The partial archive is already removed
+		progressLabel.SetText("Compression canceled")
+		progressBar.SetValue(0)
+	} else if err != nil {
 		log.Printf("Compression error: %v", err)
 		progressLabel.SetText(fmt.Sprintf("Error: %v", err))
 		dialog.ShowError(err, window)
@@ -296,28 +492,43 @@ func startCompression(state *appState, progressLabel *widget.Label, progressBar
 }
 
 // startExtraction handles the extraction process
-func startExtraction(state *appState, progressLabel *widget.Label, progressBar *widget.ProgressBar, window fyne.Window) {
+func startExtraction(state *appState, progressLabel *widget.Label, progressBar *widget.ProgressBar, cancelButton *widget.Button, window fyne.Window) {
 	state.compressing = true
 	progressLabel.SetText("Extracting...")
 	progressBar.SetValue(0)
 
+	// The Cancel button stops the extraction through ctx
+	ctx, cancel := startOperation(state, cancelButton)
+	defer finishOperation(state, cancel, cancelButton)
+
 	// Create a custom progress tracker that can update the UI
-	progressTracker := archiver.NewProgressCallback(
-		func(bytesWritten, totalSize int64) {
-			if totalSize > 0 {
-				// Calculate the percentage
-				percentage := float64(bytesWritten) / float64(totalSize)
-				// Send progress update
-				state.progressChan <- percentage
-			}
-		},
-	)
+	progressTracker := archiver.NewProgressTracker(func(event archiver.ProgressEvent) {
+		// The label shows the current file of the updates, events of every file are not needed
+		if event.Kind != archiver.FileStarted && event.Kind != archiver.FileFinished {
+			state.progressChan <- event
+		}
+	}, 0)
 
 	// Start extraction
-	err := archiver.ExtractWithProgress(state.sourcePath, state.destinationPath, progressTracker)
+	var summary archiver.ExtractSummary
+	opts := archiver.ExtractOptions{
+		Paths:     state.selectedPaths,
+		Password:  state.password,
+		Overwrite: state.overwrite,
+		Summary:   &summary,
+		SafeLinks: true,
+	}
+	if opts.Overwrite == archiver.OverwriteAsk {
+		opts.OnConflict = newConflictDialog(window)
+	}
+	err := archiver.ExtractContext(ctx, state.sourcePath, state.destinationPath, opts, progressTracker)
 
 	// Update UI based on extraction result
-	if err != nil {
+	if errors.Is(err, context.Canceled) {
+		// The files extracted so far are already removed
+		progressLabel.SetText("Extraction canceled")
+		progressBar.SetValue(0)
+	} else if err != nil {
 		log.Printf("Extraction error: %v", err)
 		progressLabel.SetText(fmt.Sprintf("Error: %v", err))
 		dialog.ShowError(err, window)
@@ -327,9 +538,25 @@ func startExtraction(state *appState, progressLabel *widget.Label, progressBar *
 
 		// Show success dialog
 		dialog.ShowInformation("Success",
-			fmt.Sprintf("Archive successfully extracted to:\n%s", state.destinationPath),
+			fmt.Sprintf("Archive successfully extracted to:\n%s%s", state.destinationPath, extractSummaryText(summary)),
 			window)
 	}
 
 	state.compressing = false
 }
+
+// startOperation returns the context of a compression or extraction about to start,
+// and enables the Cancel button to stop it
+func startOperation(state *appState, cancelButton *widget.Button) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	state.cancel = cancel
+	cancelButton.Enable()
+	return ctx, cancel
+}
+
+// finishOperation releases the context of the finished operation and disables the Cancel button
+func finishOperation(state *appState, cancel context.CancelFunc, cancelButton *widget.Button) {
+	cancel()
+	state.cancel = nil
+	cancelButton.Disable()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/dialog"
+	"fyne.io/fyne/v2/widget"
+
+	"github.com/latreon/file-compressor/pkg/archiver"
+)
+
+// entryTree holds the entries of an archive as a tree of slash separated paths.
+// The root node has the empty ID, like in widget.Tree.
+type entryTree struct {
+	children map[string][]string
+	checked  map[string]bool
+	// entries holds the archive entry of each node, directories implied by their contents have none
+	entries map[string]archiver.Entry
+}
+
+// newEntryTree builds the tree of the given archive entries, with every entry checked
+func newEntryTree(entries []archiver.Entry) *entryTree {
+	t := &entryTree{
+		children: make(map[string][]string),
+		checked:  make(map[string]bool),
+		entries:  make(map[string]archiver.Entry),
+	}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		name := strings.Trim(path.Clean("/"+strings.ReplaceAll(entry.Name, "\\", "/")), "/")
+		if name != "" && name != "." {
+			t.entries[name] = entry
+		}
+		// Add the entry and every parent directory missing so far
+		for name != "" && name != "." && !seen[name] {
+			seen[name] = true
+			t.checked[name] = true
+			parent := path.Dir(name)
+			if parent == "." {
+				parent = ""
+			}
+			t.children[parent] = append(t.children[parent], name)
+			name = parent
+		}
+	}
+	for _, children := range t.children {
+		sort.Strings(children)
+	}
+	return t
+}
+
+// setChecked checks or unchecks id and everything below it.
+// Unchecking also unchecks the parents, which no longer select all their entries.
+func (t *entryTree) setChecked(id string, checked bool) {
+	t.checked[id] = checked
+	for _, child := range t.children[id] {
+		t.setChecked(child, checked)
+	}
+	if !checked {
+		for parent := path.Dir(id); parent != "." && parent != "/"; parent = path.Dir(parent) {
+			t.checked[parent] = false
+		}
+	}
+}
+
+// selectedPaths returns the checked nodes whose parent is not checked.
+// It returns nil when every entry is checked.
+func (t *entryTree) selectedPaths() []string {
+	paths := []string{}
+	all := true
+	var walk func(id string)
+	walk = func(id string) {
+		for _, child := range t.children[id] {
+			if t.checked[child] {
+				paths = append(paths, child)
+			} else {
+				all = false
+				walk(child)
+			}
+		}
+	}
+	walk("")
+	if all {
+		return nil
+	}
+	return paths
+}
+
+// showEntrySelection lets the user pick the entries of the archive at sourcePath to extract.
+// onSelected receives the selected entry paths, or nil to extract the whole archive.
+func showEntrySelection(sourcePath string, window fyne.Window, onSelected func(paths []string)) {
+	entries, err := archiver.List(sourcePath)
+	if err != nil {
+		dialog.ShowError(fmt.Errorf("failed to list archive: %w", err), window)
+		return
+	}
+	entryTree := newEntryTree(entries)
+
+	var tree *widget.Tree
+	tree = widget.NewTree(
+		func(id widget.TreeNodeID) []widget.TreeNodeID {
+			return entryTree.children[id]
+		},
+		func(id widget.TreeNodeID) bool {
+			return len(entryTree.children[id]) > 0
+		},
+		func(branch bool) fyne.CanvasObject {
+			return widget.NewCheck("", nil)
+		},
+		func(id widget.TreeNodeID, branch bool, object fyne.CanvasObject) {
+			check := object.(*widget.Check)
+			check.Text = path.Base(id)
+			// SetChecked calls OnChanged, so install the handler afterwards
+			check.OnChanged = nil
+			check.SetChecked(entryTree.checked[id])
+			check.OnChanged = func(checked bool) {
+				entryTree.setChecked(id, checked)
+				tree.Refresh()
+			}
+		},
+	)
+
+	content := dialog.NewCustomConfirm("Select Entries", "Extract Selected", "Cancel", tree, func(ok bool) {
+		if !ok {
+			return
+		}
+		paths := entryTree.selectedPaths()
+		if paths != nil && len(paths) == 0 {
+			dialog.ShowError(fmt.Errorf("no entries selected"), window)
+			return
+		}
+		onSelected(paths)
+	}, window)
+	content.Resize(fyne.NewSize(500, 400))
+	content.Show()
+}
@@ -5,8 +5,10 @@ go 1.24.0
 require (
 	fyne.io/fyne/v2 v2.5.5
 	github.com/gorilla/mux v1.8.1
+	github.com/klauspost/compress v1.18.0
 	github.com/pdfcpu/pdfcpu v0.5.0
 	github.com/rs/cors v1.11.1
+	github.com/ulikunitz/xz v0.5.12
 	golang.org/x/image v0.18.0
 )
 
@@ -0,0 +1,105 @@
+package archiver
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// codec is a single-stream compression algorithm.
+// Codecs are exposed both as single-file formats ("xz") and as tar wrappers ("tar.xz").
+type codec struct {
+	// name is appended to "tar." to form the format name, e.g. "gz" for "tar.gz"
+	name string
+	// extensions lists the file extensions of the compressed stream, e.g. ".gz"
+	extensions []string
+	// magic is the signature at the start of a compressed stream
+	magic []byte
+	// newReader wraps r with a decompressor
+	newReader func(r io.Reader) (io.ReadCloser, error)
+	// newWriter wraps w with a compressor at level, or is nil if the codec is read-only
+	newWriter func(w io.Writer, level CompressionLevel) (io.WriteCloser, error)
+}
+
+// detect reports whether header starts with the codec's signature
+func (c *codec) detect(header []byte) bool {
+	return bytes.HasPrefix(header, c.magic)
+}
+
+var gzipCodec = &codec{
+	name:       "gz",
+	extensions: []string{".gz"},
+	magic:      []byte{0x1F, 0x8B},
+	newReader: func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	},
+	newWriter: func(w io.Writer, level CompressionLevel) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level.flateLevel())
+	},
+}
+
+var xzCodec = &codec{
+	name:       "xz",
+	extensions: []string{".xz"},
+	magic:      []byte{0xFD, '7', 'z', 'X', 'Z', 0x00},
+	newReader: func(r io.Reader) (io.ReadCloser, error) {
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	},
+	newWriter: func(w io.Writer, level CompressionLevel) (io.WriteCloser, error) {
+		// LZMA has no stored mode, faster levels use a smaller dictionary
+		config := xz.WriterConfig{}
+		switch level {
+		case LevelStore, LevelFastest:
+			config.DictCap = 1 << 20 // 1MB
+		case LevelNormal:
+			config.DictCap = 4 << 20 // 4MB
+		}
+		return config.NewWriter(w)
+	},
+}
+
+// bzip2Codec is read-only, the standard library has no bzip2 encoder
+var bzip2Codec = &codec{
+	name:       "bz2",
+	extensions: []string{".bz2"},
+	magic:      []byte("BZh"),
+	newReader: func(r io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	},
+}
+
+var zstdCodec = &codec{
+	name:       "zst",
+	extensions: []string{".zst"},
+	magic:      []byte{0x28, 0xB5, 0x2F, 0xFD},
+	newReader: func(r io.Reader) (io.ReadCloser, error) {
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	},
+	newWriter: func(w io.Writer, level CompressionLevel) (io.WriteCloser, error) {
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel(level)))
+	},
+}
+
+// zstdLevel maps a CompressionLevel to a zstd encoder level.
+// zstd cannot store data uncompressed, so LevelStore uses the fastest level.
+func zstdLevel(level CompressionLevel) zstd.EncoderLevel {
+	switch level {
+	case LevelStore, LevelFastest:
+		return zstd.SpeedFastest
+	case LevelNormal:
+		return zstd.SpeedDefault
+	}
+	return zstd.SpeedBestCompression
+}
@@ -0,0 +1,37 @@
+package archiver
+
+import (
+	"context"
+	"io"
+)
+
+// contextErr returns the error of ctx once it is canceled. ctx may be nil.
+func contextErr(ctx context.Context) error {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// contextReader stops reading once its context is canceled
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+// newContextReader returns r, stopping with the error of ctx once it is canceled.
+// ctx may be nil.
+func newContextReader(ctx context.Context, r io.Reader) io.Reader {
+	if ctx == nil || ctx.Done() == nil {
+		// The context can never be canceled
+		return r
+	}
+	return contextReader{ctx: ctx, reader: r}
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
@@ -0,0 +1,96 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cancelingTracker returns a context and a tracker canceling it on the first event of kind
+func cancelingTracker(kind ProgressKind) (context.Context, *ProgressTracker) {
+	ctx, cancel := context.WithCancel(context.Background())
+	tracker := NewProgressTracker(func(event ProgressEvent) {
+		if event.Kind == kind {
+			cancel()
+		}
+	}, 0)
+	return ctx, tracker
+}
+
+// writeCancelTree writes a few files large enough to be read in several parts
+func writeCancelTree(t *testing.T) string {
+	t.Helper()
+	source := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "sub/c.txt", "sub/d.txt"} {
+		path := filepath.Join(source, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(strings.Repeat(name, 100000)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return source
+}
+
+func TestCompressCancel(t *testing.T) {
+	source := writeCancelTree(t)
+	for _, format := range []string{"zip", "tar", "tar.gz", "gz"} {
+		t.Run(format, func(t *testing.T) {
+			src := source
+			if format == "gz" {
+				src = filepath.Join(source, "a.txt")
+			}
+			archive := filepath.Join(t.TempDir(), "canceled."+format)
+			ctx, tracker := cancelingTracker(FileStarted)
+			err := CompressContext(ctx, src, archive, format, CompressOptions{BufferSize: 4096}, tracker)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("error %v, want %v", err, context.Canceled)
+			}
+			if _, err := os.Lstat(archive); !os.IsNotExist(err) {
+				t.Errorf("partial archive left behind: %v", err)
+			}
+		})
+	}
+
+	// A context canceled beforehand creates nothing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	archive := filepath.Join(t.TempDir(), "never.zip")
+	if err := CompressContext(ctx, source, archive, "zip", CompressOptions{}, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Lstat(archive); !os.IsNotExist(err) {
+		t.Errorf("archive created for a canceled context: %v", err)
+	}
+}
+
+func TestExtractCancel(t *testing.T) {
+	source := writeCancelTree(t)
+	archives := map[string]string{"7z": filepath.Join("testdata", "lzma2.7z")}
+	for _, format := range []string{"zip", "tar.gz"} {
+		archives[format] = filepath.Join(t.TempDir(), "tree."+format)
+		if err := CompressWithProgress(source, archives[format], format, CompressOptions{}, nil); err != nil {
+			t.Fatalf("Compress: %v", err)
+		}
+	}
+
+	for format, archive := range archives {
+		t.Run(format, func(t *testing.T) {
+			// The destination and everything below it are created by the extraction
+			base := t.TempDir()
+			dest := filepath.Join(base, "out")
+			ctx, tracker := cancelingTracker(FileFinished)
+			err := ExtractContext(ctx, archive, dest, ExtractOptions{}, tracker)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("error %v, want %v", err, context.Canceled)
+			}
+			if entries, err := os.ReadDir(base); err != nil || len(entries) != 0 {
+				t.Errorf("left behind %v (%v), want nothing", entries, err)
+			}
+		})
+	}
+}
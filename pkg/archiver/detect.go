@@ -0,0 +1,118 @@
+package archiver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// headerSize is the number of leading bytes passed to Format.Detect.
+// It covers the ustar magic of a tar header and is usually enough to
+// decompress the first tar header of a compressed tarball.
+const headerSize = 64 * 1024
+
+// signatures of formats that are recognized but have no registered implementation
+var unsupportedSignatures = []struct {
+	name  string
+	magic []byte
+}{
+	{"rar", []byte("Rar!\x1A\x07")},
+}
+
+// readHeader returns the first headerSize bytes of the file at path
+func readHeader(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// DetectFormat returns the registered format of the file at path based on its content.
+// The file extension is only used as a hint when several formats share a signature,
+// such as "tar.gz" and "gz", or when the content matches no known signature.
+func DetectFormat(path string) (Format, error) {
+	header, err := readHeader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file header: %w", err)
+	}
+
+	hint, _ := ForPath(path)
+	f, err := detectHeader(header, hint)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, fmt.Errorf("unrecognized file format: %s", filepath.Base(path))
+	}
+	return f, nil
+}
+
+// detectHeader returns the registered format whose signature starts header. hint, which
+// may be nil, breaks ties between formats sharing a signature and is returned when the
+// content matches no known signature.
+func detectHeader(header []byte, hint Format) (Format, error) {
+	var matches []Format
+	for _, f := range Formats() {
+		if f.Detect(header) {
+			matches = append(matches, f)
+		}
+	}
+
+	switch {
+	case len(matches) == 1:
+		return matches[0], nil
+	case len(matches) > 1:
+		if hint != nil {
+			for _, f := range matches {
+				if f.Name() == hint.Name() {
+					return f, nil
+				}
+			}
+		}
+		return matches[0], nil
+	}
+
+	for _, sig := range unsupportedSignatures {
+		if bytes.HasPrefix(header, sig.magic) {
+			return nil, fmt.Errorf("unsupported archive format: %s", sig.name)
+		}
+	}
+	return hint, nil
+}
+
+// matchesContent reports whether the file at path has the signature of format
+func matchesContent(path string, format Format) bool {
+	header, err := readHeader(path)
+	if err != nil {
+		return false
+	}
+	return format.Detect(header)
+}
+
+// peekTar decompresses the start of header with c and checks for a tar header.
+// known is false when header is too short to decide.
+func peekTar(c *codec, header []byte) (isTar, known bool) {
+	reader, err := c.newReader(bytes.NewReader(header))
+	if err != nil {
+		return false, true
+	}
+	defer reader.Close()
+
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(reader, buf)
+	if n < 263 {
+		// A complete stream shorter than a tar header can't be a tarball
+		return false, len(header) < headerSize
+	}
+	return isTarHeader(buf[:n]), true
+}
@@ -0,0 +1,99 @@
+package archiver
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// misnamed compresses source to format and renames the archive to name
+func misnamed(t *testing.T, source, format, name string) string {
+	t.Helper()
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "archive."+format)
+	if err := CompressWithProgress(source, archive, format, CompressOptions{}, nil); err != nil {
+		t.Fatalf("Compress to %s: %v", format, err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.Rename(archive, path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDetectMisnamed(t *testing.T) {
+	source := t.TempDir()
+	writeFiles(t, source, roundTripTree)
+	single := filepath.Join(source, "readme.txt")
+
+	sevenZip, err := os.ReadFile(filepath.Join("testdata", "lzma2.7z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := filepath.Join(t.TempDir(), "upload.bin")
+	if err := os.WriteFile(binary, sevenZip, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		archive string
+		want    string
+		files   map[string]string
+	}{
+		{"zip saved as tgz", misnamed(t, source, "zip", "tree.tgz"), "zip", roundTripTree},
+		{"tar without an extension", misnamed(t, source, "tar", "tree"), "tar", roundTripTree},
+		{"tar.gz saved as gz", misnamed(t, source, "tar.gz", "tree.gz"), "tar.gz", roundTripTree},
+		{"tar.zst saved as zip", misnamed(t, source, "tar.zst", "tree.zip"), "tar.zst", roundTripTree},
+		{"gz saved as tgz", misnamed(t, single, "gz", "readme.txt.tgz"), "gz", nil},
+		{"7z saved as bin", binary, "7z", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := DetectFormat(tt.archive)
+			if err != nil || f.Name() != tt.want {
+				t.Fatalf("detected %v (%v), want %s", f, err, tt.want)
+			}
+			if tt.files == nil {
+				return
+			}
+			dest := t.TempDir()
+			if err := ExtractWithProgress(tt.archive, dest, ExtractOptions{}, nil); err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			checkExtracted(t, dest, tt.files)
+		})
+	}
+}
+
+func TestDetectUnknown(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		err     string
+	}{
+		// The extension is the fallback when no signature matches
+		{"notes.txt", "plain text", "", "unrecognized file format"},
+		{"data.zip", "plain text", "zip", ""},
+		{"archive.zip", "Rar!\x1A\x07\x01\x00", "", "unsupported archive format: rar"},
+		{"empty.tar.gz", "", "tar.gz", ""},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := DetectFormat(path)
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: detected %v (%v), want an error containing %q", tt.name, f, err, tt.err)
+			}
+		case err != nil || f.Name() != tt.want:
+			t.Errorf("%s: detected %v (%v), want %s", tt.name, f, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrFileExists is returned by OverwriteFail extractions when an entry's destination exists
+var ErrFileExists = errors.New("destination already exists")
+
+// ErrIllegalPath is matched by the errors of entries whose path or link target leads outside the destination
+var ErrIllegalPath = errors.New("path leads outside the destination")
+
+// Conflict describes an archive entry whose destination already exists
+type Conflict struct {
+	// Name is the name of the entry in the archive
+	Name string
+	// Path is the destination of the entry
+	Path string
+	// Modified is the modification time of the entry, zero if the archive does not record it
+	Modified time.Time
+	// Existing describes the file found at Path
+	Existing os.FileInfo
+}
+
+// ConflictFunc chooses how to resolve a conflict for OverwriteAsk.
+// It may return any policy except OverwriteAsk.
+type ConflictFunc func(conflict Conflict) OverwritePolicy
+
+// ExtractSummary lists the entries that were not extracted to their own path
+type ExtractSummary struct {
+	// Skipped holds the names of entries skipped because their destination existed
+	Skipped []string
+	// Renamed holds the entries extracted next to an existing file
+	Renamed []RenamedEntry
+	// Unlinked holds the names of hard links skipped because the options did not
+	// select the entry they link to
+	Unlinked []string
+}
+
+// RenamedEntry is an entry extracted to a new path because its destination existed
+type RenamedEntry struct {
+	Name string
+	Path string
+}
+
+// extractPath returns the location of an archive entry below destPath
+func extractPath(destPath, name string) (string, error) {
+	// Prepare full path for extraction
+	filePath := filepath.Join(destPath, name)
+
+	// Check for zip slip vulnerability (traversal attack)
+	if !strings.HasPrefix(filePath, filepath.Clean(destPath)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("illegal file path: %s: %w", filePath, ErrIllegalPath)
+	}
+	return filePath, nil
+}
+
+// checkLinkTarget verifies that a link stored at filePath and pointing to target stays inside destPath
+func checkLinkTarget(destPath, filePath, target string) error {
+	if filepath.IsAbs(target) {
+		return fmt.Errorf("illegal link target: %s -> %s: %w", filePath, target, ErrIllegalPath)
+	}
+	resolved := filepath.Join(filepath.Dir(filePath), target)
+	if !strings.HasPrefix(resolved, filepath.Clean(destPath)+string(os.PathSeparator)) {
+		return fmt.Errorf("illegal link target: %s -> %s: %w", filePath, target, ErrIllegalPath)
+	}
+	return nil
+}
+
+// newTempPath returns a free path next to path, for an entry being written or a file moved aside
+func newTempPath(path string) (string, error) {
+	file, err := createTempFile(path)
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+	return file.Name(), nil
+}
+
+// createTempFile creates a new file next to path with the default permissions of the process
+func createTempFile(path string) (*os.File, error) {
+	for {
+		name := filepath.Join(filepath.Dir(path), fmt.Sprintf(".extract-%08x.tmp", rand.Uint32()))
+		file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !os.IsExist(err) {
+			return file, err
+		}
+	}
+}
+
+// writeExtractedFile copies an archive entry to filePath. The entry is written next to it
+// first and replaces filePath once complete, so a failed entry leaves an existing file as
+// it was. guard limits the data copied and records the created file and directories.
+func writeExtractedFile(guard *extractGuard, filePath string, inFile io.Reader, progressTracker *ProgressTracker) (err error) {
+	// Create the directory tree for the file
+	if err := guard.mkdirAll(filepath.Dir(filePath)); err != nil {
+		return fmt.Errorf("failed to create directory structure: %w", err)
+	}
+
+	// Create the file
+	outFile, err := createTempFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer func() {
+		if closeErr := outFile.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to write to file: %w", closeErr)
+		}
+		if err == nil {
+			err = guard.replace(outFile.Name(), filePath)
+		}
+		if err != nil {
+			os.Remove(outFile.Name())
+		}
+	}()
+	inFile = guard.reader(inFile, filePath)
+
+	// Copy contents with progress tracking
+	buffer := make([]byte, 32*1024) // 32KB buffer
+	for {
+		bytesRead, err := inFile.Read(buffer)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read from archive: %w", err)
+		}
+
+		if bytesRead > 0 {
+			_, err := outFile.Write(buffer[:bytesRead])
+			if err != nil {
+				return fmt.Errorf("failed to write to file: %w", err)
+			}
+
+			// Update progress
+			progressTracker.AddProgress(int64(bytesRead))
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	return nil
+}
+
+// resolveConflict applies the overwrite policy of o to the entry name about to be written to filePath.
+// It returns the path to write the entry to, or "" if the entry is skipped. Existing files are
+// replaced once the entry is written, by renaming it over them, so links at the destination
+// are never followed.
+func (o ExtractOptions) resolveConflict(name, filePath string, modified time.Time) (string, error) {
+	existing, err := os.Lstat(filePath)
+	if os.IsNotExist(err) {
+		return filePath, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to check destination: %w", err)
+	}
+
+	policy := o.Overwrite
+	if policy == OverwriteAsk {
+		policy = o.OnConflict(Conflict{Name: name, Path: filePath, Modified: modified, Existing: existing})
+	}
+
+	switch policy {
+	case OverwriteAlways:
+	case OverwriteIfNewer:
+		// Without a time in the archive the entry cannot be newer
+		if modified.IsZero() || !modified.After(existing.ModTime()) {
+			o.Summary.skip(name)
+			return "", nil
+		}
+	case OverwriteSkip:
+		o.Summary.skip(name)
+		return "", nil
+	case OverwriteRename:
+		renamed, err := renamedPath(filePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to rename %s: %w", name, err)
+		}
+		o.Summary.rename(name, renamed)
+		return renamed, nil
+	case OverwriteFail:
+		return "", fmt.Errorf("%s: %w", filePath, ErrFileExists)
+	default:
+		return "", fmt.Errorf("invalid conflict resolution for %s: %v", name, policy)
+	}
+
+	return filePath, nil
+}
+
+// renamedPath returns the first free path of the form "name (n).ext" next to filePath
+func renamedPath(filePath string) (string, error) {
+	ext := filepath.Ext(filePath)
+	if ext == filepath.Base(filePath) {
+		// Dot files such as ".profile" have no extension to keep
+		ext = ""
+	}
+	base := strings.TrimSuffix(filePath, ext)
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", base, i, ext)
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate, nil
+		} else if err != nil {
+			return "", err
+		}
+	}
+}
+
+func (s *ExtractSummary) skip(name string) {
+	if s != nil {
+		s.Skipped = append(s.Skipped, name)
+	}
+}
+
+func (s *ExtractSummary) rename(name, path string) {
+	if s != nil {
+		s.Renamed = append(s.Renamed, RenamedEntry{Name: name, Path: path})
+	}
+}
+
+func (s *ExtractSummary) unlink(name string) {
+	if s != nil {
+		s.Unlinked = append(s.Unlinked, name)
+	}
+}
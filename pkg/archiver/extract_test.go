@@ -0,0 +1,144 @@
+package archiver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// conflictArchive writes an archive whose a.txt is newer and b.txt older than the
+// files of the destination returned by conflictDestination
+func conflictArchive(t *testing.T) string {
+	t.Helper()
+	archive := filepath.Join(t.TempDir(), "conflict.tar")
+	writeTestTar(t, archive, []testEntry{
+		{name: "a.txt", content: "NEW", modified: fixtureTime(10)},
+		{name: "b.txt", content: "NEW", modified: fixtureTime(10)},
+		{name: "c.txt", content: "NEW", modified: fixtureTime(10)},
+	})
+	return archive
+}
+
+// conflictDestination returns a destination holding an older a.txt, a newer b.txt
+// and "a (1).txt", which takes the first renamed name of a.txt
+func conflictDestination(t *testing.T) string {
+	t.Helper()
+	dest := t.TempDir()
+	for name, modified := range map[string]int{"a.txt": 5, "b.txt": 15, "a (1).txt": 5} {
+		path := filepath.Join(dest, name)
+		if err := os.WriteFile(path, []byte("OLD"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, fixtureTime(modified), fixtureTime(modified)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dest
+}
+
+func TestExtractOverwritePolicies(t *testing.T) {
+	tests := []struct {
+		policy  OverwritePolicy
+		want    map[string]string
+		summary ExtractSummary
+	}{
+		{
+			policy: OverwriteAlways,
+			want:   map[string]string{"a.txt": "NEW", "b.txt": "NEW", "c.txt": "NEW", "a (1).txt": "OLD"},
+		},
+		{
+			policy:  OverwriteSkip,
+			want:    map[string]string{"a.txt": "OLD", "b.txt": "OLD", "c.txt": "NEW", "a (1).txt": "OLD"},
+			summary: ExtractSummary{Skipped: []string{"a.txt", "b.txt"}},
+		},
+		{
+			policy:  OverwriteIfNewer,
+			want:    map[string]string{"a.txt": "NEW", "b.txt": "OLD", "c.txt": "NEW", "a (1).txt": "OLD"},
+			summary: ExtractSummary{Skipped: []string{"b.txt"}},
+		},
+		{
+			policy: OverwriteRename,
+			want: map[string]string{
+				"a.txt": "OLD", "a (1).txt": "OLD", "a (2).txt": "NEW",
+				"b.txt": "OLD", "b (1).txt": "NEW", "c.txt": "NEW",
+			},
+			summary: ExtractSummary{Renamed: []RenamedEntry{{Name: "a.txt", Path: "a (2).txt"}, {Name: "b.txt", Path: "b (1).txt"}}},
+		},
+	}
+	archive := conflictArchive(t)
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			dest := conflictDestination(t)
+			var summary ExtractSummary
+			if err := ExtractWithProgress(archive, dest, ExtractOptions{Overwrite: tt.policy, Summary: &summary}, nil); err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			checkDestination(t, dest, tt.want)
+
+			// Renamed paths are reported below the destination
+			for i, renamed := range summary.Renamed {
+				if rel, err := filepath.Rel(dest, renamed.Path); err == nil {
+					summary.Renamed[i].Path = rel
+				}
+			}
+			if !reflect.DeepEqual(summary, tt.summary) {
+				t.Errorf("summary %+v, want %+v", summary, tt.summary)
+			}
+		})
+	}
+}
+
+func TestExtractOverwriteFail(t *testing.T) {
+	dest := conflictDestination(t)
+	err := ExtractWithProgress(conflictArchive(t), dest, ExtractOptions{Overwrite: OverwriteFail}, nil)
+	if !errors.Is(err, ErrFileExists) {
+		t.Fatalf("error %v, want %v", err, ErrFileExists)
+	}
+	checkDestination(t, dest, map[string]string{"a.txt": "OLD", "b.txt": "OLD", "a (1).txt": "OLD"})
+}
+
+func TestExtractOverwriteAsk(t *testing.T) {
+	dest := conflictDestination(t)
+	var conflicts []string
+	opts := ExtractOptions{
+		Overwrite: OverwriteAsk,
+		OnConflict: func(conflict Conflict) OverwritePolicy {
+			conflicts = append(conflicts, conflict.Name)
+			if !conflict.Modified.Equal(fixtureTime(10)) || conflict.Path != filepath.Join(dest, conflict.Name) {
+				t.Errorf("conflict %+v, want the entry time and destination", conflict)
+			}
+			if conflict.Name == "a.txt" {
+				return OverwriteSkip
+			}
+			return OverwriteAlways
+		},
+	}
+	if err := ExtractWithProgress(conflictArchive(t), dest, opts, nil); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	if !reflect.DeepEqual(conflicts, []string{"a.txt", "b.txt"}) {
+		t.Errorf("conflicts %v, want a.txt and b.txt", conflicts)
+	}
+	checkDestination(t, dest, map[string]string{"a.txt": "OLD", "b.txt": "NEW", "c.txt": "NEW", "a (1).txt": "OLD"})
+}
+
+func TestRenamedPath(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"report.tar.gz", ".profile", "notes"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{
+		"report.tar.gz": "report.tar (1).gz",
+		".profile":      ".profile (1)",
+		"notes":         "notes (1)",
+	} {
+		got, err := renamedPath(filepath.Join(dir, name))
+		if err != nil || got != filepath.Join(dir, want) {
+			t.Errorf("renamedPath(%s) = %s (%v), want %s", name, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package archiver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnsupported is returned when a format does not support the requested operation
+var ErrUnsupported = errors.New("operation not supported by format")
+
+// Capability describes which operations a format supports
+type Capability uint8
+
+const (
+	// CanCompress indicates that the format can create output files
+	CanCompress Capability = 1 << iota
+	// CanExtract indicates that the format can unpack its files
+	CanExtract
+	// CanList indicates that the format can list its contents without extracting
+	CanList
+	// CanEncrypt indicates that the format can protect its contents with a password
+	CanEncrypt
+	// CanBundle indicates that the format can store several sources in one archive
+	CanBundle
+)
+
+// Entry describes a single file or directory stored in an archive
+type Entry struct {
+	Name string
+	Size int64
+	// CompressedSize is the stored size of the entry, zero if the format only
+	// compresses the archive as a whole (tar, solid 7z)
+	CompressedSize int64
+	// Method is the compression method of the entry, e.g. "deflate" or "lzma2"
+	Method string
+	// CRC32 is the checksum of the entry data, zero if the format does not store one
+	CRC32     uint32
+	Encrypted bool
+	Mode      os.FileMode
+	Modified  time.Time
+	// Link is the target of a symlink or hard link, for formats that record it in their listing
+	Link string
+}
+
+// IsDir reports whether the entry is a directory
+func (e Entry) IsDir() bool {
+	return e.Mode.IsDir()
+}
+
+// MarshalJSON encodes the entry with lower case keys and the mode in ls notation
+func (e Entry) MarshalJSON() ([]byte, error) {
+	var crc string
+	if e.CRC32 != 0 {
+		crc = fmt.Sprintf("%08x", e.CRC32)
+	}
+	return json.Marshal(struct {
+		Name           string    `json:"name"`
+		Size           int64     `json:"size"`
+		CompressedSize int64     `json:"compressedSize"`
+		Method         string    `json:"method,omitempty"`
+		CRC32          string    `json:"crc32,omitempty"`
+		Encrypted      bool      `json:"encrypted"`
+		Dir            bool      `json:"dir"`
+		Mode           string    `json:"mode"`
+		Modified       time.Time `json:"modified"`
+		Link           string    `json:"link,omitempty"`
+	}{
+		Name:           e.Name,
+		Size:           e.Size,
+		CompressedSize: e.CompressedSize,
+		Method:         e.Method,
+		CRC32:          crc,
+		Encrypted:      e.Encrypted,
+		Dir:            e.IsDir(),
+		Mode:           e.Mode.String(),
+		Modified:       e.Modified,
+		Link:           e.Link,
+	})
+}
+
+// Format is implemented by every compression or archive format known to the archiver.
+// Formats are made available to Compress, Extract and the frontends through Register.
+type Format interface {
+	// Name returns the short identifier of the format, e.g. "zip"
+	Name() string
+	// Extensions returns the file extensions (with leading dot) used by the format.
+	// The first extension is used when generating output file names.
+	Extensions() []string
+	// Capabilities reports which operations the format supports
+	Capabilities() Capability
+	// Detect reports whether header, the first bytes of a file, belongs to this format
+	Detect(header []byte) bool
+	// Compress writes sources to destPath. Formats without CanBundle accept a single
+	// source and ignore its prefix. progressTracker may be nil.
+	Compress(sources []Source, destPath string, opts CompressOptions, progressTracker *ProgressTracker) error
+	// Extract unpacks the entries of the archive at sourcePath selected by opts
+	// into the destPath directory. progressTracker may be nil.
+	Extract(sourcePath, destPath string, opts ExtractOptions, progressTracker *ProgressTracker) error
+	// List returns the entries stored in the archive at sourcePath
+	List(sourcePath string) ([]Entry, error)
+	// Test decompresses every entry of the archive at sourcePath and verifies it
+	Test(sourcePath, password string) (*TestResult, error)
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   []Format
+)
+
+func init() {
+	// Built-in formats, in the order they are offered to users
+	Register(zipFormat{})
+	Register(tarFormat{})
+	Register(tarFormat{codec: gzipCodec, extensions: []string{".tar.gz", ".tgz"}})
+	Register(tarFormat{codec: xzCodec, extensions: []string{".tar.xz", ".txz"}})
+	Register(tarFormat{codec: bzip2Codec, extensions: []string{".tar.bz2", ".tbz2", ".tbz"}})
+	Register(tarFormat{codec: zstdCodec, extensions: []string{".tar.zst", ".tzst"}})
+	Register(streamFormat{codec: gzipCodec})
+	Register(streamFormat{codec: xzCodec})
+	Register(streamFormat{codec: bzip2Codec})
+	Register(streamFormat{codec: zstdCodec})
+	Register(sevenZipFormat{})
+	Register(pdfFormat{})
+	Register(pngFormat{})
+	Register(jpegFormat{})
+}
+
+// Register makes a format available to the archiver and all frontends.
+// It panics if a format with the same name is already registered.
+func Register(format Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+
+	name := strings.ToLower(format.Name())
+	for _, f := range formats {
+		if strings.ToLower(f.Name()) == name {
+			panic(fmt.Sprintf("archiver: format %q registered twice", name))
+		}
+	}
+	formats = append(formats, format)
+}
+
+// Formats returns all registered formats in registration order
+func Formats() []Format {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+
+	return append([]Format(nil), formats...)
+}
+
+// FormatNames returns the names of the registered formats supporting all capabilities in caps
+func FormatNames(caps Capability) []string {
+	var names []string
+	for _, f := range Formats() {
+		if f.Capabilities()&caps == caps {
+			names = append(names, f.Name())
+		}
+	}
+	return names
+}
+
+// Lookup returns the format registered under name.
+// Extensions are accepted as aliases, so "jpeg" finds the format registered as "jpg".
+func Lookup(name string) (Format, bool) {
+	name = strings.TrimPrefix(strings.ToLower(name), ".")
+	all := Formats()
+
+	for _, f := range all {
+		if strings.ToLower(f.Name()) == name {
+			return f, true
+		}
+	}
+	for _, f := range all {
+		for _, ext := range f.Extensions() {
+			if strings.TrimPrefix(strings.ToLower(ext), ".") == name {
+				return f, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// ForPath returns the format matching the extension of path.
+// The longest matching extension wins, so "backup.tar.gz" selects "tar.gz" rather than a ".gz" format.
+func ForPath(path string) (Format, bool) {
+	lower := strings.ToLower(path)
+
+	var match Format
+	var matchLen int
+	for _, f := range Formats() {
+		for _, ext := range f.Extensions() {
+			ext = strings.ToLower(ext)
+			if len(ext) > matchLen && strings.HasSuffix(lower, ext) {
+				match, matchLen = f, len(ext)
+			}
+		}
+	}
+	return match, match != nil
+}
@@ -0,0 +1,100 @@
+package archiver
+
+import (
+	"slices"
+	"testing"
+)
+
+// namedFormat is a format that only has a name, for registry tests
+type namedFormat struct {
+	Format
+	name string
+}
+
+func (f namedFormat) Name() string { return f.name }
+
+func TestRegisterTwice(t *testing.T) {
+	before := len(Formats())
+	for _, name := range []string{"zip", "TAR.GZ"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %q again did not panic", name)
+				}
+			}()
+			Register(namedFormat{name: name})
+		}()
+	}
+	if after := len(Formats()); after != before {
+		t.Errorf("%d formats after the failed registrations, want %d", after, before)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"zip", "zip"},
+		{".ZIP", "zip"},
+		{"tar.gz", "tar.gz"},
+		{"tgz", "tar.gz"},
+		{".tbz", "tar.bz2"},
+		{"gz", "gz"},
+		{"jpeg", "jpg"},
+		{"JPG", "jpg"},
+		{"rar", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		f, ok := Lookup(tt.name)
+		switch {
+		case tt.want == "" && ok:
+			t.Errorf("Lookup(%q) = %s, want none", tt.name, f.Name())
+		case tt.want != "" && (!ok || f.Name() != tt.want):
+			t.Errorf("Lookup(%q) = %v, %v, want %s", tt.name, f, ok, tt.want)
+		}
+	}
+}
+
+func TestForPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"backup.tar.gz", "tar.gz"},
+		{"backup.gz", "gz"},
+		{"dir/BACKUP.TGZ", "tar.gz"},
+		{"notes.txt.xz", "xz"},
+		{"backup.tar.xz", "tar.xz"},
+		{"backup.tar", "tar"},
+		{"photo.jpeg", "jpg"},
+		{"archive.7z", "7z"},
+		// An extension needs a name before it
+		{"tar.gz", "gz"},
+		{"notes.txt", ""},
+		{"gz", ""},
+	}
+	for _, tt := range tests {
+		f, ok := ForPath(tt.path)
+		switch {
+		case tt.want == "" && ok:
+			t.Errorf("ForPath(%q) = %s, want none", tt.path, f.Name())
+		case tt.want != "" && (!ok || f.Name() != tt.want):
+			t.Errorf("ForPath(%q) = %v, %v, want %s", tt.path, f, ok, tt.want)
+		}
+	}
+}
+
+func TestFormatNames(t *testing.T) {
+	// bzip2 is extract only, so neither of its formats compresses
+	names := FormatNames(CanCompress)
+	for _, name := range []string{"tar.bz2", "bz2"} {
+		if slices.Contains(names, name) {
+			t.Errorf("%s offered for compression", name)
+		}
+	}
+	if bundles := FormatNames(CanCompress | CanBundle); !slices.Contains(bundles, "zip") || slices.Contains(bundles, "gz") {
+		t.Errorf("bundling formats %v, want zip without gz", bundles)
+	}
+}
@@ -0,0 +1,84 @@
+package archiver
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// validatePattern reports a malformed pattern
+func validatePattern(pattern string) error {
+	for _, elem := range strings.Split(pattern, "/") {
+		if elem == "**" {
+			continue
+		}
+		if _, err := path.Match(elem, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePatterns checks every pattern of the given lists
+func validatePatterns(lists ...[]string) error {
+	for _, patterns := range lists {
+		for _, pattern := range patterns {
+			if err := validatePattern(pattern); err != nil {
+				return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// cleanEntryName returns name as a slash separated relative path without a trailing slash
+func cleanEntryName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Clean("/" + name)
+	return strings.TrimPrefix(name, "/")
+}
+
+// matchEntry reports whether pattern matches the entry name or one of its parent directories.
+// Patterns are slash separated paths where "*", "?" and "[...]" match within a path element,
+// as in path.Match, and "**" matches any number of directories. A pattern without a slash
+// matches the base name of an entry at any depth.
+func matchEntry(pattern, name string) bool {
+	pattern = strings.Trim(pattern, "/")
+	name = cleanEntryName(name)
+	baseOnly := !strings.Contains(pattern, "/")
+
+	for name != "" && name != "." {
+		if baseOnly {
+			if ok, _ := path.Match(pattern, path.Base(name)); ok {
+				return true
+			}
+		} else if matchElements(strings.Split(pattern, "/"), strings.Split(name, "/")) {
+			return true
+		}
+		name = path.Dir(name)
+	}
+	return false
+}
+
+// matchElements matches path elements against pattern elements, expanding "**"
+func matchElements(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try every number of skipped directories, including none
+			for skip := 0; skip <= len(elems); skip++ {
+				if matchElements(pattern[1:], elems[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
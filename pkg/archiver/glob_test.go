@@ -0,0 +1,188 @@
+package archiver
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestMatchEntry(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		// Patterns without a slash match base names at any depth
+		{"*.txt", "a.txt", true},
+		{"*.txt", "docs/deep/a.txt", true},
+		{"*.txt", "a.txt.bak", false},
+		{"cache", "src/cache/entry.bin", true},
+		// Patterns with a slash match from the root
+		{"docs/*.md", "docs/readme.md", true},
+		{"docs/*.md", "src/docs/readme.md", false},
+		{"docs/*.md", "docs/sub/readme.md", false},
+		{"/docs/", "docs/readme.md", true},
+		// "**" matches any number of directories, including none
+		{"docs/**/*.md", "docs/readme.md", true},
+		{"docs/**/*.md", "docs/a/b/c/readme.md", true},
+		{"docs/**/*.md", "src/readme.md", false},
+		{"**/test/*.go", "test/a.go", true},
+		{"**/test/*.go", "pkg/x/test/a.go", true},
+		{"**/test/*.go", "pkg/x/test/sub/a.go", false},
+		{"config/**", "config/app/settings.yaml", true},
+		{"config/**", "configs/app.yaml", false},
+		// A matching directory selects everything below it
+		{"build", "build/out/app", true},
+		{"src/gen", "src/gen/a/b.go", true},
+		// Character classes and single characters stay within an element
+		{"file?.log", "logs/file1.log", true},
+		{"file[0-9].log", "filex.log", false},
+		{"a*b", "a/b", false},
+		// Names are cleaned before they are matched
+		{"docs/*.md", "./docs/readme.md", true},
+		{"docs/*.md", "docs\\readme.md", true},
+	}
+	for _, tt := range tests {
+		if got := matchEntry(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchEntry(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExtractSelects(t *testing.T) {
+	names := []string{"README.md", "docs/guide.md", "docs/img/logo.png", "src/main.go", "src/main_test.go", "src/vendor/lib.go"}
+	tests := []struct {
+		name string
+		opts ExtractOptions
+		want []string
+	}{
+		{"everything", ExtractOptions{}, names},
+		{"include", ExtractOptions{Include: []string{"*.md"}}, []string{"README.md", "docs/guide.md"}},
+		{"double star", ExtractOptions{Include: []string{"src/**/*.go"}}, []string{"src/main.go", "src/main_test.go", "src/vendor/lib.go"}},
+		{"exclude", ExtractOptions{Exclude: []string{"*_test.go", "vendor"}}, []string{"README.md", "docs/guide.md", "docs/img/logo.png", "src/main.go"}},
+		{
+			"include and exclude",
+			ExtractOptions{Include: []string{"src"}, Exclude: []string{"*_test.go"}},
+			[]string{"src/main.go", "src/vendor/lib.go"},
+		},
+		{"directory path", ExtractOptions{Paths: []string{"docs"}}, []string{"docs/guide.md", "docs/img/logo.png"}},
+		{"file path", ExtractOptions{Paths: []string{"./src/main.go"}}, []string{"src/main.go"}},
+		{"path prefix", ExtractOptions{Paths: []string{"src/main"}}, nil},
+		{
+			"paths or include",
+			ExtractOptions{Paths: []string{"docs/img"}, Include: []string{"README.md"}},
+			[]string{"README.md", "docs/img/logo.png"},
+		},
+		{
+			"paths and exclude",
+			ExtractOptions{Paths: []string{"docs"}, Exclude: []string{"*.png"}},
+			[]string{"docs/guide.md"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, name := range names {
+				if tt.opts.selects(name) {
+					got = append(got, name)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selected %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	for _, pattern := range []string{"*.txt", "docs/**/*.md", "**", "file[0-9]"} {
+		if err := validatePatterns([]string{pattern}); err != nil {
+			t.Errorf("%q: %v", pattern, err)
+		}
+	}
+	for _, pattern := range []string{"file[", "docs/[a-/x"} {
+		if err := validatePatterns(nil, []string{pattern}); err == nil {
+			t.Errorf("%q accepted, want an error", pattern)
+		}
+	}
+	if err := ExtractWithProgress("unused.zip", t.TempDir(), ExtractOptions{Include: []string{"["}}, nil); err == nil {
+		t.Error("extraction with a malformed pattern, want an error")
+	}
+}
+
+// extractedFiles returns the slash separated names of the regular files below dest, sorted
+func extractedFiles(t *testing.T, dest string) []string {
+	t.Helper()
+	var names []string
+	err := filepath.WalkDir(dest, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dest, path)
+		names = append(names, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestExtractSelective(t *testing.T) {
+	source := t.TempDir()
+	for _, name := range []string{"README.md", "docs/guide.md", "docs/img/logo.png", "src/main.go", "src/main_test.go"} {
+		path := filepath.Join(source, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archives := map[string]string{"7z": filepath.Join("testdata", "lzma2.7z")}
+	for _, format := range []string{"zip", "tar.gz"} {
+		archives[format] = filepath.Join(t.TempDir(), "tree."+format)
+		if err := CompressWithProgress(source, archives[format], format, CompressOptions{}, nil); err != nil {
+			t.Fatalf("Compress: %v", err)
+		}
+	}
+
+	opts := ExtractOptions{Paths: []string{"docs"}, Include: []string{"src/**/*.go"}, Exclude: []string{"*_test.go", "*.png"}}
+	want := map[string][]string{
+		"zip":    {"docs/guide.md", "src/main.go"},
+		"tar.gz": {"docs/guide.md", "src/main.go"},
+		"7z":     {"docs/lorem.txt"},
+	}
+	for format, archive := range archives {
+		t.Run(format, func(t *testing.T) {
+			dest := t.TempDir()
+			if err := ExtractWithProgress(archive, dest, opts, nil); err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			if got := extractedFiles(t, dest); !slices.Equal(got, want[format]) {
+				t.Errorf("extracted %v, want %v", got, want[format])
+			}
+
+			// A sink gets the same entries as the disk
+			sink := newMemorySink()
+			data, err := os.ReadFile(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ExtractReader(bytes.NewReader(data), sink, opts); err != nil {
+				t.Fatalf("ExtractReader: %v", err)
+			}
+			var names []string
+			for name := range sink.files {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			if !slices.Equal(names, want[format]) {
+				t.Errorf("sink got %v, want %v", names, want[format])
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package archiver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// ignoreFileNames are the files read by CompressOptions.UseIgnoreFiles in every directory
+var ignoreFileNames = []string{".gitignore", ".compressignore"}
+
+// ReadPatterns reads the patterns of a file such as an --exclude-from list, one per line.
+// Empty lines and lines starting with "#" are skipped.
+func ReadPatterns(filePath string) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pattern file: %w", err)
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read pattern file: %w", err)
+	}
+	if err := validatePatterns(patterns); err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+	return patterns, nil
+}
+
+// ignoreRule is a line of a .gitignore or .compressignore file
+type ignoreRule struct {
+	// base is the directory of the ignore file, slash separated and relative to the
+	// compressed directory, or "" for the compressed directory itself
+	base    string
+	pattern string
+	// anchored patterns match paths relative to base, the others match base names at any depth
+	anchored bool
+	negate   bool
+	dirOnly  bool
+}
+
+// ignoreRules holds the rules of the ignore files found so far, parents first
+type ignoreRules []ignoreRule
+
+// load adds the rules of the ignore files in the directory base of fsys, the compressed
+// directory, or its root for ""
+func (r *ignoreRules) load(fsys fs.FS, base string) error {
+	for _, name := range ignoreFileNames {
+		data, err := fs.ReadFile(fsys, path.Join(base, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read ignore file: %w", err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if rule, ok := parseIgnoreRule(line, base); ok {
+				*r = append(*r, rule)
+			}
+		}
+	}
+	return nil
+}
+
+// parseIgnoreRule parses a line of an ignore file with the syntax of .gitignore.
+// It returns false for empty lines, comments and malformed patterns.
+func parseIgnoreRule(line, base string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		// "\#" and "\!" escape the first character
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	// A slash at the start or in the middle anchors the pattern to the directory of the file
+	rule.anchored = strings.Contains(line, "/")
+	rule.pattern = strings.TrimPrefix(line, "/")
+
+	if rule.pattern == "" || validatePattern(rule.pattern) != nil {
+		return ignoreRule{}, false
+	}
+	return rule, true
+}
+
+// ignored reports whether the entry name, slash separated and relative to the compressed
+// directory, is ignored. Like in git, the last matching rule wins.
+func (r ignoreRules) ignored(name string, isDir bool) bool {
+	ignored := false
+	for _, rule := range r {
+		if rule.matches(name, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matches reports whether the rule applies to the entry name
+func (rule ignoreRule) matches(name string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	if rule.base != "" {
+		if !strings.HasPrefix(name, rule.base+"/") {
+			return false
+		}
+		name = name[len(rule.base)+1:]
+	}
+	if !rule.anchored {
+		ok, _ := path.Match(rule.pattern, path.Base(name))
+		return ok
+	}
+	return matchElements(strings.Split(rule.pattern, "/"), strings.Split(name, "/"))
+}
@@ -0,0 +1,271 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/image/draw"
+)
+
+// imageStages are the stages of the compression of an image
+var imageStages = []string{StageDecoding, StageResizing, StageEncoding}
+
+// pngFormat recompresses PNG images
+type pngFormat struct{}
+
+func (pngFormat) Name() string             { return "png" }
+func (pngFormat) Extensions() []string     { return []string{".png"} }
+func (pngFormat) Capabilities() Capability { return CanCompress }
+
+func (pngFormat) Detect(header []byte) bool {
+	return bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n"))
+}
+
+func (f pngFormat) Compress(sources []Source, destPath string, opts CompressOptions, progressTracker *ProgressTracker) error {
+	sourcePath, err := singleSource(f, sources)
+	if err != nil {
+		return err
+	}
+	if !matchesContent(sourcePath, f) {
+		return fmt.Errorf("source file must be a PNG for PNG compression")
+	}
+	return compressPNG(opts.context(), sourcePath, destPath, opts.Level, progressTracker)
+}
+
+func (pngFormat) compressStages() []string { return imageStages }
+
+func (pngFormat) Extract(sourcePath, destPath string, opts ExtractOptions, progressTracker *ProgressTracker) error {
+	return fmt.Errorf("png extraction: %w", ErrUnsupported)
+}
+
+func (pngFormat) List(sourcePath string) ([]Entry, error) {
+	return nil, fmt.Errorf("png listing: %w", ErrUnsupported)
+}
+
+func (pngFormat) Test(sourcePath, password string) (*TestResult, error) {
+	return nil, fmt.Errorf("png testing: %w", ErrUnsupported)
+}
+
+// jpegFormat recompresses JPEG images
+type jpegFormat struct{}
+
+func (jpegFormat) Name() string             { return "jpg" }
+func (jpegFormat) Extensions() []string     { return []string{".jpg", ".jpeg"} }
+func (jpegFormat) Capabilities() Capability { return CanCompress }
+
+func (jpegFormat) Detect(header []byte) bool {
+	return bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF})
+}
+
+func (f jpegFormat) Compress(sources []Source, destPath string, opts CompressOptions, progressTracker *ProgressTracker) error {
+	sourcePath, err := singleSource(f, sources)
+	if err != nil {
+		return err
+	}
+	if !matchesContent(sourcePath, f) {
+		return fmt.Errorf("source file must be a JPEG for JPEG compression")
+	}
+	return compressJPEG(opts.context(), sourcePath, destPath, progressTracker)
+}
+
+func (jpegFormat) compressStages() []string { return imageStages }
+
+func (jpegFormat) Extract(sourcePath, destPath string, opts ExtractOptions, progressTracker *ProgressTracker) error {
+	return fmt.Errorf("jpeg extraction: %w", ErrUnsupported)
+}
+
+func (jpegFormat) List(sourcePath string) ([]Entry, error) {
+	return nil, fmt.Errorf("jpeg listing: %w", ErrUnsupported)
+}
+
+func (jpegFormat) Test(sourcePath, password string) (*TestResult, error) {
+	return nil, fmt.Errorf("jpeg testing: %w", ErrUnsupported)
+}
+
+// compressPNG compresses a PNG image, with best compression unless level asks otherwise.
+// Canceling ctx stops decoding the image, or writing it once it is resized. progressTracker,
+// which may be nil, follows the stages of imageStages after the first one.
+func compressPNG(ctx context.Context, sourcePath, destPath string, level CompressionLevel, progressTracker *ProgressTracker) error {
+	// Open the source file
+	srcFile, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer srcFile.Close()
+	info, err := srcFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+	name := filepath.Base(sourcePath)
+	progressTracker.StartFile(name)
+
+	// Decode the PNG image
+	img, err := png.Decode(NewProgressReader(newContextReader(ctx, srcFile), progressTracker))
+	if err != nil {
+		return fmt.Errorf("failed to decode PNG: %w", err)
+	}
+	progressTracker.SetStage(StageResizing)
+
+	// Get original dimensions
+	originalBounds := img.Bounds()
+	originalWidth := originalBounds.Dx()
+	originalHeight := originalBounds.Dy()
+
+	// Calculate new dimensions (reduce to 80% if large enough)
+	var newWidth, newHeight int
+	if originalWidth > 1000 || originalHeight > 1000 {
+		// For large images, reduce more aggressively
+		newWidth = originalWidth * 7 / 10
+		newHeight = originalHeight * 7 / 10
+	} else if originalWidth > 500 || originalHeight > 500 {
+		// For medium images, reduce moderately
+		newWidth = originalWidth * 8 / 10
+		newHeight = originalHeight * 8 / 10
+	} else {
+		// For small images, don't resize
+		newWidth = originalWidth
+		newHeight = originalHeight
+	}
+
+	// Only resize if dimensions changed
+	if newWidth != originalWidth || newHeight != originalHeight {
+		// Create a new RGBA image
+		dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+
+		// Resize the image using high-quality resampling
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, originalBounds, draw.Over, nil)
+
+		// Use the resized image for compression
+		img = dst
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	progressTracker.SetStage(StageEncoding)
+
+	// Create the destination file
+	dstFile, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer dstFile.Close()
+	output := &countingWriter{w: dstFile}
+
+	// Create a PNG encoder with the selected compression level
+	encoder := png.Encoder{
+		CompressionLevel: pngLevel(level),
+	}
+
+	// Encode the image
+	if err := encoder.Encode(output, img); err != nil {
+		return fmt.Errorf("failed to encode PNG: %w", err)
+	}
+
+	progressTracker.FinishFile(name, info.Size(), output.n)
+	progressTracker.SetComplete()
+	return nil
+}
+
+// pngLevel maps a CompressionLevel to the PNG encoder level
+func pngLevel(level CompressionLevel) png.CompressionLevel {
+	switch level {
+	case LevelStore:
+		return png.NoCompression
+	case LevelFastest:
+		return png.BestSpeed
+	case LevelNormal:
+		return png.DefaultCompression
+	}
+	return png.BestCompression
+}
+
+// compressJPEG compresses a JPEG image with high compression.
+// Canceling ctx stops decoding the image, or writing it once it is resized. progressTracker,
+// which may be nil, follows the stages of imageStages after the first one.
+func compressJPEG(ctx context.Context, sourcePath, destPath string, progressTracker *ProgressTracker) error {
+	// Open the source file
+	srcFile, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer srcFile.Close()
+	info, err := srcFile.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+	name := filepath.Base(sourcePath)
+	progressTracker.StartFile(name)
+
+	// Decode the JPEG image
+	img, err := jpeg.Decode(NewProgressReader(newContextReader(ctx, srcFile), progressTracker))
+	if err != nil {
+		return fmt.Errorf("failed to decode JPEG: %w", err)
+	}
+	progressTracker.SetStage(StageResizing)
+
+	// Get original dimensions
+	originalBounds := img.Bounds()
+	originalWidth := originalBounds.Dx()
+	originalHeight := originalBounds.Dy()
+
+	// Calculate new dimensions (reduce to 80% if large enough)
+	var newWidth, newHeight int
+	if originalWidth > 1000 || originalHeight > 1000 {
+		// For large images, reduce more aggressively
+		newWidth = originalWidth * 7 / 10
+		newHeight = originalHeight * 7 / 10
+	} else if originalWidth > 500 || originalHeight > 500 {
+		// For medium images, reduce moderately
+		newWidth = originalWidth * 8 / 10
+		newHeight = originalHeight * 8 / 10
+	} else {
+		// For small images, don't resize
+		newWidth = originalWidth
+		newHeight = originalHeight
+	}
+
+	// Only resize if dimensions changed
+	if newWidth != originalWidth || newHeight != originalHeight {
+		// Create a new RGBA image
+		dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+
+		// Resize the image using high-quality resampling
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, originalBounds, draw.Over, nil)
+
+		// Use the resized image for compression
+		img = dst
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	progressTracker.SetStage(StageEncoding)
+
+	// Create the destination file
+	dstFile, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer dstFile.Close()
+	output := &countingWriter{w: dstFile}
+
+	// Encode the image with maximum compression (quality 1 for absolute maximum compression)
+	options := jpeg.Options{
+		Quality: 1, // Lowest quality = highest compression
+	}
+
+	if err := jpeg.Encode(output, img, &options); err != nil {
+		return fmt.Errorf("failed to encode JPEG: %w", err)
+	}
+
+	progressTracker.FinishFile(name, info.Size(), output.n)
+	progressTracker.SetComplete()
+	return nil
+}
@@ -0,0 +1,319 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrLimitExceeded is matched by the errors of extractions stopped by ExtractLimits
+var ErrLimitExceeded = errors.New("extraction limit exceeded")
+
+// ExtractLimits bounds the resources used by an extraction, protecting against
+// archive bombs. Sizes are counted while data is written, so archives that lie
+// about their sizes are stopped too. Zero fields are unlimited.
+type ExtractLimits struct {
+	// MaxTotalSize is the number of bytes all extracted files may hold together
+	MaxTotalSize int64
+	// MaxFileSize is the number of bytes a single extracted file may hold
+	MaxFileSize int64
+	// MaxEntries is the number of entries that may be extracted
+	MaxEntries int
+	// MaxRatio is the largest allowed ratio of extracted bytes to the archive file size
+	MaxRatio float64
+	// MaxDepth is the largest number of path elements of an entry name
+	MaxDepth int
+	// MaxInputSize is the number of bytes the archive itself may hold. It bounds the
+	// data ExtractReader reads from a stream of unknown length, and buffers for ZIP and 7z.
+	MaxInputSize int64
+}
+
+// LimitError reports which limit an extraction exceeded. It matches ErrLimitExceeded.
+type LimitError struct {
+	// Limit names the exceeded limit, e.g. "total size"
+	Limit string
+	// Max is the configured limit
+	Max float64
+	// Name is the entry being extracted when the limit was hit
+	Name string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s limit of %s exceeded by %s", ErrLimitExceeded, e.Limit, strconv.FormatFloat(e.Max, 'f', -1, 64), e.Name)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// validate checks that no limit is negative
+func (l ExtractLimits) validate() error {
+	if l.MaxTotalSize < 0 || l.MaxFileSize < 0 || l.MaxEntries < 0 || l.MaxRatio < 0 || l.MaxDepth < 0 || l.MaxInputSize < 0 {
+		return fmt.Errorf("invalid extraction limits: %+v", l)
+	}
+	return nil
+}
+
+// extractGuard enforces ExtractLimits during one extraction and records every file,
+// link and directory it creates, so that an extraction stopped by a limit or canceled
+// can be rolled back. All methods are safe to call on a nil guard, which enforces nothing.
+type extractGuard struct {
+	limits      ExtractLimits
+	archiveSize int64
+	entries     int
+	total       int64
+	created     []string
+	// links holds the identity of the symlinks created by the extraction
+	links map[fileKey]bool
+	// replaced holds the existing files moved aside by the extraction, restored by rollback
+	replaced []replacedFile
+	// ctx stops the extraction at the next entry or read once it is canceled, it may be nil
+	ctx context.Context
+}
+
+// newExtractGuard returns a guard for the extraction of an archive file of archiveSize bytes
+func newExtractGuard(limits ExtractLimits, archiveSize int64) *extractGuard {
+	return &extractGuard{limits: limits, archiveSize: archiveSize}
+}
+
+// newSizeGuard returns a guard enforcing only the total size and ratio of limits, for
+// reading the archive file at sourcePath without extracting it
+func newSizeGuard(sourcePath string, limits ExtractLimits) (*extractGuard, error) {
+	if err := limits.validate(); err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("source path error: %w", err)
+	}
+	return newExtractGuard(ExtractLimits{MaxTotalSize: limits.MaxTotalSize, MaxRatio: limits.MaxRatio}, info.Size()), nil
+}
+
+// entry accounts for the extraction of the entry name
+func (g *extractGuard) entry(name string) error {
+	if g == nil {
+		return nil
+	}
+	if err := contextErr(g.ctx); err != nil {
+		return err
+	}
+	g.entries++
+	if g.limits.MaxEntries > 0 && g.entries > g.limits.MaxEntries {
+		return &LimitError{Limit: "entry count", Max: float64(g.limits.MaxEntries), Name: name}
+	}
+	if depth := strings.Count(cleanEntryName(name), "/") + 1; g.limits.MaxDepth > 0 && depth > g.limits.MaxDepth {
+		return &LimitError{Limit: "nesting depth", Max: float64(g.limits.MaxDepth), Name: name}
+	}
+	return nil
+}
+
+// checkDeclared rejects archives whose headers already announce more data than allowed,
+// before anything is written
+func (g *extractGuard) checkDeclared(totalSize int64) error {
+	if g == nil {
+		return nil
+	}
+	return g.checkTotal(totalSize, "archive")
+}
+
+// checkInput checks the size of the archive read so far against the input size limit
+func (g *extractGuard) checkInput(size int64) error {
+	if g.limits.MaxInputSize > 0 && size > g.limits.MaxInputSize {
+		return &LimitError{Limit: "input size", Max: float64(g.limits.MaxInputSize), Name: "archive"}
+	}
+	return nil
+}
+
+// checkTotal checks total extracted bytes against the total size and ratio limits
+func (g *extractGuard) checkTotal(total int64, name string) error {
+	if g.limits.MaxTotalSize > 0 && total > g.limits.MaxTotalSize {
+		return &LimitError{Limit: "total size", Max: float64(g.limits.MaxTotalSize), Name: name}
+	}
+	if g.limits.MaxRatio > 0 && float64(total) > g.limits.MaxRatio*float64(g.archiveSize) {
+		return &LimitError{Limit: "compression ratio", Max: g.limits.MaxRatio, Name: name}
+	}
+	return nil
+}
+
+// reader returns r counting the bytes of the entry name against the size limits
+func (g *extractGuard) reader(r io.Reader, name string) io.Reader {
+	if g == nil {
+		return r
+	}
+	return &limitedReader{guard: g, reader: r, name: name}
+}
+
+// archiveReader returns r adding the bytes read from it to the archive size, for archives
+// read as a stream whose size is not known in advance. The compression ratio is then
+// measured against the part of the archive read so far.
+func (g *extractGuard) archiveReader(r io.Reader) io.Reader {
+	if g == nil {
+		return r
+	}
+	return &archiveCounter{guard: g, reader: r}
+}
+
+// mkdirAll creates the directory path and its missing parents, recording them
+func (g *extractGuard) mkdirAll(path string) error {
+	if g == nil {
+		return os.MkdirAll(path, 0755)
+	}
+
+	// Find the parents that do not exist yet, outermost last
+	var missing []string
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil {
+			break
+		}
+		missing = append(missing, dir)
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		g.created = append(g.created, missing[i])
+	}
+	return nil
+}
+
+// create records a file or link created by the extraction
+func (g *extractGuard) create(path string) {
+	if g != nil {
+		g.created = append(g.created, path)
+	}
+}
+
+// replacedFile is an existing file moved aside to backup before an entry replaced it
+type replacedFile struct {
+	path, backup string
+}
+
+// replace moves the file or link written at tempPath to path. An existing file or link at
+// path is moved aside, so that rollback can restore it, and removed by commit. Links at
+// path are replaced, never followed, and existing directories are left in place.
+func (g *extractGuard) replace(tempPath, path string) error {
+	var backup string
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() && g != nil {
+		if backup, err = newTempPath(path); err != nil {
+			return err
+		}
+		if err := os.Rename(path, backup); err != nil {
+			os.Remove(backup)
+			return err
+		}
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		if backup != "" {
+			os.Rename(backup, path)
+		}
+		return err
+	}
+	if backup != "" {
+		g.replaced = append(g.replaced, replacedFile{path: path, backup: backup})
+	}
+	g.create(path)
+	return nil
+}
+
+// commit removes the files moved aside by replace, once the extraction is kept
+func (g *extractGuard) commit() {
+	if g == nil {
+		return
+	}
+	for _, file := range g.replaced {
+		os.Remove(file.backup)
+	}
+	g.replaced = nil
+}
+
+// createdSymlink records the symlink described by info as created by the extraction
+func (g *extractGuard) createdSymlink(info os.FileInfo) {
+	if g == nil {
+		return
+	}
+	if key, ok := fileKeyOf(info); ok {
+		if g.links == nil {
+			g.links = make(map[fileKey]bool)
+		}
+		g.links[key] = true
+	}
+}
+
+// createdLink reports whether the symlink described by info was created by the extraction.
+// Where files have no identity, every symlink counts as created.
+func (g *extractGuard) createdLink(info os.FileInfo) bool {
+	if g == nil {
+		return false
+	}
+	key, ok := fileKeyOf(info)
+	return !ok || g.links[key]
+}
+
+// rollback removes everything the extraction created, newest first, and puts back
+// the files it replaced. Directories that still hold other files are kept.
+func (g *extractGuard) rollback() {
+	if g == nil {
+		return
+	}
+	for i := len(g.created) - 1; i >= 0; i-- {
+		os.Remove(g.created[i])
+	}
+	g.created = nil
+	// A path replaced twice ends up with its oldest file
+	for i := len(g.replaced) - 1; i >= 0; i-- {
+		os.Rename(g.replaced[i].backup, g.replaced[i].path)
+	}
+	g.replaced = nil
+}
+
+// limitedReader stops reading an entry once a size limit is exceeded
+type limitedReader struct {
+	guard  *extractGuard
+	reader io.Reader
+	name   string
+	size   int64
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if err := contextErr(r.guard.ctx); err != nil {
+		return 0, err
+	}
+	n, err := r.reader.Read(p)
+	r.size += int64(n)
+	r.guard.total += int64(n)
+
+	// Drop the data read past a limit, the entry is abandoned anyway
+	limits := r.guard.limits
+	if limits.MaxFileSize > 0 && r.size > limits.MaxFileSize {
+		return 0, &LimitError{Limit: "file size", Max: float64(limits.MaxFileSize), Name: r.name}
+	}
+	if limitErr := r.guard.checkTotal(r.guard.total, r.name); limitErr != nil {
+		return 0, limitErr
+	}
+	return n, err
+}
+
+// archiveCounter counts the bytes read from an archive of unknown size
+type archiveCounter struct {
+	guard  *extractGuard
+	reader io.Reader
+}
+
+func (r *archiveCounter) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.guard.archiveSize += int64(n)
+	if limitErr := r.guard.checkInput(r.guard.archiveSize); limitErr != nil {
+		return n, limitErr
+	}
+	return n, err
+}
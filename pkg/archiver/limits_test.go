@@ -0,0 +1,217 @@
+package archiver
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// limitsFixture replaces a.txt of the destination before a larger, deeper entry
+var limitsFixture = []testEntry{
+	{name: "a.txt", content: "NEW"},
+	{name: "b/c/big.bin", content: strings.Repeat("x", 10000)},
+}
+
+// prepareLimitsTest writes the archive of limitsFixture and a destination holding a precious a.txt
+func prepareLimitsTest(t *testing.T) (archive, dest string) {
+	t.Helper()
+	archive = filepath.Join(t.TempDir(), "limits.tar")
+	writeTestTar(t, archive, limitsFixture)
+	dest = t.TempDir()
+	if err := os.WriteFile(filepath.Join(dest, "a.txt"), []byte("PRECIOUS"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return archive, dest
+}
+
+// checkDestination fails unless the destination holds exactly the files of want with their contents
+func checkDestination(t *testing.T, dest string, want map[string]string) {
+	t.Helper()
+	entries, err := os.ReadDir(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if len(names) != len(want) {
+		t.Errorf("destination holds %v, want %d files", names, len(want))
+	}
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil || string(got) != content {
+			t.Errorf("%s: content %q (%v), want %q", name, got, err, content)
+		}
+	}
+}
+
+func TestExtractLimits(t *testing.T) {
+	tests := []struct {
+		limit  string
+		limits ExtractLimits
+	}{
+		{"total size", ExtractLimits{MaxTotalSize: 1000}},
+		{"file size", ExtractLimits{MaxFileSize: 1000}},
+		{"entry count", ExtractLimits{MaxEntries: 1}},
+		{"nesting depth", ExtractLimits{MaxDepth: 2}},
+		{"compression ratio", ExtractLimits{MaxRatio: 0.5}},
+		{"input size", ExtractLimits{MaxInputSize: 1000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.limit, func(t *testing.T) {
+			archive, dest := prepareLimitsTest(t)
+			err := ExtractWithProgress(archive, dest, ExtractOptions{Limits: tt.limits}, nil)
+			var limitErr *LimitError
+			if !errors.Is(err, ErrLimitExceeded) || !errors.As(err, &limitErr) || limitErr.Limit != tt.limit {
+				t.Fatalf("error %v, want the %s limit", err, tt.limit)
+			}
+			if limitErr.Name == "a.txt" {
+				t.Errorf("limit exceeded by %s, want the second entry", limitErr.Name)
+			}
+
+			// The replaced file is back and nothing else is left
+			checkDestination(t, dest, map[string]string{"a.txt": "PRECIOUS"})
+		})
+	}
+}
+
+func TestExtractWithinLimits(t *testing.T) {
+	archive, dest := prepareLimitsTest(t)
+	limits := ExtractLimits{MaxTotalSize: 20000, MaxFileSize: 10000, MaxEntries: 2, MaxDepth: 3, MaxRatio: 1, MaxInputSize: 1 << 20}
+	if err := ExtractWithProgress(archive, dest, ExtractOptions{Limits: limits}, nil); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	// The replaced file is not kept aside
+	checkDestination(t, dest, map[string]string{"a.txt": "NEW", "b/c/big.bin": limitsFixture[1].content})
+}
+
+func TestExtractRollbackOnCancel(t *testing.T) {
+	archive, dest := prepareLimitsTest(t)
+
+	// Cancel once the first entry has replaced a.txt
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker := NewProgressTracker(func(event ProgressEvent) {
+		if event.Kind == FileFinished {
+			cancel()
+		}
+	}, 0)
+
+	err := ExtractContext(ctx, archive, dest, ExtractOptions{}, tracker)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error %v, want %v", err, context.Canceled)
+	}
+	checkDestination(t, dest, map[string]string{"a.txt": "PRECIOUS"})
+}
+
+func TestExtractFailedEntryKeepsFile(t *testing.T) {
+	archive, dest := prepareLimitsTest(t)
+
+	// An archive cut in the middle of a.txt fails while the entry is copied
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(archive, data[:512+1], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ExtractWithProgress(archive, dest, ExtractOptions{}, nil); err == nil {
+		t.Fatal("truncated archive extracted without error")
+	}
+	checkDestination(t, dest, map[string]string{"a.txt": "PRECIOUS"})
+}
+
+// writeGzipBomb writes a gzip stream of size zero bytes, wrapped in a tar archive if tarred
+func writeGzipBomb(t *testing.T, size int, tarred bool) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bomb.gz")
+	content := make([]byte, size)
+	if tarred {
+		tarPath := filepath.Join(t.TempDir(), "bomb.tar")
+		writeTestTar(t, tarPath, []testEntry{{name: "zeros", content: string(content)}})
+		var err error
+		if content, err = os.ReadFile(tarPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := gzip.NewWriter(file)
+	if _, err := writer.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestListWithLimits(t *testing.T) {
+	for _, tarred := range []bool{false, true} {
+		bomb := writeGzipBomb(t, 1<<20, tarred)
+		for _, limits := range []ExtractLimits{{MaxTotalSize: 1 << 16}, {MaxRatio: 10}} {
+			if _, err := ListWithLimits(bomb, limits); !errors.Is(err, ErrLimitExceeded) {
+				t.Errorf("tar %t, limits %+v: error %v, want %v", tarred, limits, err, ErrLimitExceeded)
+			}
+		}
+
+		entries, err := ListWithLimits(bomb, ExtractLimits{MaxTotalSize: 2 << 20})
+		if err != nil || len(entries) != 1 || entries[0].Size != 1<<20 {
+			t.Errorf("tar %t: entries %v (%v), want one of 1MB", tarred, entries, err)
+		}
+	}
+}
+
+// writeZipBomb writes a ZIP archive holding size zero bytes, deflated
+func writeZipBomb(t *testing.T, size int) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bomb.zip")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	entry, err := writer.Create("zeros")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := entry.Write(make([]byte, size)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTestWithLimits(t *testing.T) {
+	bombs := map[string]string{
+		"gz":     writeGzipBomb(t, 1<<20, false),
+		"tar.gz": writeGzipBomb(t, 1<<20, true),
+		"zip":    writeZipBomb(t, 1<<20),
+	}
+	for name, bomb := range bombs {
+		for _, limits := range []ExtractLimits{{MaxTotalSize: 1 << 16}, {MaxRatio: 10}} {
+			if _, err := TestWithLimits(bomb, "", limits); !errors.Is(err, ErrLimitExceeded) {
+				t.Errorf("%s, limits %+v: error %v, want %v", name, limits, err, ErrLimitExceeded)
+			}
+		}
+
+		result, err := TestWithLimits(bomb, "", ExtractLimits{MaxTotalSize: 2 << 20})
+		if err != nil || !result.OK() || result.Tested != 1 {
+			t.Errorf("%s: result %+v (%v), want one tested entry", name, result, err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafePath is returned by SafeLinks extractions for entries that would be written
+// outside the destination by following a symlink
+var ErrUnsafePath = errors.New("path leads outside the destination through a symlink")
+
+// maxLinkHops bounds the number of symlinks followed to resolve a path, like the
+// kernel does against link loops
+const maxLinkHops = 40
+
+// entryPath returns the location of the entry name below destPath. The symlinks created
+// earlier by the extraction, and in SafeLinks mode all existing symlinks, on the way must
+// not lead outside destPath.
+func (o ExtractOptions) entryPath(destPath, name string) (string, error) {
+	filePath, err := extractPath(destPath, name)
+	if err != nil {
+		return "", err
+	}
+
+	root, err := resolvedRoot(destPath)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(destPath, filePath)
+	if err != nil {
+		return "", fmt.Errorf("illegal file path: %s: %w", filePath, ErrIllegalPath)
+	}
+	hops := 0
+	resolved, err := resolveLinks(root, rel, &hops, o.followsLink)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", filePath, err)
+	}
+	if !isWithin(root, resolved) {
+		return "", fmt.Errorf("%s: %w", filePath, ErrUnsafePath)
+	}
+	return filePath, nil
+}
+
+// checkLinkTarget verifies that a symlink stored at filePath and pointing to target stays
+// inside destPath. The target is resolved through the symlinks created earlier by the
+// extraction, and in SafeLinks mode through all existing symlinks, which catches chains
+// of links that each look harmless.
+func (o ExtractOptions) checkLinkTarget(destPath, filePath, target string) error {
+	if err := checkLinkTarget(destPath, filePath, target); err != nil {
+		return err
+	}
+
+	root, err := resolvedRoot(destPath)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(destPath, filepath.Dir(filePath))
+	if err != nil {
+		return fmt.Errorf("illegal file path: %s: %w", filePath, ErrIllegalPath)
+	}
+	hops := 0
+	dir, err := resolveLinks(root, rel, &hops, o.followsLink)
+	if err == nil {
+		target, err = resolveLinks(dir, target, &hops, o.followsLink)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", filePath, err)
+	}
+	if !isWithin(root, target) {
+		return fmt.Errorf("%s: %w", filePath, ErrUnsafePath)
+	}
+	return nil
+}
+
+// followsLink reports whether entries are checked through the symlink described by info.
+// Links created by the extraction are always followed, since the archive chose their
+// targets, while other existing links are only followed in SafeLinks mode. Without a
+// guard recording the created links, every link is followed.
+func (o ExtractOptions) followsLink(info os.FileInfo) bool {
+	return o.SafeLinks || o.guard == nil || o.guard.createdLink(info)
+}
+
+// resolvedRoot returns the absolute path of the destination with its symlinks resolved
+func resolvedRoot(destPath string) (string, error) {
+	abs, err := filepath.Abs(destPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve destination: %w", err)
+	}
+	root, err := filepath.EvalSymlinks(abs)
+	if os.IsNotExist(err) {
+		// Nothing exists below a destination that is not created yet
+		return abs, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve destination: %w", err)
+	}
+	return root, nil
+}
+
+// resolveLinks resolves the relative path rel against the directory base, following the
+// symlinks on the way for which follow returns true. The other symlinks, and the elements
+// that do not exist yet, are joined as they are. hops counts the symlinks followed.
+func resolveLinks(base, rel string, hops *int, follow func(info os.FileInfo) bool) (string, error) {
+	current := base
+	for _, elem := range strings.Split(filepath.ToSlash(rel), "/") {
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			current = filepath.Dir(current)
+			continue
+		}
+
+		current = filepath.Join(current, elem)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink == 0 || !follow(info) {
+			continue
+		}
+
+		*hops++
+		if *hops > maxLinkHops {
+			return "", fmt.Errorf("too many levels of symbolic links: %s", current)
+		}
+		target, err := os.Readlink(current)
+		if err != nil {
+			return "", err
+		}
+		start := filepath.Dir(current)
+		if filepath.IsAbs(target) {
+			start = filepath.VolumeName(target) + string(os.PathSeparator)
+			target = target[len(start):]
+		}
+		current, err = resolveLinks(start, target, hops, follow)
+		if err != nil {
+			return "", err
+		}
+	}
+	return current, nil
+}
+
+// isWithin reports whether path is root or below it
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// hardLinks remembers the archived files that have more than one name,
+// so that their other names can be stored as links to the first one
+type hardLinks map[fileKey]string
+
+// seen returns the name the file described by info was archived under before.
+// Otherwise it records name for the file and returns false.
+func (h hardLinks) seen(info os.FileInfo, name string) (string, bool) {
+	key, ok := linkedFileKey(info)
+	if h == nil || !ok {
+		return "", false
+	}
+	if first, ok := h[key]; ok {
+		return first, true
+	}
+	h[key] = name
+	return "", false
+}
@@ -0,0 +1,181 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testEntry is a file, directory, symlink or hard link of an archive written by a test
+type testEntry struct {
+	name     string
+	content  string
+	link     string
+	hardLink string
+	dir      bool
+	modified time.Time
+}
+
+// writeTestTar writes the entries to a tar archive at path
+func writeTestTar(t *testing.T, path string, entries []testEntry) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	tw := tar.NewWriter(file)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.content)), ModTime: entry.modified}
+		switch {
+		case entry.dir:
+			header.Typeflag, header.Mode, header.Size = tar.TypeDir, 0755, 0
+		case entry.link != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, entry.link, 0
+		case entry.hardLink != "":
+			header.Typeflag, header.Linkname, header.Size = tar.TypeLink, entry.hardLink, 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractLinkChainStaysInside(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks are not available")
+	}
+
+	// Each link points one level up from where its name says it is, but on
+	// disk the chain climbs above the destination
+	archive := filepath.Join(t.TempDir(), "evil.tar")
+	writeTestTar(t, archive, []testEntry{
+		{name: "d/e/up", link: ".."},
+		{name: "d/e/up/up2", link: ".."},
+		{name: "d/e/up/up2/up3", link: ".."},
+		{name: "d/e/up/up2/up3/pwned.txt", content: "pwned"},
+	})
+
+	for _, safeLinks := range []bool{false, true} {
+		base := t.TempDir()
+		dest := filepath.Join(base, "out")
+		err := ExtractWithProgress(archive, dest, ExtractOptions{SafeLinks: safeLinks}, nil)
+		if !errors.Is(err, ErrUnsafePath) {
+			t.Errorf("SafeLinks %t: error %v, want %v", safeLinks, err, ErrUnsafePath)
+		}
+		if _, err := os.Lstat(filepath.Join(base, "pwned.txt")); !os.IsNotExist(err) {
+			t.Errorf("SafeLinks %t: file written outside the destination", safeLinks)
+		}
+	}
+}
+
+func TestExtractThroughLinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks are not available")
+	}
+
+	// Links of the archive that stay inside the destination can be written through
+	archive := filepath.Join(t.TempDir(), "links.tar")
+	writeTestTar(t, archive, []testEntry{
+		{name: "lib64", dir: true},
+		{name: "lib", link: "lib64"},
+		{name: "lib/libc.so", content: "libc"},
+		{name: "data/file.txt", content: "data"},
+	})
+
+	// A link of the destination itself is trusted unless SafeLinks is set
+	outside := t.TempDir()
+	dest := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(dest, "data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ExtractWithProgress(archive, dest, ExtractOptions{}, nil); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	for path, want := range map[string]string{
+		filepath.Join(dest, "lib64", "libc.so"): "libc",
+		filepath.Join(outside, "file.txt"):      "data",
+	} {
+		content, err := os.ReadFile(path)
+		if err != nil || string(content) != want {
+			t.Errorf("%s: content %q (%v), want %q", path, content, err, want)
+		}
+	}
+
+	err := ExtractWithProgress(archive, dest, ExtractOptions{SafeLinks: true}, nil)
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("SafeLinks: error %v, want %v", err, ErrUnsafePath)
+	}
+}
+
+func TestExtractSelectedHardLink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard links are not available")
+	}
+
+	archive := filepath.Join(t.TempDir(), "hardlinks.tar")
+	writeTestTar(t, archive, []testEntry{
+		{name: "data/a.txt", content: "shared"},
+		{name: "links/a.txt", hardLink: "data/a.txt"},
+		{name: "links/b.txt", content: "own"},
+	})
+
+	tests := []struct {
+		name     string
+		opts     ExtractOptions
+		want     map[string]string
+		unlinked []string
+	}{
+		{"with target", ExtractOptions{Paths: []string{"data", "links"}}, map[string]string{"links/a.txt": "shared", "links/b.txt": "own"}, nil},
+		{"included", ExtractOptions{Include: []string{"links/**"}}, map[string]string{"links/b.txt": "own"}, []string{"links/a.txt"}},
+		{"excluded", ExtractOptions{Exclude: []string{"data"}}, map[string]string{"links/b.txt": "own"}, []string{"links/a.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var summary ExtractSummary
+			tt.opts.Summary = &summary
+			dest := t.TempDir()
+			if err := ExtractWithProgress(archive, dest, tt.opts, nil); err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			checkExtracted(t, dest, tt.want)
+			if !slices.Equal(summary.Unlinked, tt.unlinked) {
+				t.Errorf("unlinked %v, want %v", summary.Unlinked, tt.unlinked)
+			}
+			link, linkErr := os.Lstat(filepath.Join(dest, "links", "a.txt"))
+			if tt.unlinked != nil && !os.IsNotExist(linkErr) {
+				t.Errorf("skipped hard link: %v, want no file", linkErr)
+			}
+			if target, err := os.Lstat(filepath.Join(dest, "data", "a.txt")); tt.unlinked == nil && (err != nil || linkErr != nil || !os.SameFile(link, target)) {
+				t.Errorf("hard link and target are different files (%v, %v)", linkErr, err)
+			}
+
+			// Sinks do not get the links to entries they never got either
+			data, err := os.ReadFile(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sink := newMemorySink()
+			tt.opts.Summary = nil
+			if err := ExtractReader(bytes.NewReader(data), sink, tt.opts); err != nil {
+				t.Fatalf("ExtractReader: %v", err)
+			}
+			if _, ok := sink.links["links/a.txt"]; ok != (tt.unlinked == nil) {
+				t.Errorf("links %v passed to the sink", sink.links)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package archiver
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// entryMetadata is the metadata of an archive entry, restored once its data is written.
+// Zero times are not recorded by the archive and are left alone.
+type entryMetadata struct {
+	mode       os.FileMode
+	modTime    time.Time
+	accessTime time.Time
+	uid, gid   int
+	hasOwner   bool
+}
+
+// restoreMetadata applies meta to the file, directory or symlink extracted to filePath,
+// as far as o allows. The mode and times of symlinks cannot be set portably, they only get their owner.
+func (o ExtractOptions) restoreMetadata(filePath string, meta entryMetadata) error {
+	// Changing the owner clears the setuid and setgid bits, so it comes first
+	if o.PreserveOwner && meta.hasOwner {
+		if err := os.Lchown(filePath, meta.uid, meta.gid); err != nil {
+			return fmt.Errorf("failed to set owner: %w", err)
+		}
+	}
+	if meta.mode&os.ModeSymlink != 0 {
+		return nil
+	}
+
+	if !o.IgnorePermissions {
+		mode := meta.mode.Perm()
+		if o.PreserveSpecialBits {
+			mode |= meta.mode & (os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		}
+		if err := os.Chmod(filePath, mode); err != nil {
+			return fmt.Errorf("failed to set permissions: %w", err)
+		}
+	}
+
+	if !meta.modTime.IsZero() {
+		// Archives without an access time get the modification time for both
+		accessTime := meta.accessTime
+		if accessTime.IsZero() {
+			accessTime = meta.modTime
+		}
+		if err := os.Chtimes(filePath, accessTime, meta.modTime); err != nil {
+			return fmt.Errorf("failed to set file times: %w", err)
+		}
+	}
+	return nil
+}
+
+// pendingDir is an extracted directory whose metadata is restored after its contents
+type pendingDir struct {
+	path string
+	meta entryMetadata
+}
+
+// restoreDirs restores the metadata of the extracted directories, deepest last extracted first.
+// It runs after all files are written, since writing them updates the directory times
+// and read-only directories would not accept them.
+func (o ExtractOptions) restoreDirs(dirs []pendingDir) error {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := o.restoreMetadata(dirs[i].path, dirs[i].meta); err != nil {
+			return fmt.Errorf("%s: %w", dirs[i].path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,309 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// fixtureEntry describes a file, directory or symlink of the test tree
+type fixtureEntry struct {
+	path       string
+	mode       os.FileMode
+	content    string
+	link       string
+	modTime    time.Time
+	accessTime time.Time
+}
+
+// metadataFixture lists the test tree with parents before their contents.
+// Access times are after the modification times, so that relatime mounts
+// do not update them while the tree is compressed.
+var metadataFixture = []fixtureEntry{
+	{path: "docs", mode: os.ModeDir | 0750, modTime: fixtureTime(1), accessTime: fixtureTime(101)},
+	{path: "docs/readme.txt", mode: 0640, content: "read me\n", modTime: fixtureTime(2), accessTime: fixtureTime(102)},
+	{path: "docs/latest", mode: os.ModeSymlink | 0777, link: "readme.txt"},
+	{path: "bin", mode: os.ModeDir | 0755, modTime: fixtureTime(3), accessTime: fixtureTime(103)},
+	{path: "bin/run.sh", mode: 0755, content: "#!/bin/sh\necho run\n", modTime: fixtureTime(4), accessTime: fixtureTime(104)},
+	{path: "empty", mode: os.ModeDir | 0700, modTime: fixtureTime(5), accessTime: fixtureTime(105)},
+	{path: "private.key", mode: 0600, content: "secret\n", modTime: fixtureTime(6), accessTime: fixtureTime(106)},
+}
+
+// fixtureTime returns a distinct time in whole seconds, which every format can store
+func fixtureTime(days int) time.Time {
+	return time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC).AddDate(0, 0, days)
+}
+
+// writeMetadataFixture creates the test tree below root
+func writeMetadataFixture(t *testing.T, root string) {
+	t.Helper()
+	for _, entry := range metadataFixture {
+		path := filepath.Join(root, entry.path)
+		var err error
+		switch {
+		case entry.mode.IsDir():
+			err = os.Mkdir(path, 0700)
+		case entry.mode&os.ModeSymlink != 0:
+			err = os.Symlink(entry.link, path)
+		default:
+			err = os.WriteFile(path, []byte(entry.content), 0600)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Set the metadata deepest first, creating entries updates the times of their directory
+	for i := len(metadataFixture) - 1; i >= 0; i-- {
+		entry := metadataFixture[i]
+		if entry.mode&os.ModeSymlink != 0 {
+			continue
+		}
+		path := filepath.Join(root, entry.path)
+		if err := os.Chmod(path, entry.mode.Perm()); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, entry.accessTime, entry.modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// compressFixture writes the test tree and compresses it into an archive of the given format
+func compressFixture(t *testing.T, format string, opts CompressOptions) string {
+	t.Helper()
+	source := filepath.Join(t.TempDir(), "tree")
+	if err := os.Mkdir(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeMetadataFixture(t, source)
+
+	archive := filepath.Join(t.TempDir(), "tree."+format)
+	if err := Compress(source, archive, format, opts); err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	return archive
+}
+
+func TestMetadataRoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix permissions and symlinks are not available")
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		opts   CompressOptions
+	}{
+		{"zip", "zip", CompressOptions{Workers: 1}},
+		{"zip parallel", "zip", CompressOptions{Workers: 4}},
+		{"zip encrypted", "zip", CompressOptions{Workers: 1, Password: "secret"}},
+		{"tar", "tar", CompressOptions{}},
+		{"tar.gz", "tar.gz", CompressOptions{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := compressFixture(t, tt.format, tt.opts)
+			dest := t.TempDir()
+			opts := ExtractOptions{Password: tt.opts.Password, PreserveOwner: true}
+			if err := ExtractWithProgress(archive, dest, opts, nil); err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+
+			for _, entry := range metadataFixture {
+				path := filepath.Join(dest, entry.path)
+				info, err := os.Lstat(path)
+				if err != nil {
+					t.Errorf("%s: %v", entry.path, err)
+					continue
+				}
+				if info.Mode() != entry.mode {
+					t.Errorf("%s: mode %v, want %v", entry.path, info.Mode(), entry.mode)
+				}
+				if uid, gid, ok := fileOwner(info); ok && (uid != os.Getuid() || gid != os.Getgid()) {
+					t.Errorf("%s: owner %d:%d, want %d:%d", entry.path, uid, gid, os.Getuid(), os.Getgid())
+				}
+
+				if entry.mode&os.ModeSymlink != 0 {
+					target, err := os.Readlink(path)
+					if err != nil || target != entry.link {
+						t.Errorf("%s: link target %q (%v), want %q", entry.path, target, err, entry.link)
+					}
+					continue
+				}
+				if !info.ModTime().Equal(entry.modTime) {
+					t.Errorf("%s: modified %v, want %v", entry.path, info.ModTime(), entry.modTime)
+				}
+				// archive/tar reads the access time where the platform records it
+				header, err := tar.FileInfoHeader(info, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !header.AccessTime.IsZero() && !header.AccessTime.Equal(entry.accessTime) {
+					t.Errorf("%s: accessed %v, want %v", entry.path, header.AccessTime, entry.accessTime)
+				}
+				if info.Mode().IsRegular() {
+					content, err := os.ReadFile(path)
+					if err != nil || string(content) != entry.content {
+						t.Errorf("%s: content %q (%v), want %q", entry.path, content, err, entry.content)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExtractIgnorePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix permissions are not available")
+	}
+
+	// The permissions the process gives new files and directories
+	reference := t.TempDir()
+	defaultFile, err := os.Create(filepath.Join(reference, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultFile.Close()
+	if err := os.Mkdir(filepath.Join(reference, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, err := os.Stat(filepath.Join(reference, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirInfo, err := os.Stat(filepath.Join(reference, "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"zip", "tar.gz"} {
+		t.Run(format, func(t *testing.T) {
+			archive := compressFixture(t, format, CompressOptions{})
+			dest := t.TempDir()
+			if err := ExtractWithProgress(archive, dest, ExtractOptions{IgnorePermissions: true}, nil); err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+
+			for _, check := range []struct {
+				path string
+				want os.FileMode
+			}{
+				{"private.key", fileInfo.Mode()},
+				{"bin/run.sh", fileInfo.Mode()},
+				{"empty", dirInfo.Mode()},
+			} {
+				info, err := os.Stat(filepath.Join(dest, check.path))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Mode() != check.want {
+					t.Errorf("%s: mode %v, want %v", check.path, info.Mode(), check.want)
+				}
+			}
+
+			// Times are restored either way
+			info, err := os.Stat(filepath.Join(dest, "private.key"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fixtureTime(6); !info.ModTime().Equal(want) {
+				t.Errorf("private.key: modified %v, want %v", info.ModTime(), want)
+			}
+		})
+	}
+}
+
+func TestExtractSpecialBits(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix permissions are not available")
+	}
+
+	source := filepath.Join(t.TempDir(), "tree")
+	want := map[string]os.FileMode{
+		"shared":         os.ModeDir | os.ModeSticky | 0777,
+		"shared/setuid":  os.ModeSetuid | 0755,
+		"shared/setgid":  os.ModeSetgid | 0755,
+		"shared/regular": 0644,
+	}
+	if err := os.MkdirAll(filepath.Join(source, "shared"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for path, mode := range want {
+		path = filepath.Join(source, path)
+		if !mode.IsDir() {
+			if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, format := range []string{"zip", "tar"} {
+		t.Run(format, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "special."+format)
+			if err := CompressWithProgress(source, archive, format, CompressOptions{}, nil); err != nil {
+				t.Fatalf("Compress: %v", err)
+			}
+
+			// The bits are cleared unless the extraction asks for them
+			for _, preserve := range []bool{false, true} {
+				dest := t.TempDir()
+				if err := ExtractWithProgress(archive, dest, ExtractOptions{PreserveSpecialBits: preserve}, nil); err != nil {
+					t.Fatalf("Extract: %v", err)
+				}
+				for path, mode := range want {
+					if !preserve {
+						mode &^= os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+					}
+					info, err := os.Stat(filepath.Join(dest, path))
+					if err != nil {
+						t.Errorf("%s: %v", path, err)
+						continue
+					}
+					if info.Mode() != mode {
+						t.Errorf("%s: mode %v with PreserveSpecialBits %v, want %v", path, info.Mode(), preserve, mode)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestZipModifiedBefore1980(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "old.txt")
+	if err := os.WriteFile(source, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(1975, 6, 7, 8, 9, 10, 0, time.UTC)
+	if err := os.Chtimes(source, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, workers := range []int{1, 4} {
+		archive := filepath.Join(t.TempDir(), "old.zip")
+		if err := CompressWithProgress(source, archive, "zip", CompressOptions{Workers: workers}, nil); err != nil {
+			t.Fatalf("Compress: %v", err)
+		}
+		reader, err := zip.OpenReader(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := reader.File[0].FileHeader
+		reader.Close()
+
+		// The MS-DOS date is clamped, the extended timestamp keeps the time
+		if header.ModifiedDate != 1<<5|1 || header.ModifiedTime != 0 {
+			t.Errorf("%d workers: MS-DOS date %#x and time %#x, want 1980-01-01 00:00", workers, header.ModifiedDate, header.ModifiedTime)
+		}
+		if !header.Modified.Equal(modified) {
+			t.Errorf("%d workers: modified %v, want %v", workers, header.Modified, modified)
+		}
+	}
+}
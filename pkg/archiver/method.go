@@ -0,0 +1,104 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"math"
+	"path"
+	"strings"
+)
+
+// File types whose content is already compressed. Deflating them again costs
+// CPU time without making the archive noticeably smaller, so they are stored.
+var compressedExtensions = map[string]bool{
+	// Images
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".heic": true, ".heif": true, ".avif": true, ".jxl": true,
+	// Audio and video
+	".mp3": true, ".aac": true, ".m4a": true, ".ogg": true, ".opus": true, ".flac": true,
+	".mp4": true, ".m4v": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true,
+	// Archives and compressed streams
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".txz": true,
+	".zst": true, ".7z": true, ".rar": true, ".lz4": true,
+	// Formats that are ZIP archives themselves
+	".jar": true, ".apk": true, ".docx": true, ".xlsx": true, ".pptx": true,
+	".odt": true, ".ods": true, ".epub": true,
+	// Fonts
+	".woff": true, ".woff2": true,
+}
+
+// compressedSignatures are magic numbers of compressed content, checked when the extension is unknown
+var compressedSignatures = []struct {
+	offset int
+	magic  []byte
+}{
+	{0, []byte("PK\x03\x04")},                     // zip
+	{0, []byte{0x1F, 0x8B}},                       // gzip
+	{0, []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}},   // xz
+	{0, []byte("BZh")},                            // bzip2
+	{0, []byte{0x28, 0xB5, 0x2F, 0xFD}},           // zstd
+	{0, []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}}, // 7z
+	{0, []byte("Rar!\x1A\x07")},                   // rar
+	{0, []byte{0xFF, 0xD8, 0xFF}},                 // jpeg
+	{0, []byte("\x89PNG\r\n\x1a\n")},              // png
+	{0, []byte("GIF8")},                           // gif
+	{8, []byte("WEBP")},                           // webp
+	{4, []byte("ftyp")},                           // mp4, mov, heic
+	{0, []byte("OggS")},                           // ogg
+	{0, []byte("fLaC")},                           // flac
+	{0, []byte("ID3")},                            // mp3
+	{0, []byte{0x1A, 0x45, 0xDF, 0xA3}},           // matroska, webm
+	{0, []byte("wOFF")},                           // woff
+	{0, []byte("wOF2")},                           // woff2
+}
+
+const (
+	// entropySampleSize is the number of leading bytes inspected by zipEntryMethod
+	entropySampleSize = 32 * 1024
+	// minEntropySample is the smallest sample whose entropy says anything about the file
+	minEntropySample = 4 * 1024
+	// storeEntropy is the Shannon entropy in bits per byte above which data is not deflated
+	storeEntropy = 7.5
+)
+
+// zipEntryMethod returns the method used to store a file named name, whose content starts
+// with sample, in a ZIP archive. Unless opts selects a method, files that are already
+// compressed are stored and everything else is deflated.
+func zipEntryMethod(sample []byte, name string, opts CompressOptions) uint16 {
+	method := opts.zipMethod()
+	if method != zip.Deflate || opts.Method == MethodDeflate {
+		return method
+	}
+
+	if compressedExtensions[strings.ToLower(path.Ext(name))] || isCompressedContent(sample) {
+		return zip.Store
+	}
+	return method
+}
+
+// isCompressedContent reports whether sample, the start of a file, looks already compressed
+func isCompressedContent(sample []byte) bool {
+	for _, sig := range compressedSignatures {
+		if len(sample) >= sig.offset+len(sig.magic) && bytes.Equal(sample[sig.offset:sig.offset+len(sig.magic)], sig.magic) {
+			return true
+		}
+	}
+	return len(sample) >= minEntropySample && entropy(sample) > storeEntropy
+}
+
+// entropy returns the Shannon entropy of data in bits per byte
+func entropy(data []byte) float64 {
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	var bits float64
+	total := float64(len(data))
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / total
+			bits -= p * math.Log2(p)
+		}
+	}
+	return bits
+}
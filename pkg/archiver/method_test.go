@@ -0,0 +1,103 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// randomBytes returns n bytes of incompressible data
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+func TestZipEntryMethod(t *testing.T) {
+	text := []byte(strings.Repeat("the quick brown fox jumps over the lazy dog\n", 500))
+	png := append([]byte("\x89PNG\r\n\x1a\n"), text...)
+	webp := append([]byte("RIFF\x00\x00\x00\x00WEBP"), text...)
+
+	tests := []struct {
+		name   string
+		file   string
+		sample []byte
+		opts   CompressOptions
+		want   uint16
+	}{
+		{"text", "notes.txt", text, CompressOptions{}, zip.Deflate},
+		{"compressed extension", "photo.jpg", text, CompressOptions{}, zip.Store},
+		{"upper case extension", "ARCHIVE.ZIP", text, CompressOptions{}, zip.Store},
+		{"office document", "report.docx", text, CompressOptions{}, zip.Store},
+		{"magic only", "image.dat", png, CompressOptions{}, zip.Store},
+		{"magic at an offset", "image", webp, CompressOptions{}, zip.Store},
+		{"random data", "random.bin", randomBytes(entropySampleSize), CompressOptions{}, zip.Store},
+		{"short random data", "random.bin", randomBytes(minEntropySample / 2), CompressOptions{}, zip.Deflate},
+		{"empty", "empty.txt", nil, CompressOptions{}, zip.Deflate},
+		{"method deflate", "photo.jpg", png, CompressOptions{Method: MethodDeflate}, zip.Deflate},
+		{"method store", "notes.txt", text, CompressOptions{Method: MethodStore}, zip.Store},
+		{"level store", "notes.txt", text, CompressOptions{Level: LevelStore}, zip.Store},
+		{"level fastest", "photo.jpg", text, CompressOptions{Level: LevelFastest}, zip.Store},
+		{"method deflate at level store", "notes.txt", text, CompressOptions{Method: MethodDeflate, Level: LevelStore}, zip.Deflate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := zipEntryMethod(tt.sample, tt.file, tt.opts); got != tt.want {
+				t.Errorf("method %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	var everyByte []byte
+	for i := 0; i < 256*4; i++ {
+		everyByte = append(everyByte, byte(i))
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want float64
+	}{
+		{"single value", bytes.Repeat([]byte{'a'}, 100), 0},
+		{"two values", bytes.Repeat([]byte("ab"), 100), 1},
+		{"every byte", everyByte, 8},
+	}
+	for _, tt := range tests {
+		if got := entropy(tt.data); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: entropy %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestZipEntryMethodInArchive(t *testing.T) {
+	files := map[string][]byte{
+		"notes.txt":  []byte(strings.Repeat("notes ", 2000)),
+		"photo.jpg":  []byte(strings.Repeat("not really a photo ", 200)),
+		"random.bin": randomBytes(64 << 10),
+	}
+	want := map[string]uint16{"notes.txt": zip.Deflate, "photo.jpg": zip.Store, "random.bin": zip.Store}
+
+	for _, workers := range []int{1, 4} {
+		var sources []ReaderSource
+		for name, content := range files {
+			sources = append(sources, ReaderSource{Name: name, Reader: bytes.NewReader(content), Size: int64(len(content))})
+		}
+		var buffer bytes.Buffer
+		if err := CompressReaders(sources, &buffer, "zip", CompressOptions{Workers: workers}); err != nil {
+			t.Fatalf("CompressReaders: %v", err)
+		}
+		reader, err := zip.NewReader(bytes.NewReader(buffer.Bytes()), int64(buffer.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range reader.File {
+			if file.Method != want[file.Name] {
+				t.Errorf("%d workers: %s stored with method %d, want %d", workers, file.Name, file.Method, want[file.Name])
+			}
+		}
+	}
+}
@@ -0,0 +1,356 @@
+package archiver
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// CompressionLevel selects the trade-off between compression speed and output size
+type CompressionLevel int
+
+const (
+	// LevelDefault is the level used when none is selected, equal to LevelMaximum
+	LevelDefault CompressionLevel = iota
+	// LevelStore stores data without compressing it, where the format allows it
+	LevelStore
+	// LevelFastest compresses as fast as possible
+	LevelFastest
+	// LevelNormal balances speed and size
+	LevelNormal
+	// LevelMaximum produces the smallest output
+	LevelMaximum
+)
+
+var levelNames = map[CompressionLevel]string{
+	LevelStore:   "store",
+	LevelFastest: "fastest",
+	LevelNormal:  "normal",
+	LevelMaximum: "maximum",
+}
+
+// LevelNames returns the names accepted by ParseLevel, from fastest to smallest output
+func LevelNames() []string {
+	return []string{"store", "fastest", "normal", "maximum"}
+}
+
+// ParseLevel converts a level name such as "fastest" to a CompressionLevel.
+// An empty name selects LevelDefault.
+func ParseLevel(name string) (CompressionLevel, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == "default" {
+		return LevelDefault, nil
+	}
+	for level, levelName := range levelNames {
+		if levelName == name {
+			return level, nil
+		}
+	}
+	return LevelDefault, fmt.Errorf("unknown compression level: %s", name)
+}
+
+func (l CompressionLevel) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return "default"
+}
+
+// flateLevel returns the compress/flate level used for deflate based formats
+func (l CompressionLevel) flateLevel() int {
+	switch l {
+	case LevelStore:
+		return flate.NoCompression
+	case LevelFastest:
+		return flate.BestSpeed
+	case LevelNormal:
+		return flate.DefaultCompression
+	}
+	return flate.BestCompression
+}
+
+// Method selects how ZIP entries are stored
+type Method int
+
+const (
+	// MethodDefault deflates entries, but stores files that are already compressed,
+	// such as JPEG images or gzip files. With LevelStore every entry is stored.
+	MethodDefault Method = iota
+	// MethodDeflate compresses every entry with deflate
+	MethodDeflate
+	// MethodStore stores entries uncompressed
+	MethodStore
+)
+
+// ParseMethod converts a method name ("deflate" or "store") to a Method.
+// An empty name selects MethodDefault.
+func ParseMethod(name string) (Method, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "default":
+		return MethodDefault, nil
+	case "deflate":
+		return MethodDeflate, nil
+	case "store":
+		return MethodStore, nil
+	}
+	return MethodDefault, fmt.Errorf("unknown compression method: %s", name)
+}
+
+func (m Method) String() string {
+	switch m {
+	case MethodDeflate:
+		return "deflate"
+	case MethodStore:
+		return "store"
+	}
+	return "default"
+}
+
+// defaultBufferSize is the copy buffer used when CompressOptions.BufferSize is not set
+const defaultBufferSize = 4 * 1024 * 1024 // 4MB
+
+// CompressOptions controls how Compress writes its output.
+// The zero value selects maximum compression, as before options existed.
+type CompressOptions struct {
+	// Level is the compression level. PDF and JPEG compression ignore it.
+	Level CompressionLevel
+	// Method is the method of ZIP entries. Other formats ignore it.
+	Method Method
+	// BufferSize is the size of the copy buffer in bytes, 4MB if zero
+	BufferSize int
+	// Password encrypts the output, which requires a format with CanEncrypt
+	Password string
+	// Workers is the number of files compressed concurrently in ZIP archives,
+	// runtime.NumCPU() if zero. Other formats compress sequentially.
+	Workers int
+
+	// Include lists glob patterns of the files to compress from a directory,
+	// with the syntax of ExtractOptions.Include. Directories are still searched.
+	Include []string
+	// Exclude lists glob patterns of files and directories to leave out, even if they are included
+	Exclude []string
+	// UseIgnoreFiles leaves out what the .gitignore and .compressignore files found
+	// in the directory ignore, as well as .git directories
+	UseIgnoreFiles bool
+	// MinSize and MaxSize leave out files smaller or larger than them, if not zero
+	MinSize int64
+	MaxSize int64
+	// ModifiedAfter and ModifiedBefore leave out files modified outside of them, if not zero
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+
+	// ctx stops the compression once it is canceled, it is set by CompressSourcesContext
+	ctx context.Context
+}
+
+// bufferSize returns the configured copy buffer size
+func (o CompressOptions) bufferSize() int {
+	if o.BufferSize > 0 {
+		return o.BufferSize
+	}
+	return defaultBufferSize
+}
+
+// context returns the context of the compression, which is never canceled unless it was set
+func (o CompressOptions) context() context.Context {
+	if o.ctx == nil {
+		return context.Background()
+	}
+	return o.ctx
+}
+
+// workers returns the number of concurrent compression workers
+func (o CompressOptions) workers() int {
+	if o.Workers > 0 {
+		return o.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// zipMethod returns the ZIP compression method of new entries, before looking at their content
+func (o CompressOptions) zipMethod() uint16 {
+	if o.Method == MethodStore || (o.Method == MethodDefault && o.Level == LevelStore) {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// validate checks the options against the capabilities of format f
+func (o CompressOptions) validate(f Format) error {
+	if _, ok := levelNames[o.Level]; !ok && o.Level != LevelDefault {
+		return fmt.Errorf("unknown compression level: %d", o.Level)
+	}
+	if o.Method < MethodDefault || o.Method > MethodStore {
+		return fmt.Errorf("unknown compression method: %d", o.Method)
+	}
+	if o.BufferSize < 0 {
+		return fmt.Errorf("invalid buffer size: %d", o.BufferSize)
+	}
+	if o.Workers < 0 {
+		return fmt.Errorf("invalid worker count: %d", o.Workers)
+	}
+	if o.MinSize < 0 || o.MaxSize < 0 {
+		return fmt.Errorf("invalid size filter: %d to %d", o.MinSize, o.MaxSize)
+	}
+	if err := validatePatterns(o.Include, o.Exclude); err != nil {
+		return err
+	}
+	if o.Password != "" && f.Capabilities()&CanEncrypt == 0 {
+		return fmt.Errorf("%s encryption: %w", f.Name(), ErrUnsupported)
+	}
+	return nil
+}
+
+// OverwritePolicy decides what happens to an extracted entry whose destination already exists
+type OverwritePolicy int
+
+const (
+	// OverwriteAlways replaces existing files, the default
+	OverwriteAlways OverwritePolicy = iota
+	// OverwriteSkip keeps existing files and skips the entry
+	OverwriteSkip
+	// OverwriteRename extracts the entry next to the existing file as "name (1).ext"
+	OverwriteRename
+	// OverwriteIfNewer replaces existing files older than the entry and skips the others
+	OverwriteIfNewer
+	// OverwriteFail stops the extraction with ErrFileExists
+	OverwriteFail
+	// OverwriteAsk calls ExtractOptions.OnConflict to choose one of the other policies
+	OverwriteAsk
+)
+
+var overwriteNames = map[OverwritePolicy]string{
+	OverwriteAlways:  "overwrite",
+	OverwriteSkip:    "skip",
+	OverwriteRename:  "rename",
+	OverwriteIfNewer: "if-newer",
+	OverwriteFail:    "fail",
+	OverwriteAsk:     "ask",
+}
+
+// OverwritePolicyNames returns the names accepted by ParseOverwritePolicy
+func OverwritePolicyNames() []string {
+	return []string{"overwrite", "skip", "rename", "if-newer", "fail", "ask"}
+}
+
+// ParseOverwritePolicy converts a policy name such as "skip" to an OverwritePolicy.
+// An empty name selects OverwriteAlways.
+func ParseOverwritePolicy(name string) (OverwritePolicy, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return OverwriteAlways, nil
+	}
+	for policy, policyName := range overwriteNames {
+		if policyName == name {
+			return policy, nil
+		}
+	}
+	return OverwriteAlways, fmt.Errorf("unknown overwrite policy: %s", name)
+}
+
+func (p OverwritePolicy) String() string {
+	if name, ok := overwriteNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("OverwritePolicy(%d)", int(p))
+}
+
+// ExtractOptions controls which entries Extract unpacks and how it treats existing files.
+// The zero value extracts every entry, replacing existing files.
+type ExtractOptions struct {
+	// Include lists glob patterns of entries to extract, e.g. "config/**/*.yaml".
+	// "**" matches any number of directories, a pattern without a slash matches
+	// base names at any depth and a matching directory selects everything below it.
+	Include []string
+	// Exclude lists glob patterns of entries to skip, even if they are included
+	Exclude []string
+	// Paths lists entry paths to extract. A directory selects everything below it.
+	Paths []string
+	// Password decrypts encrypted entries of formats with CanEncrypt
+	Password string
+	// Overwrite is the policy for entries whose destination already exists.
+	// Directories are always merged.
+	Overwrite OverwritePolicy
+	// OnConflict chooses the policy of each conflict when Overwrite is OverwriteAsk
+	OnConflict ConflictFunc
+	// Summary, if not nil, records the entries skipped or renamed because of conflicts
+	Summary *ExtractSummary
+	// Limits bounds the size and number of extracted files. Extractions exceeding
+	// them fail with ErrLimitExceeded, remove everything they created and restore
+	// the files they replaced.
+	Limits ExtractLimits
+	// SafeLinks also resolves the symlinks that were on disk before the extraction,
+	// refusing entries and link targets that lead outside the destination through
+	// them with ErrUnsafePath. The symlinks extracted from the archive are always
+	// resolved that way.
+	SafeLinks bool
+	// IgnorePermissions creates files and directories with the default permissions
+	// of the process instead of the ones stored in the archive
+	IgnorePermissions bool
+	// PreserveOwner restores the numeric user and group stored by TAR and ZIP
+	// archives, which usually requires root privileges
+	PreserveOwner bool
+	// PreserveSpecialBits restores the setuid, setgid and sticky bits stored in the
+	// archive. They are cleared by default, so that extracted programs never run with
+	// the rights of their owner.
+	PreserveSpecialBits bool
+
+	// guard enforces Limits, it is set by ExtractWithProgress
+	guard *extractGuard
+}
+
+// filtered reports whether the options select a subset of the entries
+func (o ExtractOptions) filtered() bool {
+	return len(o.Include) > 0 || len(o.Exclude) > 0 || len(o.Paths) > 0
+}
+
+// selects reports whether the entry name is extracted
+func (o ExtractOptions) selects(name string) bool {
+	if !o.filtered() {
+		return true
+	}
+	name = cleanEntryName(name)
+
+	selected := len(o.Include) == 0 && len(o.Paths) == 0
+	for _, p := range o.Paths {
+		p = cleanEntryName(p)
+		if name == p || strings.HasPrefix(name, p+"/") {
+			selected = true
+			break
+		}
+	}
+	for _, pattern := range o.Include {
+		if selected {
+			break
+		}
+		selected = matchEntry(pattern, name)
+	}
+	if !selected {
+		return false
+	}
+
+	for _, pattern := range o.Exclude {
+		if matchEntry(pattern, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// validate checks the patterns and the overwrite policy of the options
+func (o ExtractOptions) validate() error {
+	if _, ok := overwriteNames[o.Overwrite]; !ok {
+		return fmt.Errorf("unknown overwrite policy: %d", o.Overwrite)
+	}
+	if o.Overwrite == OverwriteAsk && o.OnConflict == nil {
+		return fmt.Errorf("overwrite policy %s requires a conflict callback", o.Overwrite)
+	}
+	if err := o.Limits.validate(); err != nil {
+		return err
+	}
+	return validatePatterns(o.Include, o.Exclude)
+}
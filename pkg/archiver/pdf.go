@@ -0,0 +1,192 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// pdfFormat optimizes PDF documents
+type pdfFormat struct{}
+
+func (pdfFormat) Name() string             { return "pdf" }
+func (pdfFormat) Extensions() []string     { return []string{".pdf"} }
+func (pdfFormat) Capabilities() Capability { return CanCompress }
+
+func (pdfFormat) Detect(header []byte) bool {
+	return bytes.HasPrefix(header, []byte("%PDF-"))
+}
+
+func (f pdfFormat) Compress(sources []Source, destPath string, opts CompressOptions, progressTracker *ProgressTracker) error {
+	sourcePath, err := singleSource(f, sources)
+	if err != nil {
+		return err
+	}
+	if !matchesContent(sourcePath, f) {
+		return fmt.Errorf("source file must be a PDF for PDF compression")
+	}
+	return compressPDF(opts.context(), sourcePath, destPath, progressTracker)
+}
+
+// compressStages starts with Ghostscript, the pdfcpu stages after it are skipped when it succeeds
+func (pdfFormat) compressStages() []string {
+	return []string{StageGhostscript, StageExtractingImages, StageOptimizingImages, StageOptimizing}
+}
+
+func (pdfFormat) Extract(sourcePath, destPath string, opts ExtractOptions, progressTracker *ProgressTracker) error {
+	return fmt.Errorf("pdf extraction: %w", ErrUnsupported)
+}
+
+func (pdfFormat) List(sourcePath string) ([]Entry, error) {
+	return nil, fmt.Errorf("pdf listing: %w", ErrUnsupported)
+}
+
+func (pdfFormat) Test(sourcePath, password string) (*TestResult, error) {
+	return nil, fmt.Errorf("pdf testing: %w", ErrUnsupported)
+}
+
+// compressPDF compresses a PDF file with extreme compression. Canceling ctx stops
+// Ghostscript, and the other steps before they start. progressTracker, which may be nil,
+// follows the stages of pdfFormat.compressStages after the first one.
+func compressPDF(ctx context.Context, sourcePath, destPath string, progressTracker *ProgressTracker) error {
+	// Create temporary files for multi-stage optimization
+	tempFile1 := destPath + ".temp1"
+	tempDir := destPath + ".tempdir"
+	defer os.Remove(tempFile1)  // Clean up when done
+	defer os.RemoveAll(tempDir) // Clean up temp directory when done
+
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return fmt.Errorf("source path error: %w", err)
+	}
+	name := filepath.Base(sourcePath)
+	progressTracker.StartFile(name)
+	// finish reports the compressed document once it is written
+	finish := func() error {
+		if compressed, err := os.Stat(destPath); err == nil {
+			progressTracker.FinishFile(name, info.Size(), compressed.Size())
+		}
+		progressTracker.SetComplete()
+		return nil
+	}
+
+	// Try using Ghostscript for better compression
+	cmd := exec.CommandContext(ctx, "gs",
+		"-sDEVICE=pdfwrite",
+		"-dPDFSETTINGS=/screen", // Options: /screen (72dpi), /ebook (150dpi), /printer (300dpi), /prepress (300dpi+)
+		"-dCompatibilityLevel=1.4",
+		"-dNOPAUSE",
+		"-dQUIET",
+		"-dBATCH",
+		"-dColorImageDownsampleType=/Bicubic",
+		"-dColorImageResolution=72",
+		"-dGrayImageDownsampleType=/Bicubic",
+		"-dGrayImageResolution=72",
+		"-dMonoImageDownsampleType=/Bicubic",
+		"-dMonoImageResolution=72",
+		"-sOutputFile="+destPath,
+		sourcePath)
+
+	// Try Ghostscript first
+	err = cmd.Run()
+	if err == nil {
+		// Ghostscript succeeded
+		return finish()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Ghostscript not available or failed, create temp directory for processing with pdfcpu
+	err = os.MkdirAll(tempDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	// Stage 1: Extract and optimize images
+	// Extract images from PDF (if possible)
+	progressTracker.SetStage(StageExtractingImages)
+	err = api.ExtractImagesFile(sourcePath, tempDir, nil, nil)
+	progressTracker.SetStage(StageOptimizingImages)
+	if err != nil {
+		// If image extraction fails, just proceed with regular optimization
+		fmt.Printf("Warning: Image extraction failed, proceeding with standard optimization: %v\n", err)
+	} else {
+		// Recompress all extracted images with high compression
+		var images []string
+		err = filepath.Walk(tempDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			// Skip directories
+			if !info.IsDir() {
+				images = append(images, path)
+			}
+			return nil
+		})
+
+		for i := 0; err == nil && i < len(images); i++ {
+			path := images[i]
+			ext := strings.ToLower(filepath.Ext(path))
+			switch ext {
+			case ".jpg", ".jpeg":
+				// Compress JPEG with quality 1
+				err = compressJPEG(ctx, path, path, nil)
+			case ".png":
+				// Compress PNG with maximum compression
+				err = compressPNG(ctx, path, path, LevelMaximum, nil)
+			}
+			progressTracker.SetStageProgress(float64(i+1) / float64(len(images)))
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err != nil {
+			fmt.Printf("Warning: Image recompression failed: %v\n", err)
+		}
+	}
+
+	// Create a configuration with maximum compression settings
+	conf := model.NewDefaultConfiguration()
+
+	// Enable PDF 1.5 features for better compression
+	conf.Reader15 = true
+	conf.WriteObjectStream = true
+	conf.WriteXRefStream = true
+
+	// Stage 2: Apply optimization
+	progressTracker.SetStage(StageOptimizing)
+	err = api.OptimizeFile(sourcePath, tempFile1, conf)
+	if err != nil {
+		return fmt.Errorf("failed PDF compression: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	progressTracker.SetStageProgress(0.5)
+
+	// Stage 3: Convert to PDF 1.5 for better compression
+	finalConf := model.NewDefaultConfiguration()
+	finalConf.Reader15 = true
+	finalConf.WriteObjectStream = true
+	finalConf.WriteXRefStream = true
+
+	// Apply final optimization
+	err = api.OptimizeFile(tempFile1, destPath, finalConf)
+	if err != nil {
+		return fmt.Errorf("failed final PDF optimization: %w", err)
+	}
+
+	return finish()
+}
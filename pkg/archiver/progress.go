@@ -2,50 +2,388 @@ package archiver
 
 import (
 	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ProgressCallback is a function type that receives progress updates
 type ProgressCallback func(bytesWritten, totalSize int64)
 
-// ProgressTracker implements functionality for tracking progress
+// ProgressHandler receives the progress events of a ProgressTracker.
+// Events are delivered one at a time, in order, and the handler must not
+// call the methods of the tracker.
+type ProgressHandler func(event ProgressEvent)
+
+// DefaultProgressInterval is the minimum time between two ProgressUpdate events, unless a
+// tracker sets its own. Stage, file and completion events are always sent.
+const DefaultProgressInterval = 100 * time.Millisecond
+
+// Stages reported by the archiver in ProgressEvent.Stage. Scanning counts the files
+// to compress before the stages of the format, the other stages depend on the format.
+const (
+	StageScanning    = "scanning"
+	StageCompressing = "compressing"
+	StageExtracting  = "extracting"
+	// Images are decoded, resized and encoded again
+	StageDecoding = "decoding"
+	StageResizing = "resizing"
+	StageEncoding = "encoding"
+	// PDF documents are rewritten by Ghostscript, or else optimized with pdfcpu
+	// after their images are extracted and recompressed
+	StageGhostscript      = "ghostscript"
+	StageExtractingImages = "extracting images"
+	StageOptimizingImages = "optimizing images"
+	StageOptimizing       = "optimizing"
+)
+
+// ProgressKind tells what a ProgressEvent reports
+type ProgressKind int
+
+const (
+	// ProgressUpdate reports the counters, at most once per interval, and at completion
+	ProgressUpdate ProgressKind = iota
+	// StageStarted is sent when the operation moves to another stage
+	StageStarted
+	// FileStarted and FileFinished are sent for every entry compressed or extracted
+	FileStarted
+	FileFinished
+)
+
+// ProgressEvent is a snapshot of the progress of a compression or extraction
+type ProgressEvent struct {
+	Kind ProgressKind
+	// Stage names the current step, e.g. StageCompressing
+	Stage string
+	// StageIndex is the position of Stage among the StageCount stages of the operation,
+	// starting at 1. It is 0 before the first stage, while scanning.
+	StageIndex int
+	StageCount int
+	// StageProgress is the fraction of the current stage done, from 0 to 1. Unless the
+	// stage reports it, it follows the bytes processed since the stage started.
+	StageProgress float64
+	// BytesDone and BytesTotal count the data processed, BytesTotal is 0 if unknown
+	BytesDone  int64
+	BytesTotal int64
+	// File is the entry being processed, if any, or the entry of a FileStarted or FileFinished event
+	File string
+	// FileSize and FileStoredSize are set by FileFinished events to the size of the
+	// entry and the size of its data in the archive, or -1 if the format does not tell
+	FileSize       int64
+	FileStoredSize int64
+	// FilesDone and FilesTotal count the entries processed, FilesTotal is 0 if unknown
+	FilesDone  int64
+	FilesTotal int64
+	// Speed is the average number of bytes processed per second
+	Speed float64
+	// ETA is the estimated time left, 0 if unknown
+	ETA time.Duration
+	// Elapsed is the time since the operation started
+	Elapsed time.Duration
+	// Done is set on the last event of a completed operation
+	Done bool
+}
+
+// Percent returns the progress in percent, 0 if the total size is unknown
+func (e ProgressEvent) Percent() float64 {
+	if e.BytesTotal <= 0 {
+		return 0
+	}
+	return min(float64(e.BytesDone)/float64(e.BytesTotal)*100, 100)
+}
+
+// Fraction returns the overall progress from 0 to 1, through the stages of the
+// operation if it has several, or else through its bytes
+func (e ProgressEvent) Fraction() float64 {
+	switch {
+	case e.Done:
+		return 1
+	case e.StageCount > 1:
+		if e.StageIndex == 0 {
+			return 0
+		}
+		return min((float64(e.StageIndex-1)+e.StageProgress)/float64(e.StageCount), 1)
+	}
+	return e.Percent() / 100
+}
+
+// FileRatio returns the size of the entry of a FileFinished event in the archive
+// relative to its size, e.g. 0.25 for data compressed to a quarter. It returns 0
+// if either size is unknown.
+func (e ProgressEvent) FileRatio() float64 {
+	if e.FileSize <= 0 || e.FileStoredSize < 0 {
+		return 0
+	}
+	return float64(e.FileStoredSize) / float64(e.FileSize)
+}
+
+// ProgressTracker implements functionality for tracking progress.
+// It is safe for concurrent use, counters are updated atomically
+// and ProgressUpdate events are throttled to one per interval.
 type ProgressTracker struct {
-	callback  ProgressCallback
-	totalSize int64
-	written   int64
+	callback ProgressCallback
+	handler  ProgressHandler
+	interval time.Duration
+
+	totalSize  atomic.Int64
+	written    atomic.Int64
+	filesTotal atomic.Int64
+	filesDone  atomic.Int64
+
+	// mu guards the fields below and serializes the events
+	mu       sync.Mutex
+	start    time.Time
+	lastSent time.Time
+	stages   []string
+	stage    string
+	file     string
+	// stageBytes is the byte counter at the start of the stage, stageProgress
+	// the progress of the stage set by SetStageProgress, if stageProgressSet
+	stageBytes       int64
+	stageProgress    float64
+	stageProgressSet bool
 }
 
 // NewProgressCallback creates a new ProgressTracker with the provided callback
 func NewProgressCallback(callback ProgressCallback) *ProgressTracker {
 	return &ProgressTracker{
 		callback: callback,
+		interval: DefaultProgressInterval,
+		start:    time.Now(),
+	}
+}
+
+// NewProgressTracker creates a ProgressTracker sending ProgressEvents to handler, at most one
+// update per interval. A zero interval selects DefaultProgressInterval.
+func NewProgressTracker(handler ProgressHandler, interval time.Duration) *ProgressTracker {
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	return &ProgressTracker{
+		handler:  handler,
+		interval: interval,
+		start:    time.Now(),
 	}
 }
 
-// SetTotalSize sets the total size for progress calculation
+// SetTotalSize sets the total size for progress calculation and restarts the clock
+// of the speed and ETA. All ProgressTracker methods are safe to call on a nil tracker.
 func (pt *ProgressTracker) SetTotalSize(size int64) {
-	pt.totalSize = size
+	if pt == nil {
+		return
+	}
+	pt.totalSize.Store(size)
+	pt.mu.Lock()
+	pt.start = time.Now()
+	pt.mu.Unlock()
 	// Report initial progress
-	pt.ReportProgress(0)
+	pt.report(true)
+}
+
+// SetTotalFiles sets the number of entries the operation processes
+func (pt *ProgressTracker) SetTotalFiles(count int64) {
+	if pt != nil {
+		pt.filesTotal.Store(count)
+	}
+}
+
+// SetStages declares the stages of the operation in order, for the StageIndex and
+// StageCount of the events
+func (pt *ProgressTracker) SetStages(stages ...string) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	pt.stages = stages
+	pt.mu.Unlock()
+}
+
+// SetStage reports the start of a new step of the operation
+func (pt *ProgressTracker) SetStage(stage string) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.stage = stage
+	pt.stageBytes = pt.written.Load()
+	pt.stageProgress, pt.stageProgressSet = 0, false
+	pt.sendLocked(StageStarted, nil)
+}
+
+// SetStageProgress reports the fraction of the current stage done, from 0 to 1,
+// for stages that do not follow the byte counter
+func (pt *ProgressTracker) SetStageProgress(done float64) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	pt.stageProgress, pt.stageProgressSet = min(max(done, 0), 1), true
+	pt.mu.Unlock()
+	pt.report(false)
+}
+
+// StartFile records the entry name as the one being processed
+func (pt *ProgressTracker) StartFile(name string) {
+	if pt == nil {
+		return
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.file = name
+	pt.sendLocked(FileStarted, nil)
+}
+
+// FinishFile counts the entry name as processed. size is the size of the entry and
+// storedSize the size of its data in the archive, or -1 if it is not known.
+func (pt *ProgressTracker) FinishFile(name string, size, storedSize int64) {
+	if pt == nil {
+		return
+	}
+	pt.filesDone.Add(1)
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.sendLocked(FileFinished, func(event *ProgressEvent) {
+		event.File = name
+		event.FileSize = size
+		event.FileStoredSize = storedSize
+	})
 }
 
 // AddProgress adds to the progress counter and reports the current progress
 func (pt *ProgressTracker) AddProgress(bytes int64) {
-	pt.written += bytes
-	pt.ReportProgress(pt.written)
+	if pt == nil {
+		return
+	}
+	pt.written.Add(bytes)
+	pt.report(false)
 }
 
-// ReportProgress reports the current progress through the callback
+// ReportProgress sets the progress counter to bytesWritten and reports it
 func (pt *ProgressTracker) ReportProgress(bytesWritten int64) {
-	if pt.callback != nil {
-		pt.callback(bytesWritten, pt.totalSize)
+	if pt == nil {
+		return
 	}
+	pt.written.Store(bytesWritten)
+	pt.report(false)
 }
 
 // SetComplete marks the progress as complete
 func (pt *ProgressTracker) SetComplete() {
-	if pt.callback != nil {
-		pt.callback(pt.totalSize, pt.totalSize)
+	if pt == nil {
+		return
+	}
+	pt.written.Store(pt.totalSize.Load())
+	if total := pt.filesTotal.Load(); total > 0 {
+		pt.filesDone.Store(total)
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	pt.file = ""
+	pt.stageProgress, pt.stageProgressSet = 1, true
+	pt.sendLocked(ProgressUpdate, func(event *ProgressEvent) {
+		event.Done = true
+	})
+}
+
+// Event returns a snapshot of the current progress
+func (pt *ProgressTracker) Event() ProgressEvent {
+	if pt == nil {
+		return ProgressEvent{}
+	}
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+	return pt.event(time.Now())
+}
+
+// report sends a ProgressUpdate event unless one was sent less than an interval ago,
+// or another goroutine is sending one. force sends it in any case.
+func (pt *ProgressTracker) report(force bool) {
+	if pt.callback == nil && pt.handler == nil {
+		return
+	}
+	if force {
+		pt.mu.Lock()
+	} else if !pt.mu.TryLock() {
+		return
+	}
+	defer pt.mu.Unlock()
+
+	if !force && time.Since(pt.lastSent) < pt.interval {
+		return
 	}
+	pt.sendLocked(ProgressUpdate, nil)
+}
+
+// sendLocked sends an event of the given kind, completed by set if it is not nil,
+// while pt.mu is held. The callback of NewProgressCallback only receives updates.
+func (pt *ProgressTracker) sendLocked(kind ProgressKind, set func(event *ProgressEvent)) {
+	if pt.callback == nil && pt.handler == nil {
+		return
+	}
+	now := time.Now()
+	event := pt.event(now)
+	event.Kind = kind
+	if set != nil {
+		set(&event)
+	}
+
+	if kind == ProgressUpdate {
+		pt.lastSent = now
+		if pt.callback != nil {
+			pt.callback(event.BytesDone, event.BytesTotal)
+		}
+	}
+	if pt.handler != nil {
+		pt.handler(event)
+	}
+}
+
+// event builds the event at time now while pt.mu is held
+func (pt *ProgressTracker) event(now time.Time) ProgressEvent {
+	event := ProgressEvent{
+		Stage:      pt.stage,
+		StageCount: len(pt.stages),
+		BytesDone:  pt.written.Load(),
+		BytesTotal: pt.totalSize.Load(),
+		File:       pt.file,
+		FilesDone:  pt.filesDone.Load(),
+		FilesTotal: pt.filesTotal.Load(),
+		Elapsed:    now.Sub(pt.start),
+	}
+	for i, stage := range pt.stages {
+		if stage == pt.stage {
+			event.StageIndex = i + 1
+			break
+		}
+	}
+	if pt.stageProgressSet {
+		event.StageProgress = pt.stageProgress
+	} else if left := event.BytesTotal - pt.stageBytes; left > 0 {
+		event.StageProgress = min(max(float64(event.BytesDone-pt.stageBytes)/float64(left), 0), 1)
+	}
+	if seconds := event.Elapsed.Seconds(); seconds > 0 {
+		event.Speed = float64(event.BytesDone) / seconds
+	}
+	if event.Speed > 0 && event.BytesTotal > event.BytesDone {
+		event.ETA = time.Duration(float64(event.BytesTotal-event.BytesDone) / event.Speed * float64(time.Second))
+	}
+	return event
+}
+
+// stagedCompressor is implemented by formats compressing in several stages, reported
+// in this order. The other formats compress in the single stage StageCompressing.
+type stagedCompressor interface {
+	compressStages() []string
+}
+
+// compressStages returns the stages of a compression with f, the first one is
+// started before f compresses
+func compressStages(f Format) []string {
+	if s, ok := f.(stagedCompressor); ok {
+		return s.compressStages()
+	}
+	return []string{StageCompressing}
 }
 
 // ProgressWriter is an io.Writer that reports progress
@@ -72,3 +410,28 @@ func (pw *ProgressWriter) Write(p []byte) (n int, err error) {
 	}
 	return
 }
+
+// ProgressReader is an io.Reader that reports progress
+type ProgressReader struct {
+	Reader   io.Reader
+	Tracker  *ProgressTracker
+	Progress int64
+}
+
+// NewProgressReader creates a new progress-tracking reader
+func NewProgressReader(reader io.Reader, tracker *ProgressTracker) *ProgressReader {
+	return &ProgressReader{
+		Reader:  reader,
+		Tracker: tracker,
+	}
+}
+
+// Read implements the io.Reader interface with progress reporting
+func (pr *ProgressReader) Read(p []byte) (n int, err error) {
+	n, err = pr.Reader.Read(p)
+	if n > 0 {
+		pr.Progress += int64(n)
+		pr.Tracker.AddProgress(int64(n))
+	}
+	return
+}
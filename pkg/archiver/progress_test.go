@@ -0,0 +1,204 @@
+package archiver
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressTrackerConcurrent(t *testing.T) {
+	const (
+		workers  = 16
+		files    = 50
+		fileSize = 1000
+		interval = 10 * time.Millisecond
+	)
+
+	// The handler is called with the tracker locked, so it needs no lock of its own
+	var events []ProgressEvent
+	tracker := NewProgressTracker(func(event ProgressEvent) {
+		events = append(events, event)
+	}, interval)
+	tracker.SetTotalSize(workers * files * fileSize)
+	tracker.SetTotalFiles(workers * files)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := 0; f < files; f++ {
+				name := fmt.Sprintf("worker%d/file%d", w, f)
+				tracker.StartFile(name)
+				for i := 0; i < 10; i++ {
+					tracker.AddProgress(fileSize / 10)
+				}
+				time.Sleep(time.Millisecond)
+				tracker.FinishFile(name, fileSize, fileSize/2)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The counters are exact before completion sets them
+	if event := tracker.Event(); event.BytesDone != workers*files*fileSize || event.FilesDone != workers*files {
+		t.Errorf("counted %d bytes and %d files, want %d and %d", event.BytesDone, event.FilesDone, workers*files*fileSize, workers*files)
+	}
+	tracker.SetComplete()
+
+	var started, finished, updates int
+	var previous ProgressEvent
+	var lastUpdate time.Duration
+	for i, event := range events {
+		if event.BytesDone < previous.BytesDone || event.FilesDone < previous.FilesDone {
+			t.Fatalf("event %d went back from %d bytes and %d files to %d and %d",
+				i, previous.BytesDone, previous.FilesDone, event.BytesDone, event.FilesDone)
+		}
+		previous = event
+		switch event.Kind {
+		case FileStarted:
+			started++
+		case FileFinished:
+			finished++
+		case ProgressUpdate:
+			// Updates are throttled, except the forced first and last ones
+			if updates > 0 && !event.Done && event.Elapsed-lastUpdate < interval {
+				t.Errorf("update %d sent %v after the previous one, want at least %v", updates, event.Elapsed-lastUpdate, interval)
+			}
+			lastUpdate = event.Elapsed
+			updates++
+		}
+	}
+	if started != workers*files || finished != workers*files {
+		t.Errorf("%d files started and %d finished, want %d", started, finished, workers*files)
+	}
+	if updates < 3 {
+		t.Errorf("%d updates, want some between the first and the last", updates)
+	}
+
+	last := events[len(events)-1]
+	if last.Kind != ProgressUpdate || !last.Done || last.BytesDone != last.BytesTotal || last.FilesDone != workers*files {
+		t.Errorf("last event %+v, want the completed update", last)
+	}
+}
+
+func TestProgressTrackerDone(t *testing.T) {
+	// The completion is sent even right after a throttled update
+	var calls [][2]int64
+	tracker := NewProgressCallback(func(bytesWritten, totalSize int64) {
+		calls = append(calls, [2]int64{bytesWritten, totalSize})
+	})
+	tracker.SetTotalSize(100)
+	tracker.AddProgress(40)
+	tracker.AddProgress(40)
+	tracker.SetComplete()
+	if len(calls) == 0 || calls[len(calls)-1] != [2]int64{100, 100} {
+		t.Errorf("calls %v, want the last one at 100 of 100", calls)
+	}
+
+	// A nil tracker ignores every call
+	var none *ProgressTracker
+	none.SetTotalSize(10)
+	none.StartFile("a")
+	none.AddProgress(10)
+	none.FinishFile("a", 10, 5)
+	none.SetComplete()
+	if event := none.Event(); event != (ProgressEvent{}) {
+		t.Errorf("event of a nil tracker %+v, want none", event)
+	}
+}
+
+func TestImageProgressStages(t *testing.T) {
+	// Large enough to be resized
+	img := image.NewRGBA(image.Rect(0, 0, 600, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 600; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+	dir := t.TempDir()
+	sources := map[string]string{"png": filepath.Join(dir, "image.png"), "jpg": filepath.Join(dir, "image.jpg")}
+	for format, path := range sources {
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format == "png" {
+			err = png.Encode(file, img)
+		} else {
+			err = jpeg.Encode(file, img, &jpeg.Options{Quality: 95})
+		}
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for format, source := range sources {
+		t.Run(format, func(t *testing.T) {
+			var events []ProgressEvent
+			tracker := NewProgressTracker(func(event ProgressEvent) {
+				events = append(events, event)
+			}, time.Nanosecond)
+			dest := filepath.Join(t.TempDir(), "small."+format)
+			if err := CompressWithProgress(source, dest, format, CompressOptions{}, tracker); err != nil {
+				t.Fatalf("Compress: %v", err)
+			}
+
+			var stages []string
+			var started, finished, decoding int
+			var previous float64
+			for i, event := range events {
+				if event.StageCount != len(imageStages) {
+					t.Fatalf("event %d counts %d stages, want %d", i, event.StageCount, len(imageStages))
+				}
+				if fraction := event.Fraction(); fraction < previous {
+					t.Errorf("event %d went back from %v to %v in stage %s", i, previous, fraction, event.Stage)
+				} else {
+					previous = fraction
+				}
+				switch event.Kind {
+				case StageStarted:
+					stages = append(stages, event.Stage)
+					if want := slices.Index(imageStages, event.Stage) + 1; event.StageIndex != want {
+						t.Errorf("stage %s at index %d, want %d", event.Stage, event.StageIndex, want)
+					}
+				case ProgressUpdate:
+					// Decoding follows the bytes read, within the first stage
+					if event.Stage == StageDecoding && event.Fraction() > 0 && event.Fraction() <= 1.0/3 {
+						decoding++
+					}
+				case FileStarted:
+					started++
+				case FileFinished:
+					finished++
+					if event.File != "image."+format || event.FileRatio() <= 0 {
+						t.Errorf("finished %s with ratio %v, want image.%s", event.File, event.FileRatio(), format)
+					}
+				}
+			}
+
+			want := append([]string{StageScanning}, imageStages...)
+			if !slices.Equal(stages, want) {
+				t.Errorf("stages %v, want %v", stages, want)
+			}
+			if started != 1 || finished != 1 {
+				t.Errorf("%d files started and %d finished, want 1", started, finished)
+			}
+			if decoding == 0 {
+				t.Error("no progress while decoding")
+			}
+			if last := events[len(events)-1]; !last.Done || last.Fraction() != 1 {
+				t.Errorf("last event %+v, want the completed update", last)
+			}
+		})
+	}
+}
@@ -0,0 +1,1390 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// sevenZipMagic is the signature at the start of every 7z archive
+var sevenZipMagic = []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}
+
+// sevenZipFormat extracts 7z archives.
+// LZMA, LZMA2, Deflate, BZip2 and stored folders are supported, including solid archives.
+type sevenZipFormat struct{}
+
+func (sevenZipFormat) Name() string             { return "7z" }
+func (sevenZipFormat) Extensions() []string     { return []string{".7z"} }
+func (sevenZipFormat) Capabilities() Capability { return CanExtract | CanList }
+
+func (sevenZipFormat) Detect(header []byte) bool {
+	return bytes.HasPrefix(header, sevenZipMagic)
+}
+
+func (sevenZipFormat) Compress(sources []Source, destPath string, opts CompressOptions, progressTracker *ProgressTracker) error {
+	return fmt.Errorf("7z compression: %w", ErrUnsupported)
+}
+
+func (sevenZipFormat) Extract(sourcePath, destPath string, opts ExtractOptions, progressTracker *ProgressTracker) error {
+	return extract7z(sourcePath, destPath, opts, progressTracker)
+}
+
+func (sevenZipFormat) Test(sourcePath, password string) (*TestResult, error) {
+	return test7z(sourcePath, nil)
+}
+
+func (sevenZipFormat) testLimited(sourcePath, password string, guard *extractGuard) (*TestResult, error) {
+	return test7z(sourcePath, guard)
+}
+
+func (sevenZipFormat) extractReaderAt(r io.ReaderAt, size int64, sink Sink, opts ExtractOptions) error {
+	archive, err := read7z(r, size)
+	if err != nil {
+		return err
+	}
+	return extract7zTo(archive, sink, opts)
+}
+
+func (sevenZipFormat) List(sourcePath string) ([]Entry, error) {
+	archive, err := open7z(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	locations, err := archive.locate()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(archive.files))
+	for i, file := range archive.files {
+		entry := Entry{
+			Name:     file.name,
+			Size:     int64(file.size),
+			Mode:     file.mode,
+			Modified: file.modTime,
+		}
+		if file.hasStream {
+			folder := archive.folders[locations[i].folder]
+			substream := archive.substreams[locations[i].substream]
+			entry.Method, entry.Encrypted = folder.method()
+			entry.CRC32 = substream.crc
+			// Files of solid folders share their packed data
+			if folder.numSubstreams == 1 {
+				entry.CompressedSize = int64(archive.packedSize(folder))
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// 7z header property IDs
+const (
+	sevenZipIDEnd               = 0x00
+	sevenZipIDHeader            = 0x01
+	sevenZipIDArchiveProperties = 0x02
+	sevenZipIDAdditionalStreams = 0x03
+	sevenZipIDMainStreamsInfo   = 0x04
+	sevenZipIDFilesInfo         = 0x05
+	sevenZipIDPackInfo          = 0x06
+	sevenZipIDUnpackInfo        = 0x07
+	sevenZipIDSubStreamsInfo    = 0x08
+	sevenZipIDSize              = 0x09
+	sevenZipIDCRC               = 0x0A
+	sevenZipIDFolder            = 0x0B
+	sevenZipIDCodersUnpackSize  = 0x0C
+	sevenZipIDNumUnpackStream   = 0x0D
+	sevenZipIDEmptyStream       = 0x0E
+	sevenZipIDEmptyFile         = 0x0F
+	sevenZipIDName              = 0x11
+	sevenZipIDATime             = 0x13
+	sevenZipIDMTime             = 0x14
+	sevenZipIDWinAttributes     = 0x15
+	sevenZipIDEncodedHeader     = 0x17
+	sevenZipSignatureHeaderLen  = 32
+
+	// sevenZipMaxHeaderSize bounds the decoded size of compressed headers,
+	// far above the few hundred bytes a header takes per entry
+	sevenZipMaxHeaderSize = 64 << 20
+)
+
+// Windows file attributes used by 7z
+const (
+	winAttributeDirectory     = 0x10
+	winAttributeUnixExtension = 0x8000
+)
+
+var errSevenZipHeader = errors.New("corrupt 7z header")
+
+// sevenZipCoder is a single decompression step of a folder
+type sevenZipCoder struct {
+	id         []byte
+	numIn      int
+	numOut     int
+	properties []byte
+}
+
+// sevenZipBindPair connects the output of one coder to the input of another
+type sevenZipBindPair struct {
+	inIndex  int
+	outIndex int
+}
+
+// sevenZipFolder is a group of coders producing one stream of (possibly solid) file data
+type sevenZipFolder struct {
+	coders          []sevenZipCoder
+	bindPairs       []sevenZipBindPair
+	packedStreams   []int
+	unpackSizes     []uint64
+	firstPackStream int
+	numSubstreams   int
+	crc             uint32
+	hasCRC          bool
+}
+
+// sevenZipSubstream is the data of one file inside a folder
+type sevenZipSubstream struct {
+	size   uint64
+	crc    uint32
+	hasCRC bool
+}
+
+// sevenZipStreams describes where packed data lives and how to decode it
+type sevenZipStreams struct {
+	packPos    uint64
+	packSizes  []uint64
+	folders    []*sevenZipFolder
+	substreams []sevenZipSubstream
+}
+
+// sevenZipFile is a file, directory or symlink entry of a 7z archive
+type sevenZipFile struct {
+	name       string
+	size       uint64
+	hasStream  bool
+	mode       os.FileMode
+	modTime    time.Time
+	accessTime time.Time
+}
+
+// sevenZipArchive is an open 7z archive with its parsed header
+type sevenZipArchive struct {
+	reader io.ReaderAt
+	closer io.Closer
+	sevenZipStreams
+	files []sevenZipFile
+}
+
+// Close closes the underlying archive file, if the archive was opened from one
+func (a *sevenZipArchive) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// open7z opens the archive at sourcePath and parses its header
+func open7z(sourcePath string) (*sevenZipArchive, error) {
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open 7z file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open 7z file: %w", err)
+	}
+	archive, err := read7z(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	archive.closer = file
+	return archive, nil
+}
+
+// read7z parses the header of the archive of the given size read from r
+func read7z(r io.ReaderAt, size int64) (*sevenZipArchive, error) {
+	archive := &sevenZipArchive{reader: r}
+	if err := archive.readHeader(size); err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// readHeader reads the signature header and the (possibly compressed) archive header
+// of an archive of the given size
+func (a *sevenZipArchive) readHeader(size int64) error {
+	signature := make([]byte, sevenZipSignatureHeaderLen)
+	if _, err := a.reader.ReadAt(signature, 0); err != nil {
+		return fmt.Errorf("failed to read 7z signature header: %w", err)
+	}
+	if !bytes.HasPrefix(signature, sevenZipMagic) {
+		return fmt.Errorf("not a 7z archive")
+	}
+	if crc32.ChecksumIEEE(signature[12:32]) != binary.LittleEndian.Uint32(signature[8:12]) {
+		return fmt.Errorf("%w: signature header checksum mismatch", errSevenZipHeader)
+	}
+
+	nextOffset := binary.LittleEndian.Uint64(signature[12:20])
+	nextSize := binary.LittleEndian.Uint64(signature[20:28])
+	nextCRC := binary.LittleEndian.Uint32(signature[28:32])
+	if nextSize == 0 {
+		// Empty archive
+		return nil
+	}
+	// The header lies within the archive, checked before it is allocated
+	if nextSize > 1<<30 || nextOffset > 1<<62 || sevenZipSignatureHeaderLen+nextOffset+nextSize > uint64(size) {
+		return fmt.Errorf("%w: header beyond the end of the archive", errSevenZipHeader)
+	}
+
+	header := make([]byte, nextSize)
+	if _, err := a.reader.ReadAt(header, sevenZipSignatureHeaderLen+int64(nextOffset)); err != nil {
+		return fmt.Errorf("failed to read 7z header: %w", err)
+	}
+	if crc32.ChecksumIEEE(header) != nextCRC {
+		return fmt.Errorf("%w: checksum mismatch", errSevenZipHeader)
+	}
+
+	for {
+		p := &sevenZipParser{data: header}
+		switch p.byte() {
+		case sevenZipIDHeader:
+			if err := a.parseHeader(p); err != nil {
+				return err
+			}
+			return nil
+
+		case sevenZipIDEncodedHeader:
+			// The real header is itself compressed, decode it and parse again
+			streams, err := parseStreamsInfo(p)
+			if err != nil {
+				return err
+			}
+			if len(streams.folders) == 0 {
+				return errSevenZipHeader
+			}
+			encoded := &sevenZipArchive{reader: a.reader, sevenZipStreams: *streams}
+			reader, err := encoded.folderReader(0)
+			if err != nil {
+				return err
+			}
+			// The decoded size is declared by the archive itself, so it is bounded here
+			header, err = io.ReadAll(io.LimitReader(reader, sevenZipMaxHeaderSize+1))
+			if err != nil {
+				return fmt.Errorf("failed to decode 7z header: %w", err)
+			}
+			if len(header) > sevenZipMaxHeaderSize {
+				return &LimitError{Limit: "header size", Max: sevenZipMaxHeaderSize, Name: "7z header"}
+			}
+
+		default:
+			return errSevenZipHeader
+		}
+	}
+}
+
+// parseHeader parses the main archive header
+func (a *sevenZipArchive) parseHeader(p *sevenZipParser) error {
+	id := p.byte()
+	if id == sevenZipIDArchiveProperties {
+		for p.byte() != sevenZipIDEnd && p.err == nil {
+			p.skip(p.number())
+		}
+		id = p.byte()
+	}
+	if id == sevenZipIDAdditionalStreams {
+		if _, err := parseStreamsInfo(p); err != nil {
+			return err
+		}
+		id = p.byte()
+	}
+	if id == sevenZipIDMainStreamsInfo {
+		streams, err := parseStreamsInfo(p)
+		if err != nil {
+			return err
+		}
+		a.sevenZipStreams = *streams
+		id = p.byte()
+	}
+	if id == sevenZipIDFilesInfo {
+		if err := a.parseFilesInfo(p); err != nil {
+			return err
+		}
+		id = p.byte()
+	}
+	if p.err != nil || id != sevenZipIDEnd {
+		return errSevenZipHeader
+	}
+	return nil
+}
+
+// parseStreamsInfo parses the pack, unpack and substream sections of a header
+func parseStreamsInfo(p *sevenZipParser) (*sevenZipStreams, error) {
+	streams := &sevenZipStreams{}
+
+	id := p.byte()
+	if id == sevenZipIDPackInfo {
+		streams.packPos = p.number()
+		numPackStreams := p.count()
+		for id = p.byte(); id != sevenZipIDEnd && p.err == nil; id = p.byte() {
+			switch id {
+			case sevenZipIDSize:
+				streams.packSizes = make([]uint64, numPackStreams)
+				for i := range streams.packSizes {
+					streams.packSizes[i] = p.number()
+				}
+			case sevenZipIDCRC:
+				p.digests(numPackStreams)
+			default:
+				return nil, errSevenZipHeader
+			}
+		}
+		id = p.byte()
+	}
+
+	if id == sevenZipIDUnpackInfo {
+		if err := streams.parseUnpackInfo(p); err != nil {
+			return nil, err
+		}
+		id = p.byte()
+	}
+
+	// Without substream information every folder holds exactly one file
+	for _, folder := range streams.folders {
+		folder.numSubstreams = 1
+	}
+	if id == sevenZipIDSubStreamsInfo {
+		if err := streams.parseSubStreamsInfo(p); err != nil {
+			return nil, err
+		}
+		id = p.byte()
+	} else {
+		for _, folder := range streams.folders {
+			streams.substreams = append(streams.substreams, sevenZipSubstream{
+				size:   folder.unpackSize(),
+				crc:    folder.crc,
+				hasCRC: folder.hasCRC,
+			})
+		}
+	}
+
+	if p.err != nil || id != sevenZipIDEnd {
+		return nil, errSevenZipHeader
+	}
+	return streams, nil
+}
+
+// parseUnpackInfo parses the folder definitions
+func (s *sevenZipStreams) parseUnpackInfo(p *sevenZipParser) error {
+	if p.byte() != sevenZipIDFolder {
+		return errSevenZipHeader
+	}
+	numFolders := p.count()
+	if p.byte() != 0 {
+		return fmt.Errorf("%w: external folders are not supported", errSevenZipHeader)
+	}
+
+	packStream := 0
+	s.folders = make([]*sevenZipFolder, numFolders)
+	for i := range s.folders {
+		folder, err := parseFolder(p)
+		if err != nil {
+			return err
+		}
+		folder.firstPackStream = packStream
+		packStream += len(folder.packedStreams)
+		s.folders[i] = folder
+	}
+
+	if p.byte() != sevenZipIDCodersUnpackSize {
+		return errSevenZipHeader
+	}
+	for _, folder := range s.folders {
+		folder.unpackSizes = make([]uint64, folder.numOutStreams())
+		for i := range folder.unpackSizes {
+			folder.unpackSizes[i] = p.number()
+		}
+	}
+
+	for id := p.byte(); id != sevenZipIDEnd && p.err == nil; id = p.byte() {
+		if id != sevenZipIDCRC {
+			return errSevenZipHeader
+		}
+		defined, crcs := p.digests(len(s.folders))
+		for i, folder := range s.folders {
+			folder.hasCRC, folder.crc = defined[i], crcs[i]
+		}
+	}
+	return p.err
+}
+
+// parseFolder parses the coders and bindings of a single folder
+func parseFolder(p *sevenZipParser) (*sevenZipFolder, error) {
+	folder := &sevenZipFolder{}
+	numCoders := p.count()
+	var numIn, numOut int
+	for i := 0; i < numCoders && p.err == nil; i++ {
+		flags := p.byte()
+		if flags&0x80 != 0 {
+			return nil, fmt.Errorf("%w: alternative coder methods are not supported", errSevenZipHeader)
+		}
+		coder := sevenZipCoder{id: p.bytes(int(flags & 0x0F)), numIn: 1, numOut: 1}
+		if flags&0x10 != 0 {
+			coder.numIn = p.count()
+			coder.numOut = p.count()
+		}
+		if flags&0x20 != 0 {
+			coder.properties = p.bytes(p.count())
+		}
+		numIn += coder.numIn
+		numOut += coder.numOut
+		folder.coders = append(folder.coders, coder)
+	}
+
+	for i := 0; i < numOut-1 && p.err == nil; i++ {
+		folder.bindPairs = append(folder.bindPairs, sevenZipBindPair{inIndex: p.count(), outIndex: p.count()})
+	}
+
+	numPacked := numIn - len(folder.bindPairs)
+	if numPacked < 1 {
+		return nil, errSevenZipHeader
+	}
+	if numPacked == 1 {
+		// The single packed stream feeds the only unbound input
+		for i := 0; i < numIn; i++ {
+			if folder.bindPairForIn(i) < 0 {
+				folder.packedStreams = append(folder.packedStreams, i)
+				break
+			}
+		}
+	} else {
+		for i := 0; i < numPacked; i++ {
+			folder.packedStreams = append(folder.packedStreams, p.count())
+		}
+	}
+	if len(folder.packedStreams) != numPacked {
+		return nil, errSevenZipHeader
+	}
+	return folder, p.err
+}
+
+// parseSubStreamsInfo parses the sizes and checksums of the files stored in each folder
+func (s *sevenZipStreams) parseSubStreamsInfo(p *sevenZipParser) error {
+	id := p.byte()
+	if id == sevenZipIDNumUnpackStream {
+		for _, folder := range s.folders {
+			folder.numSubstreams = p.count()
+		}
+		id = p.byte()
+	}
+
+	for _, folder := range s.folders {
+		if folder.numSubstreams == 0 {
+			continue
+		}
+		var sum uint64
+		if id == sevenZipIDSize {
+			for i := 1; i < folder.numSubstreams; i++ {
+				size := p.number()
+				sum += size
+				s.substreams = append(s.substreams, sevenZipSubstream{size: size})
+			}
+		}
+		if sum > folder.unpackSize() {
+			return errSevenZipHeader
+		}
+		s.substreams = append(s.substreams, sevenZipSubstream{size: folder.unpackSize() - sum})
+	}
+	if id == sevenZipIDSize {
+		id = p.byte()
+	}
+
+	// Checksums are listed for every substream whose folder checksum doesn't cover it
+	var unknown []*sevenZipSubstream
+	index := 0
+	for _, folder := range s.folders {
+		if folder.numSubstreams == 1 && folder.hasCRC {
+			s.substreams[index].hasCRC = true
+			s.substreams[index].crc = folder.crc
+		} else {
+			for i := 0; i < folder.numSubstreams; i++ {
+				unknown = append(unknown, &s.substreams[index+i])
+			}
+		}
+		index += folder.numSubstreams
+	}
+
+	for ; id != sevenZipIDEnd && p.err == nil; id = p.byte() {
+		if id != sevenZipIDCRC {
+			p.skip(p.number())
+			continue
+		}
+		defined, crcs := p.digests(len(unknown))
+		for i, substream := range unknown {
+			substream.hasCRC, substream.crc = defined[i], crcs[i]
+		}
+	}
+	return p.err
+}
+
+// parseFilesInfo parses the names and attributes of the archive entries
+func (a *sevenZipArchive) parseFilesInfo(p *sevenZipParser) error {
+	numFiles := p.count()
+	files := make([]sevenZipFile, numFiles)
+	var emptyStream, emptyFile []bool
+	var attributes []uint32
+	var hasAttributes []bool
+
+	for id := p.byte(); id != sevenZipIDEnd && p.err == nil; id = p.byte() {
+		size := p.number()
+		if size > uint64(len(p.data)) {
+			return errSevenZipHeader
+		}
+		prop := &sevenZipParser{data: p.bytes(int(size))}
+
+		switch id {
+		case sevenZipIDEmptyStream:
+			emptyStream = prop.bits(numFiles)
+		case sevenZipIDEmptyFile:
+			emptyFile = prop.bits(countTrue(emptyStream))
+		case sevenZipIDName:
+			if prop.byte() != 0 {
+				return fmt.Errorf("%w: external file names are not supported", errSevenZipHeader)
+			}
+			for i := range files {
+				files[i].name = prop.utf16String()
+			}
+		case sevenZipIDMTime, sevenZipIDATime:
+			defined := prop.optionalBits(numFiles)
+			if prop.byte() != 0 {
+				return fmt.Errorf("%w: external file times are not supported", errSevenZipHeader)
+			}
+			for i := range files {
+				if !defined[i] {
+					continue
+				}
+				if id == sevenZipIDMTime {
+					files[i].modTime = fileTimeToTime(prop.uint64())
+				} else {
+					files[i].accessTime = fileTimeToTime(prop.uint64())
+				}
+			}
+		case sevenZipIDWinAttributes:
+			hasAttributes = prop.optionalBits(numFiles)
+			if prop.byte() != 0 {
+				return fmt.Errorf("%w: external attributes are not supported", errSevenZipHeader)
+			}
+			attributes = make([]uint32, numFiles)
+			for i := range files {
+				if hasAttributes[i] {
+					attributes[i] = prop.uint32()
+				}
+			}
+		}
+		if prop.err != nil {
+			return errSevenZipHeader
+		}
+	}
+
+	// Assign substreams to files in order and work out their types
+	substream, empty := 0, 0
+	for i := range files {
+		file := &files[i]
+		file.mode = 0644
+		file.hasStream = emptyStream == nil || !emptyStream[i]
+		if file.hasStream {
+			if substream >= len(a.substreams) {
+				return errSevenZipHeader
+			}
+			file.size = a.substreams[substream].size
+			substream++
+		} else {
+			// Empty streams are directories unless flagged as empty files
+			if emptyFile == nil || empty >= len(emptyFile) || !emptyFile[empty] {
+				file.mode = os.ModeDir | 0755
+			}
+			empty++
+		}
+
+		if hasAttributes != nil && hasAttributes[i] {
+			attr := attributes[i]
+			if attr&winAttributeDirectory != 0 {
+				file.mode = os.ModeDir | 0755
+			}
+			if attr&winAttributeUnixExtension != 0 {
+				file.mode = unixModeToFileMode(attr >> 16)
+			}
+		}
+		if file.mode.IsDir() {
+			file.name = path.Clean(file.name) + "/"
+		}
+	}
+
+	a.files = files
+	return p.err
+}
+
+// numOutStreams returns the total number of coder outputs of the folder
+func (f *sevenZipFolder) numOutStreams() int {
+	n := 0
+	for _, coder := range f.coders {
+		n += coder.numOut
+	}
+	return n
+}
+
+// bindPairForIn returns the index of the bind pair feeding input stream in, or -1
+func (f *sevenZipFolder) bindPairForIn(in int) int {
+	for i, bp := range f.bindPairs {
+		if bp.inIndex == in {
+			return i
+		}
+	}
+	return -1
+}
+
+// mainOutStream returns the output stream that isn't consumed by another coder
+func (f *sevenZipFolder) mainOutStream() int {
+	for out := 0; out < f.numOutStreams(); out++ {
+		bound := false
+		for _, bp := range f.bindPairs {
+			if bp.outIndex == out {
+				bound = true
+				break
+			}
+		}
+		if !bound {
+			return out
+		}
+	}
+	return -1
+}
+
+// unpackSize returns the size of the folder's decoded data
+func (f *sevenZipFolder) unpackSize() uint64 {
+	out := f.mainOutStream()
+	if out < 0 || out >= len(f.unpackSizes) {
+		return 0
+	}
+	return f.unpackSizes[out]
+}
+
+// sevenZipLocation is the folder and substream index holding the data of a file
+type sevenZipLocation struct {
+	folder    int
+	substream int
+}
+
+// locate returns the location of the data of every file. Files with data are stored
+// in folder order, several per folder in solid archives. Files without data get -1.
+func (a *sevenZipArchive) locate() ([]sevenZipLocation, error) {
+	locations := make([]sevenZipLocation, len(a.files))
+	folder, remaining, next := -1, 0, 0
+	for i, file := range a.files {
+		if !file.hasStream {
+			locations[i] = sevenZipLocation{-1, -1}
+			continue
+		}
+		for remaining == 0 {
+			folder++
+			if folder >= len(a.folders) {
+				return nil, errSevenZipHeader
+			}
+			remaining = a.folders[folder].numSubstreams
+		}
+		if next >= len(a.substreams) {
+			return nil, errSevenZipHeader
+		}
+		locations[i] = sevenZipLocation{folder, next}
+		next++
+		remaining--
+	}
+	return locations, nil
+}
+
+// packedSize returns the size of the packed streams of folder
+func (a *sevenZipArchive) packedSize(folder *sevenZipFolder) uint64 {
+	var size uint64
+	for i := range folder.packedStreams {
+		if stream := folder.firstPackStream + i; stream < len(a.packSizes) {
+			size += a.packSizes[stream]
+		}
+	}
+	return size
+}
+
+// method returns the names of the coders of the folder joined with "+",
+// and whether one of them encrypts the data
+func (f *sevenZipFolder) method() (string, bool) {
+	names := make([]string, len(f.coders))
+	encrypted := false
+	for i, coder := range f.coders {
+		names[i] = sevenZipMethodName(coder.id)
+		encrypted = encrypted || string(coder.id) == sevenZipMethodAES
+	}
+	return strings.Join(names, "+"), encrypted
+}
+
+// folderReader returns a reader producing the decoded data of folder index
+func (a *sevenZipArchive) folderReader(index int) (io.Reader, error) {
+	folder := a.folders[index]
+
+	// Locate the packed streams of the folder
+	offset := sevenZipSignatureHeaderLen + a.packPos
+	for i := 0; i < folder.firstPackStream; i++ {
+		offset += a.packSizes[i]
+	}
+	packed := make(map[int]io.Reader)
+	for i, in := range folder.packedStreams {
+		stream := folder.firstPackStream + i
+		if stream >= len(a.packSizes) {
+			return nil, errSevenZipHeader
+		}
+		packed[in] = bufio.NewReader(io.NewSectionReader(a.reader, int64(offset), int64(a.packSizes[stream])))
+		offset += a.packSizes[stream]
+	}
+
+	out := folder.mainOutStream()
+	if out < 0 {
+		return nil, errSevenZipHeader
+	}
+	return folder.outReader(out, packed, 0)
+}
+
+// outReader builds the decoder chain producing output stream out
+func (f *sevenZipFolder) outReader(out int, packed map[int]io.Reader, depth int) (io.Reader, error) {
+	if depth > len(f.coders) {
+		return nil, errSevenZipHeader
+	}
+
+	// Find the coder owning the output stream
+	in, outStart := 0, 0
+	for _, coder := range f.coders {
+		if out < outStart+coder.numOut {
+			if coder.numIn != 1 || coder.numOut != 1 {
+				return nil, fmt.Errorf("unsupported 7z coder %x", coder.id)
+			}
+
+			// The coder input is either packed data or the output of another coder
+			var input io.Reader
+			if bp := f.bindPairForIn(in); bp >= 0 {
+				var err error
+				input, err = f.outReader(f.bindPairs[bp].outIndex, packed, depth+1)
+				if err != nil {
+					return nil, err
+				}
+			} else if r, ok := packed[in]; ok {
+				input = r
+			} else {
+				return nil, errSevenZipHeader
+			}
+
+			if out >= len(f.unpackSizes) {
+				return nil, errSevenZipHeader
+			}
+			return newSevenZipDecoder(coder, input, f.unpackSizes[out])
+		}
+		in += coder.numIn
+		outStart += coder.numOut
+	}
+	return nil, errSevenZipHeader
+}
+
+// 7z coder method IDs
+const (
+	sevenZipMethodCopy    = "\x00"
+	sevenZipMethodLZMA    = "\x03\x01\x01"
+	sevenZipMethodLZMA2   = "\x21"
+	sevenZipMethodDeflate = "\x04\x01\x08"
+	sevenZipMethodBZip2   = "\x04\x02\x02"
+	sevenZipMethodAES     = "\x06\xF1\x07\x01"
+	sevenZipMethodBCJ     = "\x03\x03\x01\x03"
+)
+
+// sevenZipMethodName returns the name of a coder method ID
+func sevenZipMethodName(id []byte) string {
+	switch string(id) {
+	case sevenZipMethodCopy:
+		return "store"
+	case sevenZipMethodLZMA:
+		return "lzma"
+	case sevenZipMethodLZMA2:
+		return "lzma2"
+	case sevenZipMethodDeflate:
+		return "deflate"
+	case sevenZipMethodBZip2:
+		return "bzip2"
+	case sevenZipMethodAES:
+		return "aes"
+	case sevenZipMethodBCJ:
+		return "bcj"
+	}
+	return fmt.Sprintf("%x", id)
+}
+
+// newSevenZipDecoder returns a reader decoding input with the method of coder
+func newSevenZipDecoder(coder sevenZipCoder, input io.Reader, size uint64) (io.Reader, error) {
+	var reader io.Reader
+	switch string(coder.id) {
+	case sevenZipMethodCopy:
+		// Stored
+		reader = input
+
+	case sevenZipMethodLZMA:
+		// LZMA: rebuild the classic header from the coder properties and the known size
+		if len(coder.properties) < 5 {
+			return nil, errSevenZipHeader
+		}
+		header := make([]byte, lzma.HeaderLen)
+		header[0] = coder.properties[0]
+		binary.LittleEndian.PutUint32(header[1:5], clampDictSize(binary.LittleEndian.Uint32(coder.properties[1:5]), size))
+		binary.LittleEndian.PutUint64(header[5:], size)
+		lzmaReader, err := lzma.NewReader(io.MultiReader(bytes.NewReader(header), input))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open LZMA stream: %w", err)
+		}
+		reader = lzmaReader
+
+	case sevenZipMethodLZMA2:
+		// LZMA2
+		if len(coder.properties) < 1 || coder.properties[0] > 40 {
+			return nil, errSevenZipHeader
+		}
+		dictSize := uint32(0xFFFFFFFF)
+		if p := coder.properties[0]; p < 40 {
+			dictSize = (2 | uint32(p&1)) << (p/2 + 11)
+		}
+		config := lzma.Reader2Config{DictCap: int(clampDictSize(dictSize, size))}
+		lzma2Reader, err := config.NewReader2(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open LZMA2 stream: %w", err)
+		}
+		reader = lzma2Reader
+
+	case sevenZipMethodDeflate:
+		reader = flate.NewReader(input)
+
+	case sevenZipMethodBZip2:
+		reader = bzip2.NewReader(input)
+
+	case sevenZipMethodAES:
+		return nil, fmt.Errorf("encrypted 7z archives are not supported")
+
+	default:
+		return nil, fmt.Errorf("unsupported 7z compression method %x", coder.id)
+	}
+
+	return io.LimitReader(reader, int64(size)), nil
+}
+
+// clampDictSize limits an LZMA dictionary to what a stream of size bytes can use
+func clampDictSize(dictSize uint32, size uint64) uint32 {
+	if uint64(dictSize) > size {
+		dictSize = uint32(size)
+	}
+	if dictSize < lzma.MinDictCap {
+		dictSize = lzma.MinDictCap
+	}
+	return dictSize
+}
+
+// extract7z extracts the entries of a 7z archive selected by opts with progress reporting
+func extract7z(sourcePath, destPath string, opts ExtractOptions, progressTracker *ProgressTracker) error {
+	archive, err := open7z(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	locations, err := archive.locate()
+	if err != nil {
+		return err
+	}
+
+	// Calculate total uncompressed size of the selected files for progress tracking,
+	// and which folders hold their data so the others are never decoded
+	var totalSize, selectedFiles int64
+	selected := make([]bool, len(archive.files))
+	neededFolders := make(map[int]bool)
+	for i, file := range archive.files {
+		// The "./" entry of archives made from the current directory is the destination itself
+		selected[i] = cleanEntryName(file.name) != "" && opts.selects(file.name)
+		if selected[i] {
+			selectedFiles++
+			totalSize += int64(file.size)
+			if file.hasStream {
+				neededFolders[locations[i].folder] = true
+			}
+		}
+	}
+	if err := opts.guard.checkDeclared(totalSize); err != nil {
+		return err
+	}
+	progressTracker.SetTotalFiles(selectedFiles)
+	progressTracker.SetTotalSize(totalSize)
+
+	// Directory metadata is restored last, since creating files inside updates their times
+	var dirs []pendingDir
+	folder := -1
+	var folderReader io.Reader
+	extractFile := func(i int, file sevenZipFile) error {
+		if !file.hasStream {
+			if !selected[i] {
+				return nil
+			}
+			filePath, err := opts.entryPath(destPath, file.name)
+			if err != nil {
+				return err
+			}
+			if err := opts.guard.entry(file.name); err != nil {
+				return err
+			}
+			if file.mode.IsDir() {
+				if err := opts.guard.mkdirAll(filePath); err != nil {
+					return fmt.Errorf("failed to create directory: %w", err)
+				}
+				dirs = append(dirs, pendingDir{path: filePath, meta: file.metadata()})
+				return nil
+			}
+			filePath, err = opts.resolveConflict(file.name, filePath, file.modTime)
+			if err != nil {
+				return err
+			}
+			if filePath == "" {
+				return nil
+			}
+			if err := writeExtractedFile(opts.guard, filePath, bytes.NewReader(nil), nil); err != nil {
+				return err
+			}
+			return opts.restoreMetadata(filePath, file.metadata())
+		}
+
+		location := locations[i]
+		substream := archive.substreams[location.substream]
+		if !neededFolders[location.folder] {
+			return nil
+		}
+		if location.folder != folder {
+			reader, err := archive.folderReader(location.folder)
+			if err != nil {
+				return err
+			}
+			folder, folderReader = location.folder, reader
+		}
+
+		// Skip unselected files sharing a folder with selected ones
+		if !selected[i] {
+			return skip7zFile(folderReader, substream)
+		}
+
+		filePath, err := opts.entryPath(destPath, file.name)
+		if err != nil {
+			return err
+		}
+		if err := opts.guard.entry(file.name); err != nil {
+			return err
+		}
+		filePath, err = opts.resolveConflict(file.name, filePath, file.modTime)
+		if err != nil {
+			return err
+		}
+		if filePath == "" {
+			if err := skip7zFile(folderReader, substream); err != nil {
+				return err
+			}
+			progressTracker.AddProgress(int64(substream.size))
+			return nil
+		}
+		return extract7zFile(opts, file, substream, filePath, destPath, folderReader, progressTracker)
+	}
+	for i, file := range archive.files {
+		if selected[i] {
+			progressTracker.StartFile(file.name)
+		}
+		if err := extractFile(i, file); err != nil {
+			return err
+		}
+		if selected[i] {
+			// 7z stores compressed sizes per folder, which may hold several files
+			progressTracker.FinishFile(file.name, int64(file.size), -1)
+		}
+	}
+	if err := opts.restoreDirs(dirs); err != nil {
+		return err
+	}
+
+	// Mark progress as complete
+	progressTracker.SetComplete()
+
+	return nil
+}
+
+// extract7zTo passes the entries of a 7z archive that opts selects to sink
+func extract7zTo(archive *sevenZipArchive, sink Sink, opts ExtractOptions) error {
+	locations, err := archive.locate()
+	if err != nil {
+		return err
+	}
+
+	var totalSize int64
+	selected := make([]bool, len(archive.files))
+	neededFolders := make(map[int]bool)
+	for i, file := range archive.files {
+		name := cleanEntryName(file.name)
+		selected[i] = name != "" && opts.selects(name)
+		if selected[i] {
+			totalSize += int64(file.size)
+			if file.hasStream {
+				neededFolders[locations[i].folder] = true
+			}
+		}
+	}
+	if err := opts.guard.checkDeclared(totalSize); err != nil {
+		return err
+	}
+
+	folder := -1
+	var folderReader io.Reader
+	for i, file := range archive.files {
+		location := locations[i]
+		if file.hasStream && !neededFolders[location.folder] {
+			continue
+		}
+		if file.hasStream && location.folder != folder {
+			folder = location.folder
+			folderReader, err = archive.folderReader(folder)
+			if err != nil {
+				return err
+			}
+		}
+		if !selected[i] {
+			// Skip unselected files sharing a folder with selected ones
+			if file.hasStream {
+				if err := skip7zFile(folderReader, archive.substreams[location.substream]); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		entry := Entry{Name: cleanEntryName(file.name), Size: int64(file.size), Mode: file.mode, Modified: file.modTime}
+		if err := opts.guard.entry(entry.Name); err != nil {
+			return err
+		}
+		if !file.hasStream {
+			if err := sink.Put(entry, strings.NewReader("")); err != nil {
+				return err
+			}
+			continue
+		}
+
+		substream := archive.substreams[location.substream]
+		entry.Method, entry.Encrypted = archive.folders[location.folder].method()
+		entry.CRC32 = substream.crc
+		hash := crc32.NewIEEE()
+		data := io.TeeReader(io.LimitReader(folderReader, int64(substream.size)), hash)
+		var content io.Reader = opts.guard.reader(data, entry.Name)
+		if file.mode&os.ModeSymlink != 0 {
+			// Symlinks store their target as file content
+			target, err := io.ReadAll(content)
+			if err != nil {
+				return fmt.Errorf("failed to read from archive: %w", err)
+			}
+			entry.Link = string(target)
+			content = strings.NewReader("")
+		}
+		if err := sink.Put(entry, content); err != nil {
+			return err
+		}
+
+		// Read what the sink left of the file, the next one follows in the folder stream
+		if _, err := io.Copy(io.Discard, data); err != nil {
+			return fmt.Errorf("failed to read from archive: %w", err)
+		}
+		if substream.hasCRC && hash.Sum32() != substream.crc {
+			return fmt.Errorf("%s: %w", file.name, ErrChecksum)
+		}
+	}
+	return nil
+}
+
+// test7z decodes every folder of a 7z archive and verifies the size and checksum of each file.
+// The decoded data is counted against the size limits of guard, which may be nil.
+func test7z(sourcePath string, guard *extractGuard) (*TestResult, error) {
+	archive, err := open7z(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	locations, err := archive.locate()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TestResult{}
+	folder := -1
+	var folderReader io.Reader
+	var folderErr error
+	for i, file := range archive.files {
+		if !file.hasStream {
+			continue
+		}
+		result.Tested++
+
+		location := locations[i]
+		if location.folder != folder {
+			folder = location.folder
+			folderReader, folderErr = archive.folderReader(folder)
+		}
+		if folderErr != nil {
+			result.fail(file.name, folderErr)
+			continue
+		}
+
+		substream := archive.substreams[location.substream]
+		hash := crc32.NewIEEE()
+		data := guard.reader(io.TeeReader(io.LimitReader(folderReader, int64(substream.size)), hash), file.name)
+		err := checkEntryData(data, int64(substream.size))
+		if errors.Is(err, ErrLimitExceeded) {
+			return result, err
+		}
+		if err == nil && substream.hasCRC && hash.Sum32() != substream.crc {
+			err = ErrChecksum
+		}
+		if err != nil {
+			result.fail(file.name, err)
+		}
+	}
+	return result, nil
+}
+
+// skip7zFile reads past the data of one file of a folder stream
+func skip7zFile(folderReader io.Reader, substream sevenZipSubstream) error {
+	if _, err := io.CopyN(io.Discard, folderReader, int64(substream.size)); err != nil {
+		return fmt.Errorf("failed to read from archive: %w", err)
+	}
+	return nil
+}
+
+// extract7zFile writes one file of a folder stream and verifies its checksum
+func extract7zFile(opts ExtractOptions, file sevenZipFile, substream sevenZipSubstream, filePath, destPath string, folderReader io.Reader, progressTracker *ProgressTracker) error {
+	hash := crc32.NewIEEE()
+	data := io.TeeReader(io.LimitReader(folderReader, int64(substream.size)), hash)
+
+	if file.mode&os.ModeSymlink != 0 {
+		// Symlinks store their target as file content
+		target, err := io.ReadAll(opts.guard.reader(data, filePath))
+		if err != nil {
+			return fmt.Errorf("failed to read from archive: %w", err)
+		}
+		if err := opts.checkLinkTarget(destPath, filePath, string(target)); err != nil {
+			return err
+		}
+		if err := replaceWithLink(opts.guard, filePath, func(linkPath string) error { return os.Symlink(string(target), linkPath) }); err != nil {
+			return fmt.Errorf("failed to create symlink: %w", err)
+		}
+		progressTracker.AddProgress(int64(len(target)))
+	} else if err := writeExtractedFile(opts.guard, filePath, data, progressTracker); err != nil {
+		return err
+	}
+
+	if substream.hasCRC && hash.Sum32() != substream.crc {
+		return fmt.Errorf("%s: %w", file.name, ErrChecksum)
+	}
+	return opts.restoreMetadata(filePath, file.metadata())
+}
+
+// metadata returns the metadata stored for the file, 7z archives do not record owners
+func (f sevenZipFile) metadata() entryMetadata {
+	return entryMetadata{mode: f.mode, modTime: f.modTime, accessTime: f.accessTime}
+}
+
+// sevenZipParser reads the primitive types of a 7z header.
+// After the first error all reads return zero values and err is set.
+type sevenZipParser struct {
+	data []byte
+	err  error
+}
+
+func (p *sevenZipParser) fail() {
+	if p.err == nil {
+		p.err = errSevenZipHeader
+	}
+	p.data = nil
+}
+
+func (p *sevenZipParser) byte() byte {
+	if len(p.data) < 1 {
+		p.fail()
+		return 0
+	}
+	b := p.data[0]
+	p.data = p.data[1:]
+	return b
+}
+
+func (p *sevenZipParser) bytes(n int) []byte {
+	if n < 0 || len(p.data) < n {
+		p.fail()
+		return nil
+	}
+	b := p.data[:n]
+	p.data = p.data[n:]
+	return b
+}
+
+func (p *sevenZipParser) skip(n uint64) {
+	if n > uint64(len(p.data)) {
+		p.fail()
+		return
+	}
+	p.data = p.data[n:]
+}
+
+func (p *sevenZipParser) uint32() uint32 {
+	b := p.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (p *sevenZipParser) uint64() uint64 {
+	b := p.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+// number reads a 7z variable-length integer.
+// The count of leading one bits in the first byte gives the number of extra bytes.
+func (p *sevenZipParser) number() uint64 {
+	first := p.byte()
+	var value uint64
+	mask := byte(0x80)
+	for i := 0; i < 8; i++ {
+		if first&mask == 0 {
+			high := uint64(first & (mask - 1))
+			return value | high<<(8*i)
+		}
+		value |= uint64(p.byte()) << (8 * i)
+		mask >>= 1
+	}
+	return value
+}
+
+// count reads a number used as a count or index, bounded to keep allocations sane
+func (p *sevenZipParser) count() int {
+	n := p.number()
+	if n > 1<<24 {
+		p.fail()
+		return 0
+	}
+	return int(n)
+}
+
+// bits reads a bit vector of n entries, most significant bit first
+func (p *sevenZipParser) bits(n int) []bool {
+	v := make([]bool, n)
+	var b byte
+	for i := 0; i < n; i++ {
+		if i%8 == 0 {
+			b = p.byte()
+		}
+		v[i] = b&(0x80>>(i%8)) != 0
+	}
+	return v
+}
+
+// optionalBits reads an "all defined" flag followed by a bit vector when not all are defined
+func (p *sevenZipParser) optionalBits(n int) []bool {
+	if p.byte() == 0 {
+		return p.bits(n)
+	}
+	v := make([]bool, n)
+	for i := range v {
+		v[i] = true
+	}
+	return v
+}
+
+// digests reads a list of optional CRC32 values
+func (p *sevenZipParser) digests(n int) ([]bool, []uint32) {
+	defined := p.optionalBits(n)
+	crcs := make([]uint32, n)
+	for i := range crcs {
+		if defined[i] {
+			crcs[i] = p.uint32()
+		}
+	}
+	return defined, crcs
+}
+
+// utf16String reads a null-terminated UTF-16LE string
+func (p *sevenZipParser) utf16String() string {
+	var units []uint16
+	for p.err == nil {
+		b := p.bytes(2)
+		if b == nil {
+			break
+		}
+		u := binary.LittleEndian.Uint16(b)
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+	return string(utf16.Decode(units))
+}
+
+// countTrue returns the number of set entries in v
+func countTrue(v []bool) int {
+	n := 0
+	for _, b := range v {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// fileTimeToTime converts a Windows FILETIME (100ns intervals since 1601) to a time.Time
+func fileTimeToTime(ft uint64) time.Time {
+	const epochDelta = 116444736000000000 // 1601 to 1970 in 100ns intervals
+	if ft < epochDelta {
+		return time.Time{}
+	}
+	ft -= epochDelta
+	return time.Unix(int64(ft/1e7), int64(ft%1e7)*100)
+}
+
+// unixModeToFileMode converts a Unix st_mode value to an os.FileMode
+func unixModeToFileMode(mode uint32) os.FileMode {
+	fileMode := os.FileMode(mode & 0777)
+	switch mode & 0xF000 {
+	case 0x4000:
+		fileMode |= os.ModeDir
+	case 0xA000:
+		fileMode |= os.ModeSymlink
+	}
+	return fileMode
+}
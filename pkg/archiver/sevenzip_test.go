@@ -0,0 +1,163 @@
+package archiver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// The 7z fixtures of testdata hold the same files, each archive compressed
+// with one coder by libarchive
+var sevenZipFixture = map[string]string{
+	"hello.txt":      "hello 7z\n",
+	"docs/lorem.txt": strings.Repeat("lorem ipsum dolor sit amet ", 200) + "\n",
+	"empty.txt":      "",
+}
+
+// checkExtracted fails unless every file of want was extracted to dest with its content
+func checkExtracted(t *testing.T, dest string, want map[string]string) {
+	t.Helper()
+	for name, content := range want {
+		got, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil || string(got) != content {
+			t.Errorf("%s: content %.20q (%v), want %.20q", name, got, err, content)
+		}
+	}
+}
+
+func TestExtractSevenZipMethods(t *testing.T) {
+	for _, method := range []string{"store", "lzma", "lzma2", "deflate", "bzip2"} {
+		t.Run(method, func(t *testing.T) {
+			fixture := filepath.Join("testdata", method+".7z")
+
+			entries, err := List(fixture)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			for _, entry := range entries {
+				if entry.Mode.IsRegular() && entry.Size > 0 && entry.Method != method {
+					t.Errorf("%s: method %q, want %q", entry.Name, entry.Method, method)
+				}
+			}
+
+			result, err := Test(fixture, "")
+			if err != nil || !result.OK() {
+				t.Fatalf("Test: %v, failures %v", err, result)
+			}
+
+			dest := t.TempDir()
+			if err := ExtractWithProgress(fixture, dest, ExtractOptions{}, nil); err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			checkExtracted(t, dest, sevenZipFixture)
+			if info, err := os.Stat(filepath.Join(dest, "emptydir")); err != nil || !info.IsDir() {
+				t.Errorf("emptydir: %v, want a directory", err)
+			}
+		})
+	}
+}
+
+func TestExtractDotEntry(t *testing.T) {
+	// Archives made from "." start their names with "./" and hold "./" itself
+	tests := []struct {
+		fixture string
+		want    map[string]string
+	}{
+		{"dot.7z", sevenZipFixture},
+		{"dot.zip", map[string]string{"hello.txt": "hello zip\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			dest := t.TempDir()
+			if err := ExtractWithProgress(filepath.Join("testdata", tt.fixture), dest, ExtractOptions{}, nil); err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			checkExtracted(t, dest, tt.want)
+		})
+	}
+}
+
+// sevenZipNumber encodes n as a 7z variable-length integer of the longest form
+func sevenZipNumber(n uint64) []byte {
+	return binary.LittleEndian.AppendUint64([]byte{0xFF}, n)
+}
+
+// writeSevenZip writes a 7z archive of the packed data followed by the header
+func writeSevenZip(t *testing.T, packed, header []byte) string {
+	t.Helper()
+	signature := make([]byte, sevenZipSignatureHeaderLen)
+	copy(signature, sevenZipMagic)
+	signature[7] = 4
+	binary.LittleEndian.PutUint64(signature[12:], uint64(len(packed)))
+	binary.LittleEndian.PutUint64(signature[20:], uint64(len(header)))
+	binary.LittleEndian.PutUint32(signature[28:], crc32.ChecksumIEEE(header))
+	binary.LittleEndian.PutUint32(signature[8:], crc32.ChecksumIEEE(signature[12:32]))
+
+	path := filepath.Join(t.TempDir(), "crafted.7z")
+	if err := os.WriteFile(path, slices.Concat(signature, packed, header), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSevenZipHeaderBounds(t *testing.T) {
+	// An encoded header of zeros decoding beyond the maximum header size
+	var packed bytes.Buffer
+	writer, err := lzma.Writer2Config{DictCap: lzma.MinDictCap}.NewWriter2(&packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := int64(sevenZipMaxHeaderSize + 1<<20)
+	if _, err := io.CopyN(writer, zeroReader{}, decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	header := slices.Concat(
+		[]byte{sevenZipIDEncodedHeader, sevenZipIDPackInfo, 0, 1, sevenZipIDSize}, sevenZipNumber(uint64(packed.Len())),
+		[]byte{sevenZipIDEnd, sevenZipIDUnpackInfo, sevenZipIDFolder, 1, 0, 1, 0x21, 0x21, 1, 0, sevenZipIDCodersUnpackSize},
+		sevenZipNumber(uint64(decoded)),
+		[]byte{sevenZipIDEnd, sevenZipIDEnd},
+	)
+	bomb := writeSevenZip(t, packed.Bytes(), header)
+	if _, err := List(bomb); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("encoded header bomb: error %v, want %v", err, ErrLimitExceeded)
+	}
+
+	// A header said to reach past the end of the archive is refused before it is read
+	beyond := writeSevenZip(t, nil, []byte{sevenZipIDHeader, sevenZipIDEnd})
+	data, err := os.ReadFile(beyond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint64(data[20:], 1<<29)
+	binary.LittleEndian.PutUint32(data[8:], crc32.ChecksumIEEE(data[12:32]))
+	if err := os.WriteFile(beyond, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := List(beyond); !errors.Is(err, errSevenZipHeader) {
+		t.Errorf("header beyond the end: error %v, want %v", err, errSevenZipHeader)
+	}
+	err = ExtractReaderAt(bytes.NewReader(data), int64(len(data)), newMemorySink(), ExtractOptions{})
+	if !errors.Is(err, errSevenZipHeader) {
+		t.Errorf("header beyond the end from a reader: error %v, want %v", err, errSevenZipHeader)
+	}
+}
+
+// zeroReader reads an endless stream of zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,312 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ErrDuplicateEntry is returned when two sources would be stored under the same name
+var ErrDuplicateEntry = errors.New("duplicate entry name")
+
+// Source is a file or directory stored in an archive by CompressSources
+type Source struct {
+	// Path is the file or directory to compress. A symlink given as Path is followed.
+	Path string
+	// Prefix is the path of the source inside the archive, e.g. "etc/app.yaml" or "vendor/lib".
+	// Empty stores the source under its base name and "." stores the contents of a directory
+	// at the root of the archive.
+	Prefix string
+}
+
+// name returns the slash separated name of the source in the archive, "" for the root
+func (s Source) name(isDir bool) string {
+	name := cleanEntryName(s.Prefix)
+	if s.Prefix == "" || (name == "" && !isDir) {
+		return filepath.Base(s.Path)
+	}
+	return name
+}
+
+// singleSource returns the path of the only source, for formats that compress one file
+func singleSource(f Format, sources []Source) (string, error) {
+	if len(sources) != 1 {
+		return "", fmt.Errorf("%s compression of %d sources: %w", f.Name(), len(sources), ErrUnsupported)
+	}
+	return sources[0].Path, nil
+}
+
+// sourceEntry is a file, directory or symlink about to be stored in an archive
+type sourceEntry struct {
+	// name is the slash separated name of the entry in the archive
+	name string
+	info os.FileInfo
+	// path names the entry in error messages
+	path string
+	// link is the target of a symlink
+	link string
+	// open returns the content of files other than directories and symlinks
+	open func() (io.ReadCloser, error)
+}
+
+// entryWalker calls fn for every entry to store, in the order of the archive
+type entryWalker func(fn func(entry sourceEntry) error) error
+
+// sourcesWalker returns the walker over the entries of sources selected by opts
+func sourcesWalker(sources []Source, opts CompressOptions) entryWalker {
+	return func(fn func(entry sourceEntry) error) error {
+		return walkSources(sources, opts, fn)
+	}
+}
+
+// entryNames tracks the names stored by a walk to detect duplicates
+type entryNames struct {
+	// files maps the names of everything but directories to their path,
+	// dirs holds the directory names and whether they were stored
+	files map[string]string
+	dirs  map[string]bool
+}
+
+func newEntryNames() *entryNames {
+	return &entryNames{files: make(map[string]string), dirs: make(map[string]bool)}
+}
+
+// add records the name of entry. It returns false for directories already stored, and
+// ErrDuplicateEntry if the name is taken by another file, or by a directory when it is a file.
+func (n *entryNames) add(entry sourceEntry) (bool, error) {
+	for parent := path.Dir(entry.name); parent != "."; parent = path.Dir(parent) {
+		if other, ok := n.files[parent]; ok {
+			return false, fmt.Errorf("%s is a file in the archive, %s cannot be stored below it: %w", other, entry.path, ErrDuplicateEntry)
+		}
+		if _, ok := n.dirs[parent]; !ok {
+			n.dirs[parent] = false
+		}
+	}
+
+	if other, ok := n.files[entry.name]; ok {
+		return false, fmt.Errorf("%s and %s are both stored as %s: %w", other, entry.path, entry.name, ErrDuplicateEntry)
+	}
+	if !entry.info.IsDir() {
+		if _, ok := n.dirs[entry.name]; ok {
+			return false, fmt.Errorf("%s is stored as %s, which is a directory in the archive: %w", entry.path, entry.name, ErrDuplicateEntry)
+		}
+		n.files[entry.name] = entry.path
+		return true, nil
+	}
+	if n.dirs[entry.name] {
+		return false, nil
+	}
+	n.dirs[entry.name] = true
+	return true, nil
+}
+
+// walkSources calls fn for every entry of sources that opts selects. Directories shared
+// by several sources are reported once. An entry whose name is already taken by another
+// file, or by a directory when it is a file, returns ErrDuplicateEntry before fn is called for it.
+// Once the context of opts is canceled, the walk stops and the content of files stops reading.
+func walkSources(sources []Source, opts CompressOptions, fn func(entry sourceEntry) error) error {
+	if len(sources) == 0 {
+		return errors.New("no source to compress")
+	}
+
+	names := newEntryNames()
+	add := func(entry sourceEntry) error {
+		if err := contextErr(opts.ctx); err != nil {
+			return err
+		}
+		if ok, err := names.add(entry); !ok || err != nil {
+			return err
+		}
+		if open := entry.open; open != nil {
+			entry.open = func() (io.ReadCloser, error) {
+				file, err := open()
+				if err != nil {
+					return nil, err
+				}
+				return readCloser{newContextReader(opts.ctx, file), file}, nil
+			}
+		}
+		return fn(entry)
+	}
+
+	for _, source := range sources {
+		// Follow a symlink given as source, like a single file or directory was compressed before
+		info, err := os.Stat(source.Path)
+		if err != nil {
+			return fmt.Errorf("source path error: %w", err)
+		}
+		name := source.name(info.IsDir())
+		if !info.IsDir() {
+			sourcePath := source.Path
+			entry := sourceEntry{name: name, info: info, path: sourcePath, open: func() (io.ReadCloser, error) {
+				return os.Open(sourcePath)
+			}}
+			if err := add(entry); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// The directory itself is an entry unless its contents go to the root
+		if name != "" {
+			if err := add(sourceEntry{name: name, info: info, path: source.Path}); err != nil {
+				return err
+			}
+		}
+		// The walk does not enter a symlink given as source
+		root, err := filepath.EvalSymlinks(source.Path)
+		if err != nil {
+			return fmt.Errorf("source path error: %w", err)
+		}
+		err = walkFS(localFS{FS: os.DirFS(root), root: root}, opts, func(entry sourceEntry) error {
+			entry.path = filepath.Join(source.Path, filepath.FromSlash(entry.name))
+			entry.name = path.Join(name, entry.name)
+			return add(entry)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLinkFS is implemented by file systems able to read symlinks, like the fs.ReadLinkFS
+// of newer Go versions
+type readLinkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+// localFS is a directory of the local file system that reads its symlinks
+type localFS struct {
+	fs.FS
+	root string
+}
+
+func (l localFS) ReadLink(name string) (string, error) {
+	return os.Readlink(filepath.Join(l.root, filepath.FromSlash(name)))
+}
+
+// walkFS calls fn for every file, directory and symlink of fsys that opts selects, named
+// by their path in fsys. Excluded and ignored directories are not entered. Symlinks are
+// stored if fsys can read them, otherwise links to regular files are followed and the others skipped.
+func walkFS(fsys fs.FS, opts CompressOptions, fn func(entry sourceEntry) error) error {
+	links, canReadLinks := fsys.(readLinkFS)
+	return walkSource(fsys, opts, func(name string, info os.FileInfo) error {
+		entry := sourceEntry{name: name, info: info, path: name}
+		switch {
+		case info.IsDir():
+		case info.Mode()&os.ModeSymlink != 0 && canReadLinks:
+			target, err := links.ReadLink(name)
+			if err != nil {
+				return fmt.Errorf("failed to read symlink %s: %w", name, err)
+			}
+			entry.link = target
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := fs.Stat(fsys, name)
+			if err != nil || !target.Mode().IsRegular() {
+				return nil
+			}
+			entry.info = target
+			fallthrough
+		default:
+			entry.open = func() (io.ReadCloser, error) {
+				return fsys.Open(name)
+			}
+		}
+		return fn(entry)
+	})
+}
+
+// walkSource calls fn for every file, directory and symlink of fsys that opts selects, without
+// following symlinks. Excluded and ignored directories are not entered.
+func walkSource(fsys fs.FS, opts CompressOptions, fn func(name string, info os.FileInfo) error) error {
+	var rules ignoreRules
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			// The root is not an entry, but its ignore files apply to everything
+			if opts.UseIgnoreFiles {
+				return rules.load(fsys, "")
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if opts.excludes(name, info, rules) {
+			if info.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() && opts.UseIgnoreFiles {
+			if err := rules.load(fsys, name); err != nil {
+				return err
+			}
+		}
+		if !opts.includes(name, info) {
+			return nil
+		}
+		return fn(name, info)
+	})
+}
+
+// excludes reports whether the entry name is left out, with everything below it for directories
+func (o CompressOptions) excludes(name string, info os.FileInfo, rules ignoreRules) bool {
+	if o.UseIgnoreFiles {
+		if info.IsDir() && path.Base(name) == ".git" {
+			return true
+		}
+		if rules.ignored(name, info.IsDir()) {
+			return true
+		}
+	}
+	for _, pattern := range o.Exclude {
+		if matchEntry(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// includes reports whether an entry that is not excluded is stored.
+// The size and age filters only apply to regular files.
+func (o CompressOptions) includes(name string, info os.FileInfo) bool {
+	if len(o.Include) > 0 {
+		included := false
+		for _, pattern := range o.Include {
+			if matchEntry(pattern, name) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+
+	if !info.Mode().IsRegular() {
+		return true
+	}
+	if o.MinSize > 0 && info.Size() < o.MinSize {
+		return false
+	}
+	if o.MaxSize > 0 && info.Size() > o.MaxSize {
+		return false
+	}
+	if !o.ModifiedAfter.IsZero() && !info.ModTime().After(o.ModifiedAfter) {
+		return false
+	}
+	if !o.ModifiedBefore.IsZero() && !info.ModTime().Before(o.ModifiedBefore) {
+		return false
+	}
+	return true
+}
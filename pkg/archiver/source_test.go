@@ -0,0 +1,271 @@
+package archiver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// filterTree maps the files of the tree compressed by the filter tests to their content
+var filterTree = map[string]string{
+	".gitignore":          "# build output\n*.tmp\n*.log\n!important.log\nbuild/\n/main.go\n",
+	"main.go":             "package main\n",
+	"app.log":             "log\n",
+	"important.log":       "keep\n",
+	"build/out.bin":       "binary\n",
+	"docs/guide.md":       strings.Repeat("guide ", 20),
+	"docs/draft.tmp":      "draft\n",
+	"src/.compressignore": "cache/\n/local.go\n",
+	"src/main.go":         "package src\n",
+	"src/local.go":        "package src\n",
+	"src/cache":           "a file, not a directory\n",
+	"src/sub/local.go":    "package sub\n",
+	"src/sub/cache/x.go":  "package cache\n",
+	".git/config":         "[core]\n",
+}
+
+// compressedFiles compresses the filter tree with opts and returns the names of the stored files
+func compressedFiles(t *testing.T, opts CompressOptions) []string {
+	t.Helper()
+	source := t.TempDir()
+	writeFiles(t, source, filterTree)
+
+	archive := filepath.Join(t.TempDir(), "filtered.tar")
+	if err := CompressWithProgress(source, archive, "tar", opts, nil); err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	entries, err := List(archive)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.Mode.IsRegular() {
+			names = append(names, entry.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestCompressFilters(t *testing.T) {
+	tests := []struct {
+		name string
+		opts CompressOptions
+		want []string
+	}{
+		{
+			name: "include",
+			opts: CompressOptions{Include: []string{"*.go"}},
+			want: []string{"main.go", "src/local.go", "src/main.go", "src/sub/cache/x.go", "src/sub/local.go"},
+		},
+		{
+			name: "exclude directory",
+			opts: CompressOptions{Exclude: []string{"src", ".git", "build"}},
+			want: []string{".gitignore", "app.log", "docs/draft.tmp", "docs/guide.md", "important.log", "main.go"},
+		},
+		{
+			name: "exclude wins",
+			opts: CompressOptions{Include: []string{"src/**"}, Exclude: []string{"sub", ".*"}},
+			want: []string{"src/cache", "src/local.go", "src/main.go"},
+		},
+		{
+			name: "size",
+			opts: CompressOptions{MinSize: 100},
+			want: []string{"docs/guide.md"},
+		},
+		{
+			name: "ignore files",
+			opts: CompressOptions{UseIgnoreFiles: true},
+			want: []string{
+				".gitignore", "docs/guide.md", "important.log",
+				"src/.compressignore", "src/cache", "src/main.go", "src/sub/local.go",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compressedFiles(t, tt.opts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stored %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	var rules ignoreRules
+	for _, line := range strings.Split(filterTree[".gitignore"], "\n") {
+		if rule, ok := parseIgnoreRule(line, ""); ok {
+			rules = append(rules, rule)
+		}
+	}
+	for _, line := range []string{"cache/", "/local.go", `\#notes`} {
+		if rule, ok := parseIgnoreRule(line, "src"); ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		isDir   bool
+		ignored bool
+	}{
+		{"docs/draft.tmp", false, true},
+		{"logs/app.log", false, true},
+		{"logs/important.log", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"main.go", false, true},
+		{"src/main.go", false, false},
+		{"src/local.go", false, true},
+		{"src/sub/local.go", false, false},
+		{"src/sub/cache", true, true},
+		{"src/cache", false, false},
+		{"cache", true, false},
+		{"src/#notes", false, true},
+	}
+	for _, tt := range tests {
+		if got := rules.ignored(tt.name, tt.isDir); got != tt.ignored {
+			t.Errorf("ignored(%s, dir %t) = %t, want %t", tt.name, tt.isDir, got, tt.ignored)
+		}
+	}
+}
+
+func TestReadPatterns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "exclude.txt")
+	if err := os.WriteFile(path, []byte("# editors\n*.swp\n\n  .idea  \nnode_modules/**\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	patterns, err := ReadPatterns(path)
+	if want := []string{"*.swp", ".idea", "node_modules/**"}; err != nil || !reflect.DeepEqual(patterns, want) {
+		t.Errorf("patterns %q (%v), want %q", patterns, err, want)
+	}
+
+	if err := os.WriteFile(path, []byte("[unclosed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadPatterns(path); err == nil {
+		t.Error("malformed pattern read without error")
+	}
+}
+
+// writeFiles creates the files below root with their content
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompressSources(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"lib/x.go":       "package lib\n",
+		"lib/y/z.go":     "package y\n",
+		"config.yaml":    "debug: true\n",
+		"notes.txt":      "notes\n",
+		"site/index.htm": "<html>\n",
+		"more/vendor/a":  "a\n",
+	})
+	sources := []Source{
+		{Path: filepath.Join(root, "lib")},
+		{Path: filepath.Join(root, "lib"), Prefix: "vendor/lib"},
+		{Path: filepath.Join(root, "config.yaml"), Prefix: "etc/app.yaml"},
+		{Path: filepath.Join(root, "notes.txt"), Prefix: "."},
+		{Path: filepath.Join(root, "site"), Prefix: "."},
+		{Path: filepath.Join(root, "more"), Prefix: "./"},
+	}
+	want := map[string]string{
+		"lib/x.go":          "package lib\n",
+		"lib/y/z.go":        "package y\n",
+		"vendor/lib/x.go":   "package lib\n",
+		"vendor/lib/y/z.go": "package y\n",
+		"etc/app.yaml":      "debug: true\n",
+		"notes.txt":         "notes\n",
+		"index.htm":         "<html>\n",
+		"vendor/a":          "a\n",
+	}
+
+	for _, format := range []string{"zip", "tar"} {
+		t.Run(format, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "sources."+format)
+			if err := CompressSourcesWithProgress(sources, archive, format, CompressOptions{Workers: 2}, nil); err != nil {
+				t.Fatalf("CompressSources: %v", err)
+			}
+
+			// Directories shared by several sources, like vendor, are stored once
+			entries, err := List(archive)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			seen := make(map[string]bool)
+			for _, entry := range entries {
+				if seen[entry.Name] {
+					t.Errorf("%s stored twice", entry.Name)
+				}
+				seen[entry.Name] = true
+			}
+
+			dest := t.TempDir()
+			if err := ExtractWithProgress(archive, dest, ExtractOptions{}, nil); err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			checkExtracted(t, dest, want)
+			if got := extractedFiles(t, dest); len(got) != len(want) {
+				t.Errorf("extracted %v, want %d files", got, len(want))
+			}
+		})
+	}
+}
+
+func TestCompressSourcesDuplicate(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"one/a.txt":     "one\n",
+		"two/a.txt":     "two\n",
+		"dir/inner.txt": "inner\n",
+	})
+	tests := []struct {
+		name    string
+		sources []Source
+	}{
+		{"same base name", []Source{{Path: filepath.Join(root, "one", "a.txt")}, {Path: filepath.Join(root, "two", "a.txt")}}},
+		{"same prefix", []Source{
+			{Path: filepath.Join(root, "one", "a.txt"), Prefix: "x.txt"},
+			{Path: filepath.Join(root, "dir", "inner.txt"), Prefix: "./x.txt"},
+		}},
+		{"merged directories", []Source{{Path: filepath.Join(root, "one"), Prefix: "."}, {Path: filepath.Join(root, "two"), Prefix: "."}}},
+		{"file and directory", []Source{
+			{Path: filepath.Join(root, "one", "a.txt"), Prefix: "dir"},
+			{Path: filepath.Join(root, "dir")},
+		}},
+		{"below a file", []Source{
+			{Path: filepath.Join(root, "one", "a.txt"), Prefix: "x"},
+			{Path: filepath.Join(root, "two", "a.txt"), Prefix: "x/a.txt"},
+		}},
+	}
+	for _, tt := range tests {
+		for _, format := range []string{"zip", "tar.gz"} {
+			t.Run(tt.name+"/"+format, func(t *testing.T) {
+				archive := filepath.Join(t.TempDir(), "duplicate."+format)
+				err := CompressSourcesWithProgress(tt.sources, archive, format, CompressOptions{Workers: 2}, nil)
+				if !errors.Is(err, ErrDuplicateEntry) {
+					t.Fatalf("error %v, want %v", err, ErrDuplicateEntry)
+				}
+				if _, err := os.Lstat(archive); !os.IsNotExist(err) {
+					t.Errorf("partial archive left behind: %v", err)
+				}
+			})
+		}
+	}
+}
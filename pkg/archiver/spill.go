@@ -0,0 +1,86 @@
+package archiver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// spillThreshold is the amount of data a spillBuffer keeps in memory
+const spillThreshold = 8 * 1024 * 1024 // 8MB
+
+// spillBuffer collects data in memory and moves it to a temporary file
+// once it grows beyond its limit. Close removes the temporary file.
+type spillBuffer struct {
+	limit int
+	mem   bytes.Buffer
+	file  *os.File
+	size  int64
+}
+
+func newSpillBuffer(limit int) *spillBuffer {
+	return &spillBuffer{limit: limit}
+}
+
+func (sb *spillBuffer) Write(p []byte) (int, error) {
+	if sb.file == nil && sb.mem.Len()+len(p) > sb.limit {
+		file, err := os.CreateTemp("", "file-compressor-*.spill")
+		if err != nil {
+			return 0, fmt.Errorf("failed to create spill file: %w", err)
+		}
+		sb.file = file
+		if _, err := sb.mem.WriteTo(file); err != nil {
+			return 0, fmt.Errorf("failed to write spill file: %w", err)
+		}
+	}
+
+	var n int
+	var err error
+	if sb.file != nil {
+		n, err = sb.file.Write(p)
+	} else {
+		n, err = sb.mem.Write(p)
+	}
+	sb.size += int64(n)
+	return n, err
+}
+
+// Size returns the number of bytes written
+func (sb *spillBuffer) Size() int64 {
+	return sb.size
+}
+
+// WriteTo copies the collected data to w
+func (sb *spillBuffer) WriteTo(w io.Writer) (int64, error) {
+	if sb.file == nil {
+		return sb.mem.WriteTo(w)
+	}
+	if _, err := sb.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, sb.file)
+}
+
+// ReadAt reads the collected data at offset off
+func (sb *spillBuffer) ReadAt(p []byte, off int64) (int, error) {
+	if sb.file != nil {
+		return sb.file.ReadAt(p, off)
+	}
+	return bytes.NewReader(sb.mem.Bytes()).ReadAt(p, off)
+}
+
+// Close releases the memory and removes the spill file, if any
+func (sb *spillBuffer) Close() error {
+	sb.mem = bytes.Buffer{}
+	if sb.file == nil {
+		return nil
+	}
+	name := sb.file.Name()
+	err := sb.file.Close()
+	if removeErr := os.Remove(name); err == nil {
+		err = removeErr
+	}
+	sb.file = nil
+	return err
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package archiver
+
+import "os"
+
+// fileKey identifies a file independently of its names
+type fileKey struct{}
+
+// linkedFileKey reports no hard links, their identity is not available on this platform
+func linkedFileKey(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
+
+// fileKeyOf reports no identity, it is not available on this platform
+func fileKeyOf(info os.FileInfo) (fileKey, bool) {
+	return fileKey{}, false
+}
+
+// fileOwner reports no owner, numeric owners do not exist on this platform
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
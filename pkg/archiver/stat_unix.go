@@ -0,0 +1,40 @@
+//go:build unix
+
+package archiver
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileKey identifies a file independently of its names
+type fileKey struct {
+	dev, ino uint64
+}
+
+// linkedFileKey returns the identity of the file described by info if it has more than one name
+func linkedFileKey(info os.FileInfo) (fileKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
+
+// fileKeyOf returns the identity of the file described by info
+func fileKeyOf(info os.FileInfo) (fileKey, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileKey{}, false
+	}
+	return fileKey{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}, true
+}
+
+// fileOwner returns the numeric user and group owning the file described by info
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
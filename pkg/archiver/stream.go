@@ -0,0 +1,306 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// streamFormat compresses a single file with a codec, e.g. "report.txt" to "report.txt.xz"
+type streamFormat struct {
+	codec *codec
+}
+
+func (f streamFormat) Name() string         { return f.codec.name }
+func (f streamFormat) Extensions() []string { return f.codec.extensions }
+
+func (f streamFormat) Capabilities() Capability {
+	if f.codec.newWriter == nil {
+		return CanExtract | CanList
+	}
+	return CanCompress | CanExtract | CanList
+}
+
+func (f streamFormat) Detect(header []byte) bool {
+	if !f.codec.detect(header) {
+		return false
+	}
+	// Leave compressed tarballs to the matching tar format
+	isTar, known := peekTar(f.codec, header)
+	return !isTar || !known
+}
+
+func (f streamFormat) Compress(sources []Source, destPath string, opts CompressOptions, progressTracker *ProgressTracker) error {
+	if f.Capabilities()&CanCompress == 0 {
+		return fmt.Errorf("%s compression: %w", f.Name(), ErrUnsupported)
+	}
+	sourcePath, err := singleSource(f, sources)
+	if err != nil {
+		return err
+	}
+	return compressStream(sourcePath, destPath, f.codec, opts, progressTracker)
+}
+
+func (f streamFormat) Extract(sourcePath, destPath string, opts ExtractOptions, progressTracker *ProgressTracker) error {
+	return extractStream(sourcePath, destPath, f.codec, opts, progressTracker)
+}
+
+func (f streamFormat) Test(sourcePath, password string) (*TestResult, error) {
+	return f.testLimited(sourcePath, password, nil)
+}
+
+func (f streamFormat) testLimited(sourcePath, password string, guard *extractGuard) (*TestResult, error) {
+	name := streamEntryName(sourcePath, f.codec)
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s file: %w", f.codec.name, err)
+	}
+	defer file.Close()
+
+	reader, err := f.codec.newReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s stream: %w", f.codec.name, err)
+	}
+	defer reader.Close()
+
+	// The codec verifies the checksum of the stream, if it has one
+	result := &TestResult{Tested: 1}
+	if _, err := io.Copy(io.Discard, guard.reader(reader, name)); err != nil {
+		if errors.Is(err, ErrLimitExceeded) {
+			return result, err
+		}
+		result.fail(name, err)
+	}
+	return result, nil
+}
+
+func (f streamFormat) List(sourcePath string) ([]Entry, error) {
+	return f.listLimited(sourcePath, nil)
+}
+
+func (f streamFormat) listLimited(sourcePath string, guard *extractGuard) ([]Entry, error) {
+	name := streamEntryName(sourcePath, f.codec)
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s file: %w", f.codec.name, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	// The uncompressed size is only known after decompressing the stream
+	reader, err := f.codec.newReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s stream: %w", f.codec.name, err)
+	}
+	defer reader.Close()
+
+	size, err := io.Copy(io.Discard, guard.reader(reader, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s stream: %w", f.codec.name, err)
+	}
+
+	return []Entry{{
+		Name:           name,
+		Size:           size,
+		CompressedSize: info.Size(),
+		Method:         f.codec.name,
+		Mode:           0644,
+		Modified:       info.ModTime(),
+	}}, nil
+}
+
+func (f streamFormat) extractReader(r io.Reader, sink Sink, opts ExtractOptions) error {
+	return extractStreamTo(r, f.codec, sink, opts)
+}
+
+// streamEntryName returns the name of the file stored in a compressed stream,
+// which is the archive name without the codec extension
+func streamEntryName(sourcePath string, c *codec) string {
+	base := filepath.Base(sourcePath)
+	lower := strings.ToLower(base)
+	for _, ext := range c.extensions {
+		if strings.HasSuffix(lower, ext) && len(base) > len(ext) {
+			return base[:len(base)-len(ext)]
+		}
+	}
+	return base + ".out"
+}
+
+// compressStream compresses the single file at sourcePath into destPath using c
+func compressStream(sourcePath, destPath string, c *codec, opts CompressOptions, progressTracker *ProgressTracker) error {
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return fmt.Errorf("source path error: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s compression requires a single file, use tar.%s for directories", c.name, c.name)
+	}
+
+	srcFile, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer srcFile.Close()
+
+	return compressToFile(destPath, func(dest io.Writer) error {
+		return writeStream(dest, info.Name(), newContextReader(opts.ctx, srcFile), c, opts, progressTracker)
+	})
+}
+
+// compressTo compresses the only file of walk to w, directories are passed over
+func (f streamFormat) compressTo(w io.Writer, walk entryWalker, opts CompressOptions, progressTracker *ProgressTracker) error {
+	c := f.codec
+	var file *sourceEntry
+	err := walk(func(entry sourceEntry) error {
+		if entry.info.IsDir() {
+			return nil
+		}
+		if file != nil || entry.open == nil {
+			return fmt.Errorf("%s compression requires a single file, use tar.%s for several", c.name, c.name)
+		}
+		file = &entry
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		return fmt.Errorf("%s compression requires a single file", c.name)
+	}
+
+	content, err := file.open()
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %w", err)
+	}
+	defer content.Close()
+	return writeStream(w, file.name, content, c, opts, progressTracker)
+}
+
+// writeStream compresses r, the content of the file name, to w using c
+func writeStream(w io.Writer, name string, r io.Reader, c *codec, opts CompressOptions, progressTracker *ProgressTracker) error {
+	progressTracker.StartFile(name)
+	counter := &countingWriter{w: w}
+	writer, err := c.newWriter(counter, opts.Level)
+	if err != nil {
+		return fmt.Errorf("failed to create %s writer: %w", c.name, err)
+	}
+
+	// Use a larger buffer for better throughput
+	buffer := make([]byte, opts.bufferSize())
+
+	size, err := io.CopyBuffer(writer, NewProgressReader(r, progressTracker), buffer)
+	if err != nil {
+		return fmt.Errorf("failed to compress file: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to finish %s stream: %w", c.name, err)
+	}
+	progressTracker.FinishFile(name, size, counter.n)
+
+	// Mark progress as complete
+	progressTracker.SetComplete()
+
+	return nil
+}
+
+// extractStream decompresses the single file stored at sourcePath into the destPath directory
+func extractStream(sourcePath, destPath string, c *codec, opts ExtractOptions, progressTracker *ProgressTracker) error {
+	name := streamEntryName(sourcePath, c)
+	if !opts.selects(name) {
+		// Nothing to extract
+		progressTracker.SetComplete()
+		return nil
+	}
+	filePath, err := opts.entryPath(destPath, name)
+	if err != nil {
+		return err
+	}
+	if err := opts.guard.entry(name); err != nil {
+		return err
+	}
+
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s file: %w", c.name, err)
+	}
+	defer file.Close()
+
+	// Progress is reported in bytes of the compressed file
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+	progressTracker.SetTotalSize(info.Size())
+	progressTracker.SetTotalFiles(1)
+	progressTracker.StartFile(name)
+
+	// The compressed file's time stands in for the time of its content
+	filePath, err = opts.resolveConflict(name, filePath, info.ModTime())
+	if err != nil {
+		return err
+	}
+	if filePath == "" {
+		progressTracker.SetComplete()
+		return nil
+	}
+
+	reader, err := c.newReader(NewProgressReader(file, progressTracker))
+	if err != nil {
+		return fmt.Errorf("failed to open %s stream: %w", c.name, err)
+	}
+	defer reader.Close()
+
+	if err := writeExtractedFile(opts.guard, filePath, reader, nil); err != nil {
+		return err
+	}
+	// The stream records no metadata of its content
+	if err := opts.restoreMetadata(filePath, entryMetadata{mode: 0644}); err != nil {
+		return err
+	}
+	if extracted, err := os.Stat(filePath); err == nil {
+		progressTracker.FinishFile(name, extracted.Size(), info.Size())
+	}
+
+	// Mark progress as complete
+	progressTracker.SetComplete()
+
+	return nil
+}
+
+// streamSinkEntry names the content of a compressed stream passed to a Sink,
+// the file name is only known from the name of the stream
+const streamSinkEntry = "data"
+
+// extractStreamTo decompresses the stream read from r with c and passes its content to sink
+func extractStreamTo(r io.Reader, c *codec, sink Sink, opts ExtractOptions) error {
+	if !opts.selects(streamSinkEntry) {
+		return nil
+	}
+	if err := opts.guard.entry(streamSinkEntry); err != nil {
+		return err
+	}
+
+	reader, err := c.newReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to open %s stream: %w", c.name, err)
+	}
+	defer reader.Close()
+
+	// The uncompressed size is only known after decompressing the stream
+	entry := Entry{Name: streamSinkEntry, Size: -1, Method: c.name, Mode: 0644}
+	if err := sink.Put(entry, opts.guard.reader(reader, streamSinkEntry)); err != nil {
+		return err
+	}
+	// Read the rest of the stream so the codec verifies its checksum
+	if _, err := io.Copy(io.Discard, opts.guard.reader(reader, streamSinkEntry)); err != nil {
+		return fmt.Errorf("failed to read %s stream: %w", c.name, err)
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package archiver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+)
+
+// streamCompressor is implemented by formats able to write their output to an io.Writer
+type streamCompressor interface {
+	// compressTo writes the entries of walk to w. progressTracker may be nil.
+	compressTo(w io.Writer, walk entryWalker, opts CompressOptions, progressTracker *ProgressTracker) error
+}
+
+func (zipFormat) compressTo(w io.Writer, walk entryWalker, opts CompressOptions, progressTracker *ProgressTracker) error {
+	return writeZip(w, walk, opts, progressTracker)
+}
+
+func (f tarFormat) compressTo(w io.Writer, walk entryWalker, opts CompressOptions, progressTracker *ProgressTracker) error {
+	if f.Capabilities()&CanCompress == 0 {
+		return fmt.Errorf("%s compression: %w", f.Name(), ErrUnsupported)
+	}
+	return writeTar(w, walk, f.codec, opts, progressTracker)
+}
+
+// compressToFile creates the file destPath and passes it to write
+func compressToFile(destPath string, write func(dest io.Writer) error) error {
+	// Create the destination file
+	dest, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("failed to create destination file: %w", err)
+	}
+	defer dest.Close()
+
+	if err := write(dest); err != nil {
+		return err
+	}
+	if err := dest.Close(); err != nil {
+		return fmt.Errorf("failed to close destination file: %w", err)
+	}
+	return nil
+}
+
+// streamingFormat looks up a registered format able to compress to an io.Writer with opts
+func streamingFormat(name string, opts CompressOptions) (streamCompressor, error) {
+	f, err := compressionFormat(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := f.(streamCompressor)
+	if !ok {
+		return nil, fmt.Errorf("%s compression to a writer: %w", f.Name(), ErrUnsupported)
+	}
+	return c, nil
+}
+
+// CompressFS writes the files, directories and symlinks of fsys selected by opts as an
+// archive of the given format to w. Symlinks are stored if fsys implements
+// ReadLink(name string) (string, error), otherwise links to regular files are followed.
+// PDF and image formats, which only work on files, return ErrUnsupported.
+func CompressFS(fsys fs.FS, w io.Writer, format string, opts CompressOptions) error {
+	c, err := streamingFormat(format, opts)
+	if err != nil {
+		return err
+	}
+	walk := func(fn func(entry sourceEntry) error) error {
+		return walkFS(fsys, opts, fn)
+	}
+	return c.compressTo(w, walk, opts, nil)
+}
+
+// ReaderSource is a file or directory stored by CompressReaders
+type ReaderSource struct {
+	// Name is the slash separated path of the entry in the archive
+	Name string
+	// Reader holds the content of a file, it is read once and not closed
+	Reader io.Reader
+	// Size is the length of the content, zero if it is not known. Formats storing
+	// the size before the content, like tar, buffer content of unknown size.
+	Size int64
+	// Mode holds the permissions, and os.ModeDir for directories. Zero stores a
+	// file readable by everyone.
+	Mode os.FileMode
+	// Link makes the entry a symlink pointing to Link
+	Link string
+	// Modified is the modification time, zero stores the current time
+	Modified time.Time
+}
+
+// readerInfo describes a ReaderSource like a file of the file system
+type readerInfo struct {
+	source  ReaderSource
+	modTime time.Time
+}
+
+func (i readerInfo) Name() string       { return path.Base(i.source.Name) }
+func (i readerInfo) ModTime() time.Time { return i.modTime }
+func (i readerInfo) IsDir() bool        { return i.source.Mode.IsDir() }
+func (i readerInfo) Sys() any           { return nil }
+
+func (i readerInfo) Size() int64 {
+	if i.source.Size <= 0 && i.Mode().IsRegular() {
+		// Unknown sizes are measured while the content is written
+		return -1
+	}
+	return i.source.Size
+}
+
+func (i readerInfo) Mode() os.FileMode {
+	switch {
+	case i.source.Link != "":
+		return os.ModeSymlink | 0777
+	case i.source.Mode == 0:
+		return 0644
+	}
+	return i.source.Mode
+}
+
+// CompressReaders writes files as an archive of the given format to w, for content
+// that is not on disk such as uploads or objects of a remote store. The include,
+// exclude and filter options of opts apply to the names of the files. Names used twice
+// return ErrDuplicateEntry. Formats without CanBundle take a single file.
+func CompressReaders(files []ReaderSource, w io.Writer, format string, opts CompressOptions) error {
+	c, err := streamingFormat(format, opts)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no source to compress")
+	}
+
+	now := time.Now()
+	walk := func(fn func(entry sourceEntry) error) error {
+		names := newEntryNames()
+		for _, file := range files {
+			name := cleanEntryName(file.Name)
+			if name == "" {
+				return fmt.Errorf("invalid entry name: %q", file.Name)
+			}
+			info := readerInfo{source: file, modTime: file.Modified}
+			if info.modTime.IsZero() {
+				info.modTime = now
+			}
+			if opts.excludes(name, info, nil) || !opts.includes(name, info) {
+				continue
+			}
+
+			entry := sourceEntry{name: name, info: info, path: file.Name, link: file.Link}
+			if info.Mode().IsRegular() {
+				if file.Reader == nil {
+					return fmt.Errorf("no content for %s", file.Name)
+				}
+				content := file.Reader
+				entry.open = func() (io.ReadCloser, error) {
+					return io.NopCloser(content), nil
+				}
+			}
+			if ok, err := names.add(entry); !ok || err != nil {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return c.compressTo(w, walk, opts, nil)
+}
+
+// Sink receives the entries of an archive extracted by ExtractReader or ExtractReaderAt
+type Sink interface {
+	// Put stores entry. Names are cleaned slash separated paths that stay below the root.
+	// content holds the data of regular files and is only valid until Put returns.
+	// Directories and links have no content, the target of a link is entry.Link.
+	Put(entry Entry, content io.Reader) error
+}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc func(entry Entry, content io.Reader) error
+
+func (f SinkFunc) Put(entry Entry, content io.Reader) error {
+	return f(entry, content)
+}
+
+// readerAtExtractor is implemented by formats that need random access to extract,
+// such as ZIP with its central directory at the end
+type readerAtExtractor interface {
+	extractReaderAt(r io.ReaderAt, size int64, sink Sink, opts ExtractOptions) error
+}
+
+// readerExtractor is implemented by formats extracted in a single pass
+type readerExtractor interface {
+	extractReader(r io.Reader, sink Sink, opts ExtractOptions) error
+}
+
+// sinkFormat returns the registered format of the archive starting with header,
+// if it can be extracted to a Sink
+func sinkFormat(header []byte) (Format, error) {
+	f, err := detectHeader(header, nil)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, errors.New("unrecognized archive format")
+	}
+	if f.Capabilities()&CanExtract == 0 {
+		return nil, fmt.Errorf("unsupported archive format: %s", f.Name())
+	}
+	switch f.(type) {
+	case readerAtExtractor, readerExtractor:
+		return f, nil
+	}
+	return nil, fmt.Errorf("%s extraction to a sink: %w", f.Name(), ErrUnsupported)
+}
+
+// ExtractReaderAt passes the entries of the archive of size bytes read from r that opts
+// selects to sink, in archive order. The format is detected from the content. Options
+// about the destination on disk (Overwrite, SafeLinks, IgnorePermissions and
+// PreserveOwner) are left to the sink. Compressed streams such as ".gz" files hold one
+// entry named "data" whose size is reported as -1.
+func ExtractReaderAt(r io.ReaderAt, size int64, sink Sink, opts ExtractOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	header := make([]byte, min(size, headerSize))
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read file header: %w", err)
+	}
+	f, err := sinkFormat(header[:n])
+	if err != nil {
+		return err
+	}
+
+	// The compression ratio limit is relative to size
+	opts.guard = newExtractGuard(opts.Limits, size)
+	if err := opts.guard.checkInput(size); err != nil {
+		return err
+	}
+	if e, ok := f.(readerAtExtractor); ok {
+		return e.extractReaderAt(r, size, sink, opts)
+	}
+	return f.(readerExtractor).extractReader(io.NewSectionReader(r, 0, size), sink, opts)
+}
+
+// ExtractReader is like ExtractReaderAt for an archive read once from r, such as an
+// HTTP request body. Tar archives and compressed streams are extracted while they are
+// read, ZIP and 7z archives are buffered first, in memory or in a temporary file.
+// Limits.MaxInputSize bounds the data read from r in both cases.
+func ExtractReader(r io.Reader, sink Sink, opts ExtractOptions) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+
+	buffered := bufio.NewReaderSize(r, headerSize)
+	header, err := buffered.Peek(headerSize)
+	if err != nil && err != io.EOF {
+		return fmt.Errorf("failed to read file header: %w", err)
+	}
+	f, err := sinkFormat(header)
+	if err != nil {
+		return err
+	}
+
+	// The archive size grows as the archive is read
+	opts.guard = newExtractGuard(opts.Limits, 0)
+	input := opts.guard.archiveReader(buffered)
+	if e, ok := f.(readerExtractor); ok {
+		return e.extractReader(input, sink, opts)
+	}
+
+	// The index of the archive is read before its entries
+	data := newSpillBuffer(spillThreshold)
+	defer data.Close()
+	if _, err := io.Copy(data, input); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	return f.(readerAtExtractor).extractReaderAt(data, data.Size(), sink, opts)
+}
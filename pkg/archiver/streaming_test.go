@@ -0,0 +1,229 @@
+package archiver
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// memorySink collects the entries passed to it, with the content of regular files
+type memorySink struct {
+	files map[string]string
+	dirs  []string
+	links map[string]string
+}
+
+func newMemorySink() *memorySink {
+	return &memorySink{files: make(map[string]string), links: make(map[string]string)}
+}
+
+func (s *memorySink) Put(entry Entry, content io.Reader) error {
+	switch {
+	case entry.IsDir():
+		s.dirs = append(s.dirs, entry.Name)
+	case entry.Link != "":
+		s.links[entry.Name] = entry.Link
+	default:
+		data, err := io.ReadAll(content)
+		if err != nil {
+			return err
+		}
+		s.files[entry.Name] = string(data)
+	}
+	return nil
+}
+
+// streamingFS is compressed by the streaming tests
+var streamingFS = fstest.MapFS{
+	"readme.txt":          {Data: []byte("read me\n"), Mode: 0644},
+	"docs":                {Mode: os.ModeDir | 0755},
+	"docs/manual.txt":     {Data: []byte(strings.Repeat("manual ", 500)), Mode: 0644},
+	"docs/empty":          {Mode: os.ModeDir | 0755},
+	"scripts/build.sh":    {Data: []byte("#!/bin/sh\n"), Mode: 0755},
+	"scripts/.cache/tmp1": {Data: []byte("cached"), Mode: 0644},
+}
+
+func TestCompressFSRoundTrip(t *testing.T) {
+	want := map[string]string{
+		"readme.txt":       "read me\n",
+		"docs/manual.txt":  strings.Repeat("manual ", 500),
+		"scripts/build.sh": "#!/bin/sh\n",
+	}
+	for _, format := range []string{"zip", "tar", "tar.gz", "tar.xz", "tar.zst"} {
+		t.Run(format, func(t *testing.T) {
+			var archive bytes.Buffer
+			if err := CompressFS(streamingFS, &archive, format, CompressOptions{Exclude: []string{".cache"}}); err != nil {
+				t.Fatalf("CompressFS: %v", err)
+			}
+
+			// Single pass and random access extraction find the same entries
+			fromReader := newMemorySink()
+			if err := ExtractReader(bytes.NewReader(archive.Bytes()), fromReader, ExtractOptions{}); err != nil {
+				t.Fatalf("ExtractReader: %v", err)
+			}
+			fromReaderAt := newMemorySink()
+			if err := ExtractReaderAt(bytes.NewReader(archive.Bytes()), int64(archive.Len()), fromReaderAt, ExtractOptions{}); err != nil {
+				t.Fatalf("ExtractReaderAt: %v", err)
+			}
+			for _, sink := range []*memorySink{fromReader, fromReaderAt} {
+				if !reflect.DeepEqual(sink.files, want) {
+					t.Errorf("files %v, want %v", sink.files, want)
+				}
+				if !containsString(sink.dirs, "docs/empty") {
+					t.Errorf("directories %v, want docs/empty", sink.dirs)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressReaders(t *testing.T) {
+	files := []ReaderSource{
+		{Name: "upload/a.txt", Reader: strings.NewReader("first")},
+		{Name: "upload/b.txt", Reader: strings.NewReader("second"), Size: 6},
+		{Name: "upload/empty", Mode: os.ModeDir | 0755},
+		{Name: "upload/latest", Link: "b.txt"},
+		{Name: "upload/skip.log", Reader: strings.NewReader("log")},
+	}
+	for _, format := range []string{"zip", "tar.gz"} {
+		t.Run(format, func(t *testing.T) {
+			var archive bytes.Buffer
+			if err := CompressReaders(files, &archive, format, CompressOptions{Exclude: []string{"*.log"}}); err != nil {
+				t.Fatalf("CompressReaders: %v", err)
+			}
+			// The readers are consumed, rewind them for the next format
+			files[0].Reader = strings.NewReader("first")
+			files[1].Reader = strings.NewReader("second")
+
+			sink := newMemorySink()
+			if err := ExtractReader(&archive, sink, ExtractOptions{}); err != nil {
+				t.Fatalf("ExtractReader: %v", err)
+			}
+			if want := map[string]string{"upload/a.txt": "first", "upload/b.txt": "second"}; !reflect.DeepEqual(sink.files, want) {
+				t.Errorf("files %v, want %v", sink.files, want)
+			}
+			if !containsString(sink.dirs, "upload/empty") || sink.links["upload/latest"] != "b.txt" {
+				t.Errorf("directories %v and links %v, want upload/empty and upload/latest", sink.dirs, sink.links)
+			}
+		})
+	}
+
+	duplicate := []ReaderSource{
+		{Name: "a.txt", Reader: strings.NewReader("one")},
+		{Name: "./a.txt", Reader: strings.NewReader("two")},
+	}
+	if err := CompressReaders(duplicate, io.Discard, "tar", CompressOptions{}); !errors.Is(err, ErrDuplicateEntry) {
+		t.Errorf("duplicate names: error %v, want %v", err, ErrDuplicateEntry)
+	}
+}
+
+func TestExtractReaderSink(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "sink.tar")
+	writeTestTar(t, archive, []testEntry{
+		{name: "../escape.txt", content: "cleaned"},
+		{name: "keep/a.txt", content: "a"},
+		{name: "keep/b.txt", content: strings.Repeat("b", 1000)},
+		{name: "skip/c.txt", content: "c"},
+	})
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Names are cleaned and opts selects the entries
+	sink := newMemorySink()
+	if err := ExtractReader(bytes.NewReader(data), sink, ExtractOptions{Exclude: []string{"skip"}}); err != nil {
+		t.Fatalf("ExtractReader: %v", err)
+	}
+	want := map[string]string{"escape.txt": "cleaned", "keep/a.txt": "a", "keep/b.txt": strings.Repeat("b", 1000)}
+	if !reflect.DeepEqual(sink.files, want) {
+		t.Errorf("files %v, want %v", sink.files, want)
+	}
+
+	// Limits apply to the content read by the sink
+	err = ExtractReader(bytes.NewReader(data), newMemorySink(), ExtractOptions{Limits: ExtractLimits{MaxFileSize: 100}})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("file size limit: error %v, want %v", err, ErrLimitExceeded)
+	}
+
+	// An error of the sink stops the extraction
+	errFull := errors.New("sink full")
+	var puts int
+	err = ExtractReader(bytes.NewReader(data), SinkFunc(func(entry Entry, content io.Reader) error {
+		puts++
+		return errFull
+	}), ExtractOptions{})
+	if !errors.Is(err, errFull) || puts != 1 {
+		t.Errorf("error %v after %d entries, want %v after one", err, puts, errFull)
+	}
+}
+
+func TestExtractReaderStream(t *testing.T) {
+	var compressed bytes.Buffer
+	err := CompressReaders([]ReaderSource{{Name: "report.txt", Reader: strings.NewReader("report")}}, &compressed, "gz", CompressOptions{})
+	if err != nil {
+		t.Fatalf("CompressReaders: %v", err)
+	}
+
+	var entries []Entry
+	var content string
+	err = ExtractReader(&compressed, SinkFunc(func(entry Entry, r io.Reader) error {
+		entries = append(entries, entry)
+		data, err := io.ReadAll(r)
+		content = string(data)
+		return err
+	}), ExtractOptions{})
+	if err != nil {
+		t.Fatalf("ExtractReader: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Name != streamSinkEntry || entries[0].Size != -1 || content != "report" {
+		t.Errorf("entries %+v with content %q, want one %q entry of unknown size", entries, content, streamSinkEntry)
+	}
+}
+
+func TestExtractReaderInputSize(t *testing.T) {
+	// An endless ZIP stream is buffered up to the limit only, and the spill file removed
+	t.Setenv("TMPDIR", t.TempDir())
+	endless := io.MultiReader(strings.NewReader("PK\x03\x04"), zeroReader{})
+	limits := ExtractLimits{MaxInputSize: spillThreshold + 1<<20}
+	var limitErr *LimitError
+	err := ExtractReader(endless, newMemorySink(), ExtractOptions{Limits: limits})
+	if !errors.As(err, &limitErr) || limitErr.Limit != "input size" {
+		t.Errorf("endless ZIP stream: error %v, want the input size limit", err)
+	}
+	if spilled, err := os.ReadDir(os.TempDir()); err != nil || len(spilled) != 0 {
+		t.Errorf("temporary directory holds %v (%v), want nothing", spilled, err)
+	}
+
+	// Streamed formats are bounded while they are extracted
+	var archive bytes.Buffer
+	random := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	if err := CompressReaders([]ReaderSource{{Name: "random.bin", Reader: bytes.NewReader(random)}}, &archive, "tar.gz", CompressOptions{}); err != nil {
+		t.Fatalf("CompressReaders: %v", err)
+	}
+	err = ExtractReader(bytes.NewReader(archive.Bytes()), newMemorySink(), ExtractOptions{Limits: ExtractLimits{MaxInputSize: 32 << 10}})
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("tar.gz beyond the limit: error %v, want %v", err, ErrLimitExceeded)
+	}
+	if err := ExtractReader(&archive, newMemorySink(), ExtractOptions{Limits: ExtractLimits{MaxInputSize: 1 << 20}}); err != nil {
+		t.Errorf("tar.gz within the limit: %v", err)
+	}
+}
+
+// containsString reports whether list holds s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
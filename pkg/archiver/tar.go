@@ -0,0 +1,493 @@
+package archiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tarFormat reads and writes tar archives, optionally wrapped in a compression codec
+type tarFormat struct {
+	codec      *codec
+	extensions []string
+}
+
+func (f tarFormat) Name() string {
+	if f.codec == nil {
+		return "tar"
+	}
+	return "tar." + f.codec.name
+}
+
+func (f tarFormat) Extensions() []string {
+	if f.codec == nil {
+		return []string{".tar"}
+	}
+	return f.extensions
+}
+
+func (f tarFormat) Capabilities() Capability {
+	if f.codec != nil && f.codec.newWriter == nil {
+		return CanExtract | CanList
+	}
+	return CanCompress | CanExtract | CanList | CanBundle
+}
+
+func (f tarFormat) Detect(header []byte) bool {
+	if f.codec == nil {
+		return isTarHeader(header)
+	}
+	if !f.codec.detect(header) {
+		return false
+	}
+	// Compressed single files share the codec signature, look inside the stream
+	isTar, known := peekTar(f.codec, header)
+	return isTar || !known
+}
+
+func (f tarFormat) Compress(sources []Source, destPath string, opts CompressOptions, progressTracker *ProgressTracker) error {
+	if f.Capabilities()&CanCompress == 0 {
+		return fmt.Errorf("%s compression: %w", f.Name(), ErrUnsupported)
+	}
+	return compressTar(sources, destPath, f.codec, opts, progressTracker)
+}
+
+func (f tarFormat) Extract(sourcePath, destPath string, opts ExtractOptions, progressTracker *ProgressTracker) error {
+	return extractTar(sourcePath, destPath, f.codec, opts, progressTracker)
+}
+
+func (f tarFormat) Test(sourcePath, password string) (*TestResult, error) {
+	return f.testLimited(sourcePath, password, nil)
+}
+
+func (f tarFormat) testLimited(sourcePath, password string, guard *extractGuard) (*TestResult, error) {
+	result := &TestResult{}
+	err := readTar(sourcePath, f.codec, guard, nil, func(tarReader *tar.Reader, header *tar.Header) error {
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		result.Tested++
+		if err := checkEntryData(tarReader, header.Size); err != nil {
+			if errors.Is(err, ErrLimitExceeded) {
+				return err
+			}
+			// Nothing after a damaged entry can be read
+			result.fail(header.Name, err)
+			return errTarStopped
+		}
+		return nil
+	})
+	if err != nil && err != errTarStopped {
+		return result, err
+	}
+	return result, nil
+}
+
+func (f tarFormat) List(sourcePath string) ([]Entry, error) {
+	return f.listLimited(sourcePath, nil)
+}
+
+func (f tarFormat) listLimited(sourcePath string, guard *extractGuard) ([]Entry, error) {
+	// Entries are stored as is, the archive may be compressed as a whole
+	method := "store"
+	if f.codec != nil {
+		method = f.codec.name
+	}
+
+	var entries []Entry
+	err := readTar(sourcePath, f.codec, guard, nil, func(tarReader *tar.Reader, header *tar.Header) error {
+		entries = append(entries, tarEntry(header, method))
+		return nil
+	})
+	return entries, err
+}
+
+func (f tarFormat) extractReader(r io.Reader, sink Sink, opts ExtractOptions) error {
+	return extractTarTo(r, f.codec, sink, opts)
+}
+
+// tarEntry describes the entry of header, stored with method
+func tarEntry(header *tar.Header, method string) Entry {
+	entry := Entry{
+		Name:     header.Name,
+		Size:     header.Size,
+		Method:   method,
+		Mode:     header.FileInfo().Mode(),
+		Modified: header.ModTime,
+	}
+	if header.Typeflag == tar.TypeSymlink || header.Typeflag == tar.TypeLink {
+		entry.Link = header.Linkname
+	}
+	return entry
+}
+
+// isTarHeader reports whether header starts with a POSIX or GNU tar header
+func isTarHeader(header []byte) bool {
+	if len(header) < 263 {
+		return false
+	}
+	magic := header[257:263]
+	return bytes.Equal(magic, []byte("ustar\x00")) || bytes.Equal(magic, []byte("ustar "))
+}
+
+// compressTar writes sources into a tar archive at destPath, compressed with c if it is not nil
+func compressTar(sources []Source, destPath string, c *codec, opts CompressOptions, progressTracker *ProgressTracker) error {
+	return compressToFile(destPath, func(dest io.Writer) error {
+		return writeTar(dest, sourcesWalker(sources, opts), c, opts, progressTracker)
+	})
+}
+
+// writeTar writes the entries of walk as a tar archive to w, compressed with c if it is not nil
+func writeTar(w io.Writer, walk entryWalker, c *codec, opts CompressOptions, progressTracker *ProgressTracker) error {
+	// Wrap the destination with the compression codec
+	var out io.WriteCloser = nopWriteCloser{w}
+	if c != nil {
+		var err error
+		out, err = c.newWriter(w, opts.Level)
+		if err != nil {
+			return fmt.Errorf("failed to create %s writer: %w", c.name, err)
+		}
+	}
+
+	tarWriter := tar.NewWriter(out)
+
+	// Use a larger buffer for better throughput
+	buffer := make([]byte, opts.bufferSize())
+
+	// Walk through the selected files, hard links may span sources
+	links := make(hardLinks)
+	err := walk(func(entry sourceEntry) error {
+		progressTracker.StartFile(entry.name)
+		size, err := addFileToTar(tarWriter, entry, links, buffer, progressTracker)
+		if err != nil {
+			return err
+		}
+		progressTracker.FinishFile(entry.name, size, tarStoredSize(size, c))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error walking sources: %w", err)
+	}
+
+	// Flush the archive trailer and the codec
+	if err := tarWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish tar archive: %w", err)
+	}
+	if c != nil {
+		if err := out.Close(); err != nil {
+			return fmt.Errorf("failed to finish %s stream: %w", c.name, err)
+		}
+	}
+
+	// Mark progress as complete
+	progressTracker.SetComplete()
+
+	return nil
+}
+
+// tarStoredSize returns the size in the archive of an entry of the given size, which is
+// not known once the archive is compressed with c
+func tarStoredSize(size int64, c *codec) int64 {
+	if c != nil {
+		return -1
+	}
+	return size
+}
+
+// addFileToTar adds the file, directory or symlink of entry to the tar archive and returns
+// the size of its content. Files already archived under another name in links are stored as hard links.
+func addFileToTar(tarWriter *tar.Writer, entry sourceEntry, links hardLinks, buffer []byte, progressTracker *ProgressTracker) (int64, error) {
+	info := entry.info
+	// Sockets cannot be archived
+	if info.Mode()&os.ModeSocket != 0 {
+		return 0, nil
+	}
+
+	// Symlinks are stored as links instead of being followed
+	header, err := tar.FileInfoHeader(info, entry.link)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create tar header: %w", err)
+	}
+
+	// Use the name in the archive for the header name
+	header.Name = entry.name
+	// PAX headers keep the access time and sub-second times, the change time cannot be restored
+	header.Format = tar.FormatPAX
+	header.ChangeTime = time.Time{}
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if info.Mode().IsRegular() {
+		if first, ok := links.seen(info, header.Name); ok {
+			header.Typeflag = tar.TypeLink
+			header.Linkname = first
+			header.Size = 0
+		}
+	}
+
+	var content io.Reader
+	if header.Typeflag == tar.TypeReg {
+		file, err := entry.open()
+		if err != nil {
+			return 0, fmt.Errorf("failed to open file %s: %w", entry.path, err)
+		}
+		defer file.Close()
+		content = file
+
+		// The header comes first, content of unknown size is collected to measure it
+		if header.Size < 0 {
+			data := newSpillBuffer(spillThreshold)
+			defer data.Close()
+			if _, err := io.CopyBuffer(data, file, buffer); err != nil {
+				return 0, fmt.Errorf("failed to read %s: %w", entry.path, err)
+			}
+			header.Size = data.Size()
+			content = io.NewSectionReader(data, 0, data.Size())
+		}
+	}
+
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return 0, fmt.Errorf("failed to write tar header: %w", err)
+	}
+	if content == nil {
+		return 0, nil
+	}
+
+	// Copy contents with progress tracking
+	size, err := io.CopyBuffer(tarWriter, NewProgressReader(content, progressTracker), buffer)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write file to tar: %w", err)
+	}
+
+	return size, nil
+}
+
+// errTarStopped is returned by readTar callbacks to stop reading without an error
+var errTarStopped = errors.New("tar reading stopped")
+
+// readTar calls fn for every entry of the tar archive at sourcePath.
+// Progress is reported in bytes of the (possibly compressed) archive file.
+func readTar(sourcePath string, c *codec, guard *extractGuard, progressTracker *ProgressTracker, fn func(*tar.Reader, *tar.Header) error) error {
+	file, err := os.Open(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open tar file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+	progressTracker.SetTotalSize(info.Size())
+
+	return readTarStream(NewProgressReader(file, progressTracker), c, guard, fn)
+}
+
+// readTarStream calls fn for every entry of the tar archive read from in,
+// decompressing it with c if it is not nil. The whole decompressed archive is
+// counted against the size limits of guard, extractions pass nil and count the
+// content of each entry instead.
+func readTarStream(in io.Reader, c *codec, guard *extractGuard, fn func(*tar.Reader, *tar.Header) error) error {
+	if c != nil {
+		decompressor, err := c.newReader(in)
+		if err != nil {
+			return fmt.Errorf("failed to open %s stream: %w", c.name, err)
+		}
+		defer decompressor.Close()
+		in = decompressor
+	}
+	in = guard.reader(in, "archive")
+
+	tarReader := tar.NewReader(in)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			// Read the rest of a compressed stream so the codec verifies its checksum
+			if c != nil {
+				if _, err := io.Copy(io.Discard, in); err != nil {
+					return fmt.Errorf("failed to read tar archive: %w", err)
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+		if err := fn(tarReader, header); err != nil {
+			return err
+		}
+	}
+}
+
+// extractTar extracts a tar archive, decompressing it with c if it is not nil.
+// The whole archive is read even if opts selects a few entries, so progress
+// is reported in bytes of the archive file either way.
+func extractTar(sourcePath, destPath string, c *codec, opts ExtractOptions, progressTracker *ProgressTracker) error {
+	// Directory metadata is restored last, since creating files inside updates their times
+	var dirs []pendingDir
+	// Entries extracted to another path by OverwriteRename, for the hard links pointing to them
+	renamed := make(map[string]string)
+
+	err := readTar(sourcePath, c, nil, progressTracker, func(tarReader *tar.Reader, header *tar.Header) error {
+		if filepath.Clean(header.Name) == "." || !opts.selects(header.Name) {
+			return nil
+		}
+		// The data of a hard link is in the entry it links to, there is nothing to link to
+		// once that entry is left out
+		if header.Typeflag == tar.TypeLink && !opts.selects(header.Linkname) {
+			opts.Summary.unlink(header.Name)
+			return nil
+		}
+		filePath, err := opts.entryPath(destPath, header.Name)
+		if err != nil {
+			return err
+		}
+		if err := opts.guard.entry(header.Name); err != nil {
+			return err
+		}
+		progressTracker.StartFile(header.Name)
+		defer progressTracker.FinishFile(header.Name, header.Size, tarStoredSize(header.Size, c))
+
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeSymlink, tar.TypeLink:
+			entryPath := filePath
+			filePath, err = opts.resolveConflict(header.Name, filePath, header.ModTime)
+			if err != nil || filePath == "" {
+				return err
+			}
+			if filePath != entryPath {
+				renamed[entryPath] = filePath
+			}
+		}
+
+		meta := entryMetadata{
+			mode:       header.FileInfo().Mode(),
+			modTime:    header.ModTime,
+			accessTime: header.AccessTime,
+			uid:        header.Uid,
+			gid:        header.Gid,
+			hasOwner:   true,
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := opts.guard.mkdirAll(filePath); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			dirs = append(dirs, pendingDir{path: filePath, meta: meta})
+			return nil
+
+		case tar.TypeReg:
+			if err := writeExtractedFile(opts.guard, filePath, tarReader, nil); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			if err := opts.checkLinkTarget(destPath, filePath, header.Linkname); err != nil {
+				return err
+			}
+			if err := replaceWithLink(opts.guard, filePath, func(linkPath string) error { return os.Symlink(header.Linkname, linkPath) }); err != nil {
+				return fmt.Errorf("failed to create symlink: %w", err)
+			}
+
+		case tar.TypeLink:
+			target, err := opts.entryPath(destPath, header.Linkname)
+			if err != nil {
+				return err
+			}
+			// Link to the extracted file if it had to be renamed
+			if path, ok := renamed[target]; ok {
+				target = path
+			}
+			if err := replaceWithLink(opts.guard, filePath, func(linkPath string) error { return os.Link(target, linkPath) }); err != nil {
+				return fmt.Errorf("failed to create hard link: %w", err)
+			}
+
+		default:
+			// Devices, FIFOs and other special files are not restored
+			return nil
+		}
+
+		return opts.restoreMetadata(filePath, meta)
+	})
+	if err != nil {
+		return err
+	}
+	if err := opts.restoreDirs(dirs); err != nil {
+		return err
+	}
+
+	// Mark progress as complete
+	progressTracker.SetComplete()
+
+	return nil
+}
+
+// extractTarTo passes the entries of the tar archive read from r that opts selects to sink,
+// decompressing it with c if it is not nil. Devices, FIFOs and other special files are skipped.
+func extractTarTo(r io.Reader, c *codec, sink Sink, opts ExtractOptions) error {
+	method := "store"
+	if c != nil {
+		method = c.name
+	}
+
+	return readTarStream(r, c, nil, func(tarReader *tar.Reader, header *tar.Header) error {
+		name := cleanEntryName(header.Name)
+		if name == "" || !opts.selects(name) {
+			return nil
+		}
+		if header.Typeflag == tar.TypeLink && !opts.selects(header.Linkname) {
+			opts.Summary.unlink(name)
+			return nil
+		}
+
+		var content io.Reader
+		switch header.Typeflag {
+		case tar.TypeReg:
+			content = opts.guard.reader(tarReader, name)
+		case tar.TypeDir, tar.TypeSymlink, tar.TypeLink:
+			content = strings.NewReader("")
+		default:
+			return nil
+		}
+		if err := opts.guard.entry(name); err != nil {
+			return err
+		}
+
+		entry := tarEntry(header, method)
+		entry.Name = name
+		if header.Typeflag == tar.TypeLink {
+			entry.Link = cleanEntryName(header.Linkname)
+		}
+		return sink.Put(entry, content)
+	})
+}
+
+// replaceWithLink creates a link next to filePath by calling create with its path, and
+// then replaces whatever exists at filePath with it. guard records the created link and directories.
+func replaceWithLink(guard *extractGuard, filePath string, create func(linkPath string) error) error {
+	if err := guard.mkdirAll(filepath.Dir(filePath)); err != nil {
+		return err
+	}
+	linkPath, err := newTempPath(filePath)
+	if err != nil {
+		return err
+	}
+	os.Remove(linkPath)
+	if err := create(linkPath); err != nil {
+		return err
+	}
+	if err := guard.replace(linkPath, filePath); err != nil {
+		os.Remove(linkPath)
+		return err
+	}
+	if info, err := os.Lstat(filePath); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		guard.createdSymlink(info)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package archiver
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// roundTripTree is compressed by the round trip tests, with a symlink and an empty directory
+var roundTripTree = map[string]string{
+	"readme.txt":         "read me\n",
+	"docs/guide.md":      "# Guide\n\nLong enough to be worth compressing, long enough to be worth compressing.\n",
+	"docs/deep/data.csv": "a,b,c\n1,2,3\n",
+	"empty.txt":          "",
+}
+
+// writeRoundTripTree creates the round trip tree below a new directory and returns it
+func writeRoundTripTree(t *testing.T) string {
+	t.Helper()
+	source := t.TempDir()
+	writeFiles(t, source, roundTripTree)
+	if err := os.Mkdir(filepath.Join(source, "emptydir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("docs/guide.md", filepath.Join(source, "guide")); err != nil {
+		t.Fatal(err)
+	}
+	return source
+}
+
+// checkRoundTrip compresses the round trip tree to an archive named name in format, and
+// checks the archive with checkTreeArchive
+func checkRoundTrip(t *testing.T, source, name, format, want string) {
+	t.Helper()
+	archive := filepath.Join(t.TempDir(), name)
+	if err := CompressWithProgress(source, archive, format, CompressOptions{}, nil); err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	checkTreeArchive(t, archive, want)
+}
+
+// checkTreeArchive checks that the archive of the round trip tree is detected as want,
+// and lists, tests and extracts back to the tree
+func checkTreeArchive(t *testing.T, archive, want string) {
+	t.Helper()
+	f, err := DetectFormat(archive)
+	if err != nil || f.Name() != want {
+		t.Fatalf("detected %v (%v), want %s", f, err, want)
+	}
+
+	entries, err := List(archive)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	// Directory names end with a slash in the listing
+	listed := make(map[string]Entry)
+	for _, entry := range entries {
+		listed[cleanEntryName(entry.Name)] = entry
+	}
+	for name, content := range roundTripTree {
+		if entry, ok := listed[name]; !ok || entry.Size != int64(len(content)) {
+			t.Errorf("%s: listed as %+v, want %d bytes", name, entry, len(content))
+		}
+	}
+	if entry := listed["guide"]; entry.Mode&os.ModeSymlink == 0 || entry.Link != "docs/guide.md" {
+		t.Errorf("guide: listed as %+v, want a symlink to docs/guide.md", entry)
+	}
+	if !listed["emptydir"].IsDir() {
+		t.Errorf("emptydir: listed as %+v, want a directory", listed["emptydir"])
+	}
+
+	result, err := Test(archive, "")
+	if err != nil || !result.OK() {
+		t.Fatalf("Test: %v, failures %v", err, result)
+	}
+
+	dest := t.TempDir()
+	if err := ExtractWithProgress(archive, dest, ExtractOptions{}, nil); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	checkExtracted(t, dest, roundTripTree)
+	if target, err := os.Readlink(filepath.Join(dest, "guide")); err != nil || target != "docs/guide.md" {
+		t.Errorf("guide: link to %q (%v), want docs/guide.md", target, err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "emptydir")); err != nil || !info.IsDir() {
+		t.Errorf("emptydir: %v, want a directory", err)
+	}
+}
+
+func TestTarRoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks are not available")
+	}
+
+	source := writeRoundTripTree(t)
+	tests := []struct {
+		name   string
+		format string
+		want   string
+	}{
+		{"tree.tar", "tar", "tar"},
+		{"tree.tar.gz", "tar.gz", "tar.gz"},
+		{"tree.tgz", "tgz", "tar.gz"},
+		{"tree.tar.xz", "tar.xz", "tar.xz"},
+		{"tree.txz", "txz", "tar.xz"},
+		{"tree.tar.zst", "tar.zst", "tar.zst"},
+		{"tree.tzst", "tzst", "tar.zst"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkRoundTrip(t, source, tt.name, tt.format, tt.want)
+		})
+	}
+}
+
+func TestTarBzip2(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks are not available")
+	}
+
+	// bzip2 is extract only, the fixture was made with tar and bzip2
+	checkTreeArchive(t, filepath.Join("testdata", "tree.tar.bz2"), "tar.bz2")
+
+	source := writeRoundTripTree(t)
+	if err := CompressWithProgress(source, filepath.Join(t.TempDir(), "tree.tar.bz2"), "tar.bz2", CompressOptions{}, nil); err == nil {
+		t.Error("compressed to tar.bz2, want an error")
+	}
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	content := strings.Repeat("a single file compressed as a stream\n", 100)
+	source := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(source, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	archives := map[string]string{"bz2": filepath.Join("testdata", "hello.txt.bz2")}
+	for _, format := range []string{"gz", "xz", "zst"} {
+		archives[format] = filepath.Join(t.TempDir(), "notes.txt."+format)
+		if err := CompressWithProgress(source, archives[format], format, CompressOptions{}, nil); err != nil {
+			t.Fatalf("Compress to %s: %v", format, err)
+		}
+	}
+	want := map[string]map[string]string{
+		"gz":  {"notes.txt": content},
+		"xz":  {"notes.txt": content},
+		"zst": {"notes.txt": content},
+		"bz2": {"hello.txt": "hello, bzip2\n"},
+	}
+
+	for format, archive := range archives {
+		t.Run(format, func(t *testing.T) {
+			f, err := DetectFormat(archive)
+			if err != nil || f.Name() != format {
+				t.Fatalf("detected %v (%v), want %s", f, err, format)
+			}
+
+			entries, err := List(archive)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			for name, content := range want[format] {
+				if len(entries) != 1 || entries[0].Name != name || entries[0].Size != int64(len(content)) {
+					t.Errorf("listed %+v, want %s of %d bytes", entries, name, len(content))
+				}
+			}
+
+			dest := t.TempDir()
+			if err := ExtractWithProgress(archive, dest, ExtractOptions{}, nil); err != nil {
+				t.Fatalf("Extract: %v", err)
+			}
+			checkExtracted(t, dest, want[format])
+		})
+	}
+}
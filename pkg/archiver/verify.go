@@ -0,0 +1,92 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrChecksum is returned when the data of an entry does not match its stored checksum
+var ErrChecksum = errors.New("checksum mismatch")
+
+// TestResult is the outcome of checking an archive with Test
+type TestResult struct {
+	// Tested is the number of entries whose data was checked
+	Tested int
+	// Failures lists the entries that are damaged or could not be read
+	Failures []EntryError
+}
+
+// OK reports whether every tested entry passed
+func (r *TestResult) OK() bool {
+	return len(r.Failures) == 0
+}
+
+// fail records a damaged entry
+func (r *TestResult) fail(name string, err error) {
+	r.Failures = append(r.Failures, EntryError{Name: name, Err: err})
+}
+
+// EntryError is the failure of a single archive entry
+type EntryError struct {
+	Name string
+	Err  error
+}
+
+func (e EntryError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+func (e EntryError) Unwrap() error {
+	return e.Err
+}
+
+// Test checks the integrity of the archive at sourcePath by decompressing every entry
+// and verifying its checksum and size, without writing anything. password is used
+// for encrypted entries. Damaged entries are reported in the result; the error is
+// only set when the archive cannot be read at all or is damaged outside of an entry.
+func Test(sourcePath, password string) (*TestResult, error) {
+	f, err := extractionFormat(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	return f.Test(sourcePath, password)
+}
+
+// limitedTester is implemented by formats able to count the data they decompress while testing
+type limitedTester interface {
+	// testLimited tests the archive at sourcePath, counting the decompressed data
+	// against the size limits of guard, which may be nil
+	testLimited(sourcePath, password string, guard *extractGuard) (*TestResult, error)
+}
+
+// TestWithLimits is like Test, but stops with a LimitError once the decompressed data
+// exceeds the MaxTotalSize or MaxRatio of limits. The other limits are not used.
+func TestWithLimits(sourcePath, password string, limits ExtractLimits) (*TestResult, error) {
+	f, err := extractionFormat(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	tester, ok := f.(limitedTester)
+	if !ok {
+		return f.Test(sourcePath, password)
+	}
+	guard, err := newSizeGuard(sourcePath, limits)
+	if err != nil {
+		return nil, err
+	}
+	return tester.testLimited(sourcePath, password, guard)
+}
+
+// checkEntryData reads r to the end and verifies that it holds size bytes.
+// Checksums are verified by the readers of each format.
+func checkEntryData(r io.Reader, size int64) error {
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("size mismatch: expected %d bytes, got %d", size, n)
+	}
+	return nil
+}
@@ -0,0 +1,553 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/latreon/file-compressor/pkg/utils"
+)
+
+// zipFormat reads and writes ZIP archives
+type zipFormat struct{}
+
+func (zipFormat) Name() string         { return "zip" }
+func (zipFormat) Extensions() []string { return []string{".zip"} }
+func (zipFormat) Capabilities() Capability {
+	return CanCompress | CanExtract | CanList | CanEncrypt | CanBundle
+}
+
+func (zipFormat) Detect(header []byte) bool {
+	// Local file header, or the end of central directory record of an empty archive
+	return bytes.HasPrefix(header, []byte("PK\x03\x04")) || bytes.HasPrefix(header, []byte("PK\x05\x06"))
+}
+
+func (zipFormat) Compress(sources []Source, destPath string, opts CompressOptions, progressTracker *ProgressTracker) error {
+	return compressZipWithProgress(sources, destPath, opts, progressTracker)
+}
+
+func (zipFormat) Extract(sourcePath, destPath string, opts ExtractOptions, progressTracker *ProgressTracker) error {
+	return extractZipWithProgress(sourcePath, destPath, opts, progressTracker)
+}
+
+func (zipFormat) Test(sourcePath, password string) (*TestResult, error) {
+	return testZip(sourcePath, password, nil)
+}
+
+func (zipFormat) testLimited(sourcePath, password string, guard *extractGuard) (*TestResult, error) {
+	return testZip(sourcePath, password, guard)
+}
+
+func (zipFormat) List(sourcePath string) ([]Entry, error) {
+	reader, err := zip.OpenReader(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip file: %w", err)
+	}
+	defer reader.Close()
+
+	entries := make([]Entry, 0, len(reader.File))
+	for _, file := range reader.File {
+		entries = append(entries, zipEntry(file))
+	}
+	return entries, nil
+}
+
+func (zipFormat) extractReaderAt(r io.ReaderAt, size int64, sink Sink, opts ExtractOptions) error {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("failed to open zip file: %w", err)
+	}
+	return extractZipTo(reader, sink, opts)
+}
+
+// zipEntry describes a ZIP entry as listed
+func zipEntry(file *zip.File) Entry {
+	// WinZip AES entries keep their real method in an extra field
+	method := file.Method
+	if method == zipMethodAES {
+		if _, _, actual, ok := parseAESExtra(file.Extra); ok {
+			method = actual
+		}
+	}
+	return Entry{
+		Name:           file.Name,
+		Size:           int64(file.UncompressedSize64),
+		CompressedSize: int64(file.CompressedSize64),
+		Method:         zipMethodName(method),
+		CRC32:          file.CRC32,
+		Encrypted:      file.Flags&zipFlagEncrypted != 0,
+		Mode:           file.Mode(),
+		Modified:       file.Modified,
+	}
+}
+
+// zipMethodName returns the name of a ZIP compression method
+func zipMethodName(method uint16) string {
+	switch method {
+	case zip.Store:
+		return "store"
+	case zip.Deflate:
+		return "deflate"
+	case 9:
+		return "deflate64"
+	case 12:
+		return "bzip2"
+	case 14:
+		return "lzma"
+	case 93:
+		return "zstd"
+	case 95:
+		return "xz"
+	}
+	return fmt.Sprintf("method %d", method)
+}
+
+// compressZipWithProgress compresses files using the ZIP format with progress reporting.
+// Entries are encrypted with AES-256 (WinZip AE-2) when opts has a password.
+func compressZipWithProgress(sources []Source, destPath string, opts CompressOptions, progressTracker *ProgressTracker) error {
+	return compressToFile(destPath, func(dest io.Writer) error {
+		return writeZip(dest, sourcesWalker(sources, opts), opts, progressTracker)
+	})
+}
+
+// writeZip writes the entries of walk as a ZIP archive to w
+func writeZip(w io.Writer, walk entryWalker, opts CompressOptions, progressTracker *ProgressTracker) error {
+	// Create a new zip writer, progress counts the bytes read from the entries.
+	// Entries are compressed with the selected level before they are written raw.
+	zipWriter := zip.NewWriter(w)
+
+	if opts.workers() > 1 {
+		// Compress entries concurrently, they are written in walk order
+		if err := compressZipParallel(zipWriter, walk, opts, progressTracker); err != nil {
+			return fmt.Errorf("error walking sources: %w", err)
+		}
+	} else {
+		// Use a larger buffer for better compression, the workers have their own
+		buffer := make([]byte, opts.bufferSize())
+
+		// Walk through the selected files, the name in the archive is the zip header name
+		err := walk(func(entry sourceEntry) error {
+			progressTracker.StartFile(entry.name)
+			size, storedSize, err := addFileToZipWithBuffer(zipWriter, entry, opts, buffer, progressTracker)
+			if err != nil {
+				return err
+			}
+			progressTracker.FinishFile(entry.name, size, storedSize)
+			return nil
+		})
+
+		if err != nil {
+			return fmt.Errorf("error walking sources: %w", err)
+		}
+	}
+
+	// Write the central directory
+	if err := zipWriter.Close(); err != nil {
+		return fmt.Errorf("failed to finish zip archive: %w", err)
+	}
+
+	// Mark progress as complete
+	progressTracker.SetComplete()
+
+	return nil
+}
+
+// addFileToZipWithBuffer adds the file, directory or symlink of entry to the zip archive using a buffer.
+// It returns the size of the content and the size of its data in the archive.
+func addFileToZipWithBuffer(zipWriter *zip.Writer, entry sourceEntry, opts CompressOptions, buffer []byte, progressTracker *ProgressTracker) (int64, int64, error) {
+	header, file, err := openZipEntry(entry, opts, progressTracker)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	// Directories have no data
+	if entry.info.IsDir() {
+		if _, err := zipWriter.CreateHeader(header); err != nil {
+			return 0, 0, fmt.Errorf("failed to create zip header: %w", err)
+		}
+		return 0, 0, nil
+	}
+
+	return addRawFileToZip(zipWriter, header, file, opts, buffer)
+}
+
+// openZipEntry opens the content of entry and returns the header to store it, with the method
+// selected for the content. Symlinks are not followed, their entry holds the link target like
+// Info-ZIP stores them. Directories have no content. Reading the content of files reports progress.
+func openZipEntry(entry sourceEntry, opts CompressOptions, progressTracker *ProgressTracker) (*zip.FileHeader, io.ReadCloser, error) {
+	// Create zip header
+	header, err := zip.FileInfoHeader(entry.info)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create zip header: %w", err)
+	}
+	if entry.info.Size() < 0 {
+		// The size of streamed content is written after it
+		header.UncompressedSize64, header.UncompressedSize = 0, 0
+	}
+	// Use the name in the archive for the header name
+	header.Name = entry.name
+	// Keep the access time and owner in Info-ZIP Unix fields
+	header.Extra = unixZipExtra(entry.info)
+
+	if entry.info.IsDir() {
+		header.Name += "/"
+		header.Method = zip.Store
+		return header, io.NopCloser(strings.NewReader("")), nil
+	}
+
+	if entry.info.Mode()&os.ModeSymlink != 0 {
+		header.Method = zip.Store
+		return header, io.NopCloser(strings.NewReader(entry.link)), nil
+	}
+
+	file, err := entry.open()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file %s: %w", entry.path, err)
+	}
+	// Use the selected compression method, or store content that is already compressed.
+	// The start of the content is kept in a buffer to look at it.
+	content := bufio.NewReaderSize(file, entropySampleSize)
+	sample, _ := content.Peek(entropySampleSize)
+	header.Method = zipEntryMethod(sample, entry.name, opts)
+	return header, readCloser{NewProgressReader(content, progressTracker), file}, nil
+}
+
+// readCloser reads from Reader and closes Closer, the source of Reader
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// addRawFileToZip compresses file, and encrypts it as an AE-2 entry if opts has a password.
+// The data is written raw, so the sizes are stored in a data descriptor afterwards.
+// It returns the size of the content and the size of its data in the archive.
+func addRawFileToZip(zipWriter *zip.Writer, header *zip.FileHeader, file io.Reader, opts CompressOptions, buffer []byte) (int64, int64, error) {
+	method := header.Method
+	prepareRawHeader(header)
+	if opts.Password != "" {
+		setAESHeader(header, method)
+	}
+	header.Flags |= 0x8 // sizes follow in a data descriptor
+
+	writer, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create zip header: %w", err)
+	}
+
+	counter := &countingWriter{w: writer}
+	var out io.Writer = counter
+	var encrypter *aesWriter
+	if opts.Password != "" {
+		encrypter, err = newAESWriter(counter, opts.Password)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to encrypt %s: %w", header.Name, err)
+		}
+		out = encrypter
+	}
+	compressor := newZipEntryWriter(out, method, opts.Level)
+
+	checksum := crc32.NewIEEE()
+	size, err := io.CopyBuffer(compressor, io.TeeReader(file, checksum), buffer)
+	if err == nil {
+		err = compressor.Close()
+	}
+	if err == nil && encrypter != nil {
+		err = encrypter.Close()
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to write file to zip: %w", err)
+	}
+
+	// The zip writer reads the sizes from the header when the entry is finished
+	if encrypter == nil {
+		header.CRC32 = checksum.Sum32()
+	}
+	header.CompressedSize64 = uint64(counter.n)
+	header.UncompressedSize64 = uint64(size)
+	header.CompressedSize = uint32(min(header.CompressedSize64, math.MaxUint32))
+	header.UncompressedSize = uint32(min(header.UncompressedSize64, math.MaxUint32))
+
+	return size, counter.n, nil
+}
+
+// prepareRawHeader fills the header fields that CreateHeader derives on its own,
+// for entries written with CreateRaw
+func prepareRawHeader(header *zip.FileHeader) {
+	header.CreatorVersion = header.CreatorVersion&0xff00 | 20
+	header.ReaderVersion = 20
+	if utf8.ValidString(header.Name) && !isASCII(header.Name) {
+		header.Flags |= 0x800
+	}
+
+	// MS-DOS time and the extended timestamp used by Info-ZIP.
+	// MS-DOS dates start in 1980, earlier times are only kept by the extended timestamp.
+	dosTime := header.Modified
+	if dosTime.Year() < 1980 {
+		dosTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	header.ModifiedDate = uint16(dosTime.Day() + int(dosTime.Month())<<5 + (dosTime.Year()-1980)<<9)
+	header.ModifiedTime = uint16(dosTime.Second()/2 + dosTime.Minute()<<5 + dosTime.Hour()<<11)
+	timestamp := make([]byte, 9)
+	binary.LittleEndian.PutUint16(timestamp[0:], 0x5455)
+	binary.LittleEndian.PutUint16(timestamp[2:], 5)
+	timestamp[4] = 1 // modification time only
+	binary.LittleEndian.PutUint32(timestamp[5:], uint32(header.Modified.Unix()))
+	header.Extra = append(header.Extra, timestamp...)
+}
+
+// setAESHeader marks header as an AE-2 entry whose data is compressed with method
+func setAESHeader(header *zip.FileHeader, method uint16) {
+	header.Method = zipMethodAES
+	header.Flags |= zipFlagEncrypted
+	header.CreatorVersion = header.CreatorVersion&0xff00 | 51
+	header.ReaderVersion = 51
+	header.CRC32 = 0 // AE-2 stores no CRC, the authentication code protects the data
+	header.Extra = append(header.Extra, aesExtra(method)...)
+}
+
+// newZipEntryWriter returns a writer that compresses entry data with method to w
+func newZipEntryWriter(w io.Writer, method uint16, level CompressionLevel) io.WriteCloser {
+	if method == zip.Deflate {
+		return utils.NewCompressionWriter(w, level.flateLevel())
+	}
+	return nopWriteCloser{w}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// nopWriteCloser adds a Close method that does nothing to an io.Writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// isASCII reports whether s only contains ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// extractZipWithProgress extracts the entries of a ZIP archive selected by opts with progress reporting.
+// opts.Password is used for entries encrypted with WinZip AES or ZipCrypto.
+func extractZipWithProgress(sourcePath, destPath string, opts ExtractOptions, progressTracker *ProgressTracker) error {
+	// Open the zip file
+	reader, err := zip.OpenReader(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to open zip file: %w", err)
+	}
+	defer reader.Close()
+
+	// Calculate total uncompressed size of the selected entries for progress tracking
+	var files []*zip.File
+	var totalSize int64
+	for _, file := range reader.File {
+		// The "./" entry of archives made from the current directory is the destination itself
+		if cleanEntryName(file.Name) != "" && opts.selects(file.Name) {
+			files = append(files, file)
+			totalSize += int64(file.UncompressedSize64)
+		}
+	}
+
+	// Reject archives announcing too much data before writing anything
+	if err := opts.guard.checkDeclared(totalSize); err != nil {
+		return err
+	}
+
+	// Set totals in progress tracker
+	progressTracker.SetTotalFiles(int64(len(files)))
+	progressTracker.SetTotalSize(totalSize)
+
+	// Extract each file, directory metadata is restored once their contents are written
+	var dirs []pendingDir
+	for _, file := range files {
+		progressTracker.StartFile(file.Name)
+		err := extractZipFileWithProgressTracker(file, destPath, opts, &dirs, progressTracker)
+		if err != nil {
+			return err
+		}
+		progressTracker.FinishFile(file.Name, int64(file.UncompressedSize64), int64(file.CompressedSize64))
+	}
+	if err := opts.restoreDirs(dirs); err != nil {
+		return err
+	}
+
+	// Mark progress as complete
+	progressTracker.SetComplete()
+
+	return nil
+}
+
+// extractZipFileWithProgressTracker extracts a single file from a ZIP archive with progress tracking,
+// resolving conflicts with existing files according to opts. Directories are added to dirs.
+func extractZipFileWithProgressTracker(file *zip.File, destPath string, opts ExtractOptions, dirs *[]pendingDir, progressTracker *ProgressTracker) error {
+	filePath, err := opts.entryPath(destPath, file.Name)
+	if err != nil {
+		return err
+	}
+	if err := opts.guard.entry(file.Name); err != nil {
+		return err
+	}
+
+	meta := zipMetadata(file)
+
+	// Create directory tree
+	if file.FileInfo().IsDir() {
+		if err := opts.guard.mkdirAll(filePath); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+		*dirs = append(*dirs, pendingDir{path: filePath, meta: meta})
+		return nil
+	}
+
+	filePath, err = opts.resolveConflict(file.Name, filePath, file.Modified)
+	if err != nil {
+		return err
+	}
+	if filePath == "" {
+		// Count the skipped entry so progress still reaches the total
+		progressTracker.AddProgress(int64(file.UncompressedSize64))
+		return nil
+	}
+
+	// Open the file inside the archive
+	inFile, err := openZipFile(file, opts.Password)
+	if err != nil {
+		return fmt.Errorf("failed to open %s in archive: %w", file.Name, err)
+	}
+	defer inFile.Close()
+
+	if file.Mode()&os.ModeSymlink != 0 {
+		// Symlinks store their target as file content
+		target, err := io.ReadAll(opts.guard.reader(inFile, filePath))
+		if err != nil {
+			return fmt.Errorf("failed to read from archive: %w", err)
+		}
+		if err := opts.checkLinkTarget(destPath, filePath, string(target)); err != nil {
+			return err
+		}
+		if err := replaceWithLink(opts.guard, filePath, func(linkPath string) error { return os.Symlink(string(target), linkPath) }); err != nil {
+			return fmt.Errorf("failed to create symlink: %w", err)
+		}
+		progressTracker.AddProgress(int64(file.UncompressedSize64))
+	} else if err := writeExtractedFile(opts.guard, filePath, inFile, progressTracker); err != nil {
+		return err
+	}
+
+	return opts.restoreMetadata(filePath, meta)
+}
+
+// extractZipTo passes the entries of a ZIP archive that opts selects to sink
+func extractZipTo(reader *zip.Reader, sink Sink, opts ExtractOptions) error {
+	var files []*zip.File
+	var totalSize int64
+	for _, file := range reader.File {
+		if name := cleanEntryName(file.Name); name != "" && opts.selects(name) {
+			files = append(files, file)
+			totalSize += int64(file.UncompressedSize64)
+		}
+	}
+	if err := opts.guard.checkDeclared(totalSize); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		entry := zipEntry(file)
+		entry.Name = cleanEntryName(file.Name)
+		if err := opts.guard.entry(entry.Name); err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if err := sink.Put(entry, strings.NewReader("")); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := func() error {
+			inFile, err := openZipFile(file, opts.Password)
+			if err != nil {
+				return fmt.Errorf("failed to open %s in archive: %w", file.Name, err)
+			}
+			defer inFile.Close()
+
+			content := opts.guard.reader(inFile, entry.Name)
+			if entry.Mode&os.ModeSymlink != 0 {
+				// Symlinks store their target as file content
+				target, err := io.ReadAll(content)
+				if err != nil {
+					return fmt.Errorf("failed to read from archive: %w", err)
+				}
+				entry.Link = string(target)
+				content = strings.NewReader("")
+			}
+			return sink.Put(entry, content)
+		}()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// testZip decompresses every entry of a ZIP archive. archive/zip and openZipFile
+// verify the CRC32 or the authentication code of each entry. The decompressed data
+// is counted against the size limits of guard, which may be nil.
+func testZip(sourcePath, password string, guard *extractGuard) (*TestResult, error) {
+	reader, err := zip.OpenReader(sourcePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zip file: %w", err)
+	}
+	defer reader.Close()
+
+	result := &TestResult{}
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		result.Tested++
+		if err := testZipFile(file, password, guard); err != nil {
+			if errors.Is(err, ErrLimitExceeded) {
+				return result, err
+			}
+			result.fail(file.Name, err)
+		}
+	}
+	return result, nil
+}
+
+// testZipFile reads a single entry of a ZIP archive to the end
+func testZipFile(file *zip.File, password string, guard *extractGuard) error {
+	inFile, err := openZipFile(file, password)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	return checkEntryData(guard.reader(inFile, file.Name), int64(file.UncompressedSize64))
+}
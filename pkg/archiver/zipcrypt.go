@@ -0,0 +1,386 @@
+package archiver
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+var (
+	// ErrPasswordRequired is returned when an encrypted entry is extracted without a password
+	ErrPasswordRequired = errors.New("archive is encrypted, a password is required")
+	// ErrWrongPassword is returned when the password does not match an encrypted entry
+	ErrWrongPassword = errors.New("wrong password")
+)
+
+// WinZip AES encryption (AE-1 and AE-2), see https://www.winzip.com/en/support/aes-encryption/
+const (
+	zipMethodAES     = 99
+	zipExtraAES      = 0x9901
+	aesKeyIterations = 1000
+	aesMACSize       = 10
+	aesVerifierSize  = 2
+	aesStrength256   = 3
+)
+
+// zipFlagEncrypted marks an encrypted entry in the general purpose bit flag
+const zipFlagEncrypted = 0x1
+
+// aesSaltSize returns the salt length for a WinZip AES key strength
+func aesSaltSize(strength byte) int {
+	switch strength {
+	case 1:
+		return 8
+	case 2:
+		return 12
+	case 3:
+		return 16
+	}
+	return 0
+}
+
+// aesKeys derives the encryption key, authentication key and password verifier
+func aesKeys(password string, salt []byte, keySize int) (encKey, macKey, verifier []byte, err error) {
+	key, err := pbkdf2.Key(sha1.New, password, salt, aesKeyIterations, 2*keySize+aesVerifierSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return key[:keySize], key[keySize : 2*keySize], key[2*keySize:], nil
+}
+
+// winZipCTR is AES in counter mode with the little-endian counter used by WinZip,
+// starting at 1
+type winZipCTR struct {
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int
+}
+
+func newWinZipCTR(key []byte) (*winZipCTR, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &winZipCTR{block: block, used: aes.BlockSize}, nil
+}
+
+func (c *winZipCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.used == aes.BlockSize {
+			for j := range c.counter {
+				c.counter[j]++
+				if c.counter[j] != 0 {
+					break
+				}
+			}
+			c.block.Encrypt(c.stream[:], c.counter[:])
+			c.used = 0
+		}
+		dst[i] = src[i] ^ c.stream[c.used]
+		c.used++
+	}
+}
+
+// aesWriter encrypts and authenticates the compressed data of an AE-2 entry
+type aesWriter struct {
+	w      io.Writer
+	stream cipher.Stream
+	mac    hash.Hash
+	buf    []byte
+}
+
+// newAESWriter writes the salt and password verifier to w and returns a writer for the entry data.
+// Close appends the authentication code but does not close w.
+func newAESWriter(w io.Writer, password string) (*aesWriter, error) {
+	salt := make([]byte, aesSaltSize(aesStrength256))
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	encKey, macKey, verifier, err := aesKeys(password, salt, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive encryption key: %w", err)
+	}
+	stream, err := newWinZipCTR(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(salt); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(verifier); err != nil {
+		return nil, err
+	}
+	return &aesWriter{w: w, stream: stream, mac: hmac.New(sha1.New, macKey)}, nil
+}
+
+func (aw *aesWriter) Write(p []byte) (int, error) {
+	if cap(aw.buf) < len(p) {
+		aw.buf = make([]byte, len(p))
+	}
+	buf := aw.buf[:len(p)]
+	aw.stream.XORKeyStream(buf, p)
+	aw.mac.Write(buf)
+	return aw.w.Write(buf)
+}
+
+func (aw *aesWriter) Close() error {
+	_, err := aw.w.Write(aw.mac.Sum(nil)[:aesMACSize])
+	return err
+}
+
+// aesExtra returns the 0x9901 extra field of an AE-2 entry compressed with method
+func aesExtra(method uint16) []byte {
+	extra := make([]byte, 11)
+	binary.LittleEndian.PutUint16(extra[0:], zipExtraAES)
+	binary.LittleEndian.PutUint16(extra[2:], 7)
+	binary.LittleEndian.PutUint16(extra[4:], 2) // AE-2, no CRC
+	copy(extra[6:], "AE")
+	extra[8] = aesStrength256
+	binary.LittleEndian.PutUint16(extra[9:], method)
+	return extra
+}
+
+// parseAESExtra reads the vendor version, key strength and actual compression method
+// from the 0x9901 extra field
+func parseAESExtra(extra []byte) (version uint16, strength byte, method uint16, ok bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra[0:])
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if id == zipExtraAES && size >= 7 {
+			field := extra[:size]
+			return binary.LittleEndian.Uint16(field[0:]), field[4], binary.LittleEndian.Uint16(field[5:]), true
+		}
+		extra = extra[size:]
+	}
+	return 0, 0, 0, false
+}
+
+// openZipFile opens an entry of a ZIP archive for reading, decrypting it with password if needed
+func openZipFile(file *zip.File, password string) (io.ReadCloser, error) {
+	if file.Flags&zipFlagEncrypted == 0 {
+		return file.Open()
+	}
+	if password == "" {
+		return nil, ErrPasswordRequired
+	}
+	if file.Method == zipMethodAES {
+		return openAESFile(file, password)
+	}
+	return openZipCryptoFile(file, password)
+}
+
+// openAESFile decrypts a WinZip AES entry
+func openAESFile(file *zip.File, password string) (io.ReadCloser, error) {
+	version, strength, method, ok := parseAESExtra(file.Extra)
+	saltSize := aesSaltSize(strength)
+	if !ok || saltSize == 0 {
+		return nil, fmt.Errorf("invalid AES header for %s", file.Name)
+	}
+	dataSize := int64(file.CompressedSize64) - int64(saltSize+aesVerifierSize+aesMACSize)
+	if dataSize < 0 {
+		return nil, fmt.Errorf("invalid AES data size for %s", file.Name)
+	}
+
+	raw, err := file.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, saltSize+aesVerifierSize)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, err
+	}
+
+	encKey, macKey, verifier, err := aesKeys(password, header[:saltSize], 2*saltSize)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(verifier, header[saltSize:]) {
+		return nil, ErrWrongPassword
+	}
+	stream, err := newWinZipCTR(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := &aesReader{
+		r:      io.LimitReader(raw, dataSize),
+		raw:    raw,
+		stream: stream,
+		mac:    hmac.New(sha1.New, macKey),
+	}
+	reader, err := zipDecompressor(method, decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file.Name, err)
+	}
+	reader = &aesEntryReader{ReadCloser: reader, data: decrypted}
+	if version == 1 {
+		// AE-1 entries also carry the CRC of the plain data
+		return &crcReader{ReadCloser: reader, hash: crc32.NewIEEE(), want: file.CRC32, name: file.Name}, nil
+	}
+	return reader, nil
+}
+
+// aesReader decrypts the data of a WinZip AES entry and checks its authentication code at the end
+type aesReader struct {
+	r      io.Reader
+	raw    io.Reader
+	stream cipher.Stream
+	mac    hash.Hash
+	done   bool
+}
+
+func (ar *aesReader) Read(p []byte) (int, error) {
+	if ar.done {
+		return 0, io.EOF
+	}
+	n, err := ar.r.Read(p)
+	if n > 0 {
+		ar.mac.Write(p[:n])
+		ar.stream.XORKeyStream(p[:n], p[:n])
+	}
+	if err == io.EOF {
+		ar.done = true
+		code := make([]byte, aesMACSize)
+		if _, err := io.ReadFull(ar.raw, code); err != nil {
+			return n, err
+		}
+		if !hmac.Equal(code, ar.mac.Sum(nil)[:aesMACSize]) {
+			return n, errors.New("authentication failed, the archive is damaged")
+		}
+	}
+	return n, err
+}
+
+// aesEntryReader drains the encrypted data once decompression ends,
+// so that the authentication code is always checked
+type aesEntryReader struct {
+	io.ReadCloser
+	data *aesReader
+}
+
+func (er *aesEntryReader) Read(p []byte) (int, error) {
+	n, err := er.ReadCloser.Read(p)
+	if err == io.EOF {
+		if _, err := io.Copy(io.Discard, er.data); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// ZipCrypto, the legacy PKWARE stream cipher. It is only supported for reading.
+type zipCryptoKeys [3]uint32
+
+func newZipCryptoKeys(password string) *zipCryptoKeys {
+	keys := &zipCryptoKeys{305419896, 591751049, 878082192}
+	for i := 0; i < len(password); i++ {
+		keys.update(password[i])
+	}
+	return keys
+}
+
+func (k *zipCryptoKeys) update(b byte) {
+	k[0] = crc32.IEEETable[byte(k[0])^b] ^ (k[0] >> 8)
+	k[1] = (k[1]+(k[0]&0xff))*134775813 + 1
+	k[2] = crc32.IEEETable[byte(k[2])^byte(k[1]>>24)] ^ (k[2] >> 8)
+}
+
+func (k *zipCryptoKeys) decrypt(p []byte) {
+	for i := range p {
+		temp := uint16(k[2]) | 2
+		p[i] ^= byte((uint32(temp) * uint32(temp^1)) >> 8)
+		k.update(p[i])
+	}
+}
+
+type zipCryptoReader struct {
+	r    io.Reader
+	keys *zipCryptoKeys
+}
+
+func (zr *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := zr.r.Read(p)
+	zr.keys.decrypt(p[:n])
+	return n, err
+}
+
+// openZipCryptoFile decrypts an entry encrypted with the legacy ZipCrypto cipher
+func openZipCryptoFile(file *zip.File, password string) (io.ReadCloser, error) {
+	if file.CompressedSize64 < 12 {
+		return nil, fmt.Errorf("invalid encryption header for %s", file.Name)
+	}
+	raw, err := file.OpenRaw()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := newZipCryptoKeys(password)
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(raw, header); err != nil {
+		return nil, err
+	}
+	keys.decrypt(header)
+
+	// The last header byte repeats the high byte of the CRC, or of the
+	// modification time when the CRC is stored in a data descriptor
+	check := byte(file.CRC32 >> 24)
+	if file.Flags&0x8 != 0 {
+		check = byte(file.ModifiedTime >> 8)
+	}
+	if header[11] != check {
+		return nil, ErrWrongPassword
+	}
+
+	decrypted := &zipCryptoReader{r: io.LimitReader(raw, int64(file.CompressedSize64)-12), keys: keys}
+	reader, err := zipDecompressor(file.Method, decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file.Name, err)
+	}
+	return &crcReader{ReadCloser: reader, hash: crc32.NewIEEE(), want: file.CRC32, name: file.Name}, nil
+}
+
+// zipDecompressor returns a reader for data compressed with a ZIP method
+func zipDecompressor(method uint16, r io.Reader) (io.ReadCloser, error) {
+	switch method {
+	case zip.Store:
+		return io.NopCloser(r), nil
+	case zip.Deflate:
+		return flate.NewReader(r), nil
+	}
+	return nil, fmt.Errorf("compression method %d: %w", method, ErrUnsupported)
+}
+
+// crcReader verifies the CRC-32 of the data read once the end is reached
+type crcReader struct {
+	io.ReadCloser
+	hash hash.Hash32
+	want uint32
+	name string
+}
+
+func (cr *crcReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.hash.Write(p[:n])
+	if err == io.EOF && cr.hash.Sum32() != cr.want {
+		return n, fmt.Errorf("checksum mismatch for %s: %w", cr.name, zip.ErrChecksum)
+	}
+	return n, err
+}
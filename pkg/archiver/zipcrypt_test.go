@@ -0,0 +1,155 @@
+package archiver
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// compressEncrypted compresses a small tree into a ZIP archive encrypted with password
+func compressEncrypted(t *testing.T, password string) (archive string, want map[string]string) {
+	t.Helper()
+	want = map[string]string{
+		"hello.txt":      "hello aes\n",
+		"docs/lorem.txt": strings.Repeat("lorem ipsum dolor sit amet ", 200),
+	}
+	source := t.TempDir()
+	for name, content := range want {
+		path := filepath.Join(source, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive = filepath.Join(t.TempDir(), "encrypted.zip")
+	if err := Compress(source, archive, "zip", CompressOptions{Password: password}); err != nil {
+		t.Fatalf("Compress: %v", err)
+	}
+	return archive, want
+}
+
+func TestZipAESRoundTrip(t *testing.T) {
+	archive, want := compressEncrypted(t, "secret")
+
+	// Every file is an AE-2 entry, which stores no CRC of the plain data
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	for _, file := range reader.File {
+		if file.Mode().IsDir() {
+			continue
+		}
+		version, strength, _, ok := parseAESExtra(file.Extra)
+		if file.Method != zipMethodAES || !ok || version != 2 || strength != aesStrength256 || file.CRC32 != 0 {
+			t.Errorf("%s: method %d, AES version %d, strength %d, CRC %08x, want an AE-2 entry", file.Name, file.Method, version, strength, file.CRC32)
+		}
+		if file.Flags&zipFlagEncrypted == 0 {
+			t.Errorf("%s: not flagged as encrypted", file.Name)
+		}
+	}
+
+	result, err := Test(archive, "secret")
+	if err != nil || !result.OK() {
+		t.Fatalf("Test: %v, failures %v", err, result)
+	}
+	dest := t.TempDir()
+	if err := ExtractWithProgress(archive, dest, ExtractOptions{Password: "secret"}, nil); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	checkExtracted(t, dest, want)
+}
+
+func TestZipAESAuthentication(t *testing.T) {
+	archive, _ := compressEncrypted(t, "secret")
+
+	// Flip the last byte of the authentication code of hello.txt
+	reader, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offset int64 = -1
+	for _, file := range reader.File {
+		if file.Name == "hello.txt" {
+			start, err := file.DataOffset()
+			if err != nil {
+				t.Fatal(err)
+			}
+			offset = start + int64(file.CompressedSize64) - 1
+		}
+	}
+	reader.Close()
+	if offset < 0 {
+		t.Fatal("hello.txt not found in the archive")
+	}
+	data, err := os.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[offset] ^= 0xff
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Test(archive, "secret")
+	if err != nil {
+		t.Fatalf("Test: %v", err)
+	}
+	if len(result.Failures) != 1 || result.Failures[0].Name != "hello.txt" {
+		t.Errorf("failures %v, want hello.txt", result.Failures)
+	}
+	if err := ExtractWithProgress(archive, t.TempDir(), ExtractOptions{Password: "secret"}, nil); err == nil {
+		t.Error("entry with a wrong authentication code extracted without error")
+	}
+}
+
+func TestZipWrongPassword(t *testing.T) {
+	aes, _ := compressEncrypted(t, "secret")
+	for _, archive := range []string{aes, filepath.Join("testdata", "zipcrypto.zip")} {
+		t.Run(filepath.Base(archive), func(t *testing.T) {
+			err := ExtractWithProgress(archive, t.TempDir(), ExtractOptions{Password: "wrong"}, nil)
+			if !errors.Is(err, ErrWrongPassword) {
+				t.Errorf("wrong password: error %v, want %v", err, ErrWrongPassword)
+			}
+			err = ExtractWithProgress(archive, t.TempDir(), ExtractOptions{}, nil)
+			if !errors.Is(err, ErrPasswordRequired) {
+				t.Errorf("no password: error %v, want %v", err, ErrPasswordRequired)
+			}
+		})
+	}
+}
+
+func TestZipCryptoExtract(t *testing.T) {
+	// Made with Info-ZIP "zip -e", which stores the CRC in data descriptors and
+	// checks the password against the modification time
+	archive := filepath.Join("testdata", "zipcrypto.zip")
+	entries, err := List(archive)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	for _, entry := range entries {
+		if !entry.Encrypted {
+			t.Errorf("%s: not listed as encrypted", entry.Name)
+		}
+	}
+
+	result, err := Test(archive, "secret")
+	if err != nil || !result.OK() {
+		t.Fatalf("Test: %v, failures %v", err, result)
+	}
+	dest := t.TempDir()
+	if err := ExtractWithProgress(archive, dest, ExtractOptions{Password: "secret"}, nil); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+	checkExtracted(t, dest, map[string]string{
+		"hello.txt": "hello zipcrypto\n",
+		"lorem.txt": strings.Repeat("lorem ipsum dolor sit amet ", 200) + "\n",
+	})
+}
@@ -0,0 +1,100 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/binary"
+	"os"
+	"time"
+)
+
+// Extra fields of the Info-ZIP Unix extensions
+const (
+	// zipExtraUnix ("UX") holds the access and modification times in seconds
+	zipExtraUnix = 0x5855
+	// zipExtraUnixOwner ("ux") holds the numeric user and group of the owner
+	zipExtraUnixOwner = 0x7875
+)
+
+// unixZipExtra returns the extra fields recording the access time and owner of the file
+// described by info, for the ones the platform provides
+func unixZipExtra(info os.FileInfo) []byte {
+	var extra []byte
+
+	// archive/tar already reads the access time on every platform
+	if header, err := tar.FileInfoHeader(info, ""); err == nil && !header.AccessTime.IsZero() {
+		field := make([]byte, 12)
+		binary.LittleEndian.PutUint16(field[0:], zipExtraUnix)
+		binary.LittleEndian.PutUint16(field[2:], 8)
+		binary.LittleEndian.PutUint32(field[4:], uint32(header.AccessTime.Unix()))
+		binary.LittleEndian.PutUint32(field[8:], uint32(info.ModTime().Unix()))
+		extra = append(extra, field...)
+	}
+
+	if uid, gid, ok := fileOwner(info); ok {
+		field := make([]byte, 15)
+		binary.LittleEndian.PutUint16(field[0:], zipExtraUnixOwner)
+		binary.LittleEndian.PutUint16(field[2:], 11)
+		field[4] = 1 // version
+		field[5] = 4
+		binary.LittleEndian.PutUint32(field[6:], uint32(uid))
+		field[10] = 4
+		binary.LittleEndian.PutUint32(field[11:], uint32(gid))
+		extra = append(extra, field...)
+	}
+	return extra
+}
+
+// zipUnixMetadata adds the access time and owner found in the extra fields of a ZIP entry to meta
+func zipUnixMetadata(extra []byte, meta *entryMetadata) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if size > len(extra)-4 {
+			return
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+
+		switch id {
+		case zipExtraUnix:
+			if size >= 8 {
+				meta.accessTime = time.Unix(int64(binary.LittleEndian.Uint32(field)), 0)
+			}
+		case zipExtraUnixOwner:
+			// Only version 1 of the field is defined
+			if size < 1 || field[0] != 1 {
+				continue
+			}
+			uid, rest, ok := zipOwnerID(field[1:])
+			if !ok {
+				continue
+			}
+			gid, _, ok := zipOwnerID(rest)
+			if !ok {
+				continue
+			}
+			meta.uid, meta.gid, meta.hasOwner = uid, gid, true
+		}
+	}
+}
+
+// zipOwnerID reads a size prefixed little-endian ID of a "ux" field and returns the data after it
+func zipOwnerID(data []byte) (int, []byte, bool) {
+	if len(data) < 1 || int(data[0]) > len(data)-1 || data[0] > 8 {
+		return 0, nil, false
+	}
+	size := int(data[0])
+	var id uint64
+	for i := size; i > 0; i-- {
+		id = id<<8 | uint64(data[i])
+	}
+	return int(id), data[1+size:], true
+}
+
+// zipMetadata returns the metadata stored for a ZIP entry
+func zipMetadata(file *zip.File) entryMetadata {
+	meta := entryMetadata{mode: file.Mode(), modTime: file.Modified}
+	zipUnixMetadata(file.Extra, &meta)
+	return meta
+}
@@ -0,0 +1,172 @@
+package archiver
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// errZipAborted stops the walk of compressZipParallel after a failure
+var errZipAborted = errors.New("zip compression aborted")
+
+// zipEntryJob is a file compressed by one of the workers of compressZipParallel
+type zipEntryJob struct {
+	entry  sourceEntry
+	result chan zipEntryResult
+}
+
+// zipEntryResult is a compressed entry waiting to be written to the archive
+type zipEntryResult struct {
+	header *zip.FileHeader
+	data   *spillBuffer
+	err    error
+}
+
+// compressZipParallel adds the entries of walk to zipWriter using opts.workers() goroutines.
+// Each entry is compressed into a spill buffer and then copied into the archive with CreateRaw
+// in walk order, so the output does not depend on scheduling. Entries count as finished once written.
+func compressZipParallel(zipWriter *zip.Writer, walk entryWalker, opts CompressOptions, progressTracker *ProgressTracker) error {
+	workers := opts.workers()
+
+	// jobs feeds the workers, pending keeps the same jobs in walk order for the writer.
+	// Its capacity bounds the number of compressed entries held in memory or spill files.
+	jobs := make(chan *zipEntryJob)
+	pending := make(chan *zipEntryJob, workers)
+	abort := make(chan struct{})
+
+	var walkErr error
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		walkErr = walk(func(entry sourceEntry) error {
+			job := &zipEntryJob{entry: entry, result: make(chan zipEntryResult, 1)}
+			select {
+			case jobs <- job:
+			case <-abort:
+				return errZipAborted
+			}
+			// Every job taken by a worker produces a result, so the writer can always drain pending
+			pending <- job
+			return nil
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buffer := make([]byte, opts.bufferSize())
+			for job := range jobs {
+				select {
+				case <-abort:
+					job.result <- zipEntryResult{err: errZipAborted}
+					continue
+				default:
+				}
+				progressTracker.StartFile(job.entry.name)
+				header, data, err := compressZipEntry(job.entry, opts, buffer, progressTracker)
+				job.result <- zipEntryResult{header: header, data: data, err: err}
+			}
+		}()
+	}
+
+	// Write the entries in walk order, stopping the workers on the first error
+	// or once the compression is canceled
+	var err error
+	for job := range pending {
+		result := <-job.result
+		if err == nil {
+			err = result.err
+		}
+		if err == nil {
+			err = contextErr(opts.ctx)
+		}
+		if err == nil {
+			err = writeRawZipEntry(zipWriter, result.header, result.data)
+		}
+		if err == nil {
+			progressTracker.FinishFile(job.entry.name, int64(result.header.UncompressedSize64), int64(result.header.CompressedSize64))
+		}
+		if result.data != nil {
+			result.data.Close()
+		}
+		if err != nil {
+			select {
+			case <-abort:
+			default:
+				close(abort)
+			}
+		}
+	}
+	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+	return walkErr
+}
+
+// compressZipEntry compresses, and encrypts if opts has a password, the file, directory or symlink of entry
+// into a spill buffer. It returns the header to store the data with CreateRaw.
+func compressZipEntry(entry sourceEntry, opts CompressOptions, buffer []byte, progressTracker *ProgressTracker) (*zip.FileHeader, *spillBuffer, error) {
+	header, file, err := openZipEntry(entry, opts, progressTracker)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+	method := header.Method
+
+	data := newSpillBuffer(spillThreshold)
+	var out io.Writer = data
+	var encrypter *aesWriter
+	// Directories have no data to encrypt
+	if opts.Password != "" && !entry.info.IsDir() {
+		encrypter, err = newAESWriter(data, opts.Password)
+		if err != nil {
+			data.Close()
+			return nil, nil, fmt.Errorf("failed to encrypt %s: %w", entry.name, err)
+		}
+		out = encrypter
+	}
+	compressor := newZipEntryWriter(out, method, opts.Level)
+
+	checksum := crc32.NewIEEE()
+	size, err := io.CopyBuffer(compressor, io.TeeReader(file, checksum), buffer)
+	if err == nil {
+		err = compressor.Close()
+	}
+	if err == nil && encrypter != nil {
+		err = encrypter.Close()
+	}
+	if err != nil {
+		data.Close()
+		return nil, nil, fmt.Errorf("failed to compress %s: %w", entry.name, err)
+	}
+
+	prepareRawHeader(header)
+	header.Method = method
+	header.CRC32 = checksum.Sum32()
+	if encrypter != nil {
+		setAESHeader(header, method)
+	}
+	header.CompressedSize64 = uint64(data.Size())
+	header.UncompressedSize64 = uint64(size)
+
+	return header, data, nil
+}
+
+// writeRawZipEntry copies an entry compressed by compressZipEntry into the archive
+func writeRawZipEntry(zipWriter *zip.Writer, header *zip.FileHeader, data *spillBuffer) error {
+	writer, err := zipWriter.CreateRaw(header)
+	if err != nil {
+		return fmt.Errorf("failed to create zip header: %w", err)
+	}
+	if _, err := data.WriteTo(writer); err != nil {
+		return fmt.Errorf("failed to write file to zip: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package archiver
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+)
+
+// zipFileSummary is what a test compares of a ZIP entry
+type zipFileSummary struct {
+	name    string
+	method  uint16
+	crc     uint32
+	size    uint64
+	content string
+}
+
+// readZipSummary returns the entries of the ZIP archive at path in archive order
+func readZipSummary(t *testing.T, path string) []zipFileSummary {
+	t.Helper()
+	reader, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var files []zipFileSummary
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("%s: %v", file.Name, err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", file.Name, err)
+		}
+		files = append(files, zipFileSummary{file.Name, file.Method, file.CRC32, file.UncompressedSize64, string(content)})
+	}
+	return files
+}
+
+func TestZipParallelMatchesSequential(t *testing.T) {
+	// Text, random data and empty files of varied sizes, so workers finish out of order
+	source := t.TempDir()
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 40; i++ {
+		var content []byte
+		switch i % 3 {
+		case 0:
+			content = []byte(strings.Repeat(fmt.Sprintf("line %d\n", i), random.Intn(20000)))
+		case 1:
+			content = make([]byte, random.Intn(200000))
+			random.Read(content)
+		}
+		path := filepath.Join(source, fmt.Sprintf("dir%d", i%4), fmt.Sprintf("file%02d.bin", i))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(source, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	archives := make(map[int]string)
+	for _, workers := range []int{1, 4} {
+		archives[workers] = filepath.Join(t.TempDir(), "tree.zip")
+		if err := CompressWithProgress(source, archives[workers], "zip", CompressOptions{Workers: workers}, nil); err != nil {
+			t.Fatalf("Compress with %d workers: %v", workers, err)
+		}
+	}
+
+	sequential := readZipSummary(t, archives[1])
+	parallel := readZipSummary(t, archives[4])
+	if len(parallel) != len(sequential) {
+		t.Fatalf("%d entries with 4 workers, want %d", len(parallel), len(sequential))
+	}
+	for i := range sequential {
+		if parallel[i] != sequential[i] {
+			t.Errorf("entry %d: %s (method %d, crc %08x, %d bytes) with 4 workers, want %s (method %d, crc %08x, %d bytes)",
+				i, parallel[i].name, parallel[i].method, parallel[i].crc, parallel[i].size,
+				sequential[i].name, sequential[i].method, sequential[i].crc, sequential[i].size)
+		}
+	}
+}
+
+// countingReader counts the readers of a test that were read at all
+type countingReader struct {
+	reader io.Reader
+	read   *atomic.Int32
+	once   atomic.Bool
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if r.once.CompareAndSwap(false, true) {
+		r.read.Add(1)
+	}
+	return r.reader.Read(p)
+}
+
+func TestZipParallelAbort(t *testing.T) {
+	// A failing entry stops the workers before they compress the rest
+	errBroken := errors.New("broken source")
+	var read atomic.Int32
+	var files []ReaderSource
+	for i := 0; i < 100; i++ {
+		var reader io.Reader = strings.NewReader(strings.Repeat("data ", 1000))
+		if i == 5 {
+			reader = iotest.ErrReader(errBroken)
+		}
+		files = append(files, ReaderSource{Name: fmt.Sprintf("file%03d", i), Reader: &countingReader{reader: reader, read: &read}})
+	}
+	err := CompressReaders(files, io.Discard, "zip", CompressOptions{Workers: 4})
+	if !errors.Is(err, errBroken) {
+		t.Errorf("error %v, want %v", err, errBroken)
+	}
+	if n := read.Load(); n > 30 {
+		t.Errorf("%d of %d files read after the failure of the sixth", n, len(files))
+	}
+
+	// A canceled compression removes the partial archive
+	source := writeCancelTree(t)
+	archive := filepath.Join(t.TempDir(), "canceled.zip")
+	ctx, tracker := cancelingTracker(FileFinished)
+	err = CompressContext(ctx, source, archive, "zip", CompressOptions{Workers: 4}, tracker)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Lstat(archive); !os.IsNotExist(err) {
+		t.Errorf("partial archive left behind: %v", err)
+	}
+}
@@ -7,11 +7,16 @@ import (
 
 // NewMaxCompressionWriter returns a WriteCloser that writes to w with maximum compression level
 func NewMaxCompressionWriter(w io.Writer) io.WriteCloser {
-	fw, err := flate.NewWriter(w, flate.BestCompression)
+	return NewCompressionWriter(w, flate.BestCompression)
+}
+
+// NewCompressionWriter returns a WriteCloser that deflates to w with the given flate level
+func NewCompressionWriter(w io.Writer, level int) io.WriteCloser {
+	fw, err := flate.NewWriter(w, level)
 	if err != nil {
 		// If there's an error, fallback to a default writer
-		// This should never happen with BestCompression, but we handle it just in case
+		// This only happens for levels outside the range supported by compress/flate
 		fw, _ = flate.NewWriter(w, flate.DefaultCompression)
 	}
 	return fw
-} 
\ No newline at end of file
+}
@@ -43,32 +43,40 @@ func (pw *ProgressWriter) Write(p []byte) (int, error) {
 
 // DisplayProgress prints the current progress to the console
 func (pw *ProgressWriter) DisplayProgress() {
-	// Calculate percentage
-	var percentage float64
-	if pw.TotalSize > 0 {
-		percentage = float64(pw.BytesWritten) / float64(pw.TotalSize) * 100
-	} else {
-		percentage = 0
-	}
-
 	// Calculate speed
 	elapsed := time.Since(pw.StartTime).Seconds()
-	var bytesPerSecond int64
+	var bytesPerSecond float64
 	if elapsed > 0 {
-		bytesPerSecond = int64(float64(pw.BytesWritten) / elapsed)
+		bytesPerSecond = float64(pw.BytesWritten) / elapsed
 	}
 
 	// Calculate ETA
-	var eta string
-	if bytesPerSecond > 0 && pw.TotalSize > 0 {
-		secondsLeft := float64(pw.TotalSize-pw.BytesWritten) / float64(bytesPerSecond)
-		if secondsLeft > 0 {
-			eta = formatDuration(secondsLeft)
-		} else {
-			eta = "0s"
-		}
-	} else {
-		eta = "Unknown"
+	var eta time.Duration
+	if bytesPerSecond > 0 && pw.TotalSize > pw.BytesWritten {
+		eta = time.Duration(float64(pw.TotalSize-pw.BytesWritten) / bytesPerSecond * float64(time.Second))
+	}
+
+	PrintProgress(pw.Description, pw.BytesWritten, pw.TotalSize, bytesPerSecond, eta)
+}
+
+// PrintProgress prints a progress line to the console from a speed and ETA computed
+// by the caller, such as the fields of an archiver.ProgressEvent. A zero eta is shown
+// as unknown until done reaches total.
+func PrintProgress(description string, done, total int64, bytesPerSecond float64, eta time.Duration) {
+	// Calculate percentage
+	var percentage float64
+	if total > 0 {
+		percentage = min(float64(done)/float64(total)*100, 100)
+	}
+
+	var etaText string
+	switch {
+	case eta > 0:
+		etaText = formatDuration(eta.Seconds())
+	case total > 0 && done >= total:
+		etaText = "0s"
+	default:
+		etaText = "Unknown"
 	}
 
 	// Build progress bar (50 chars width)
@@ -88,11 +96,11 @@ func (pw *ProgressWriter) DisplayProgress() {
 
 	// Print progress information
 	fmt.Printf("\r%s: %s %.1f%% %s/s ETA: %s",
-		pw.Description,
+		description,
 		progressBar,
 		percentage,
-		formatBytes(bytesPerSecond),
-		eta,
+		formatBytes(int64(bytesPerSecond)),
+		etaText,
 	)
 }
 
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseSize parses a byte count such as "512", "64K", "4MB" or "1.5G".
+// Units are powers of 1024, matching the sizes printed by the progress display.
+func ParseSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	value = strings.TrimSuffix(strings.TrimSuffix(value, "B"), "I")
+
+	multiplier := int64(1)
+	if n := len(value); n > 0 {
+		if exp := strings.IndexByte("KMGTPE", value[n-1]); exp >= 0 {
+			for i := 0; i <= exp; i++ {
+				multiplier *= 1024
+			}
+			value = strings.TrimSpace(value[:n-1])
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size: %q", s)
+	}
+	return int64(number * float64(multiplier)), nil
+}
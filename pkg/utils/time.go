@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dateLayouts are the date formats accepted by ParseTime, dates without a zone are local times
+var dateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// ParseTime parses a point in time given as a date such as "2024-01-31" or "2024-01-31 15:04",
+// or as an age before now such as "90m", "12h", "7d" or "2w".
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	value := strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	// Days and weeks are not time.Duration units
+	var age time.Duration
+	var err error
+	if n := len(value); n > 0 && (value[n-1] == 'd' || value[n-1] == 'w') {
+		var number float64
+		number, err = strconv.ParseFloat(value[:n-1], 64)
+		age = time.Duration(number * float64(24*time.Hour))
+		if value[n-1] == 'w' {
+			age *= 7
+		}
+	} else {
+		age, err = time.ParseDuration(value)
+	}
+	if err != nil || age < 0 {
+		return time.Time{}, fmt.Errorf("invalid time or age: %q", s)
+	}
+	return now.Add(-age), nil
+}
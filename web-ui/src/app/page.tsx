@@ -1,7 +1,7 @@
 'use client'
 
 import { useState } from 'react'
-import FileUpload from '@/components/FileUpload'
+import FileUpload, { type JobProgress } from '@/components/FileUpload'
 import CompressionResult from '@/components/CompressionResult'
 import FormatSelector from '@/components/FormatSelector'
 import Header from '@/components/Header'
@@ -16,14 +16,49 @@ interface CompressionResponse {
     inputSize?: number
 }
 
+// Final state of a compression job
+interface Job {
+    state: string
+    result?: CompressionResponse
+    error?: string
+}
+
 export default function Home() {
     const [format, setFormat] = useState<string>('')
     const [isLoading, setIsLoading] = useState<boolean>(false)
     const [result, setResult] = useState<CompressionResponse | null>(null)
+    const [progress, setProgress] = useState<JobProgress | null>(null)
+
+    // Follow the progress of a job until it finishes
+    const followJob = (id: string) =>
+        new Promise<CompressionResponse>((resolve) => {
+            const events = new EventSource(`/api/jobs/${id}/events`)
+            events.addEventListener('progress', (event) => {
+                setProgress(JSON.parse((event as MessageEvent).data))
+            })
+            for (const state of ['done', 'failed', 'canceled']) {
+                events.addEventListener(state, (event) => {
+                    events.close()
+                    const job: Job = JSON.parse((event as MessageEvent).data)
+                    resolve(job.result ?? {
+                        success: false,
+                        message: job.error || 'The compression was canceled',
+                    })
+                })
+            }
+            events.onerror = () => {
+                events.close()
+                resolve({
+                    success: false,
+                    message: 'Lost the connection to the compression',
+                })
+            }
+        })
 
     const handleCompression = async (file: File) => {
         setIsLoading(true)
         setResult(null)
+        setProgress(null)
 
         try {
             // Create form data to send the file
@@ -35,14 +70,18 @@ export default function Home() {
                 formData.append('format', format)
             }
 
-            // Send file to API for compression
-            const response = await fetch('/api/compress', {
+            // Start a compression job and follow it to its result
+            const response = await fetch('/api/jobs', {
                 method: 'POST',
                 body: formData,
             })
 
             const data = await response.json()
-            setResult(data)
+            if (!data.success) {
+                setResult(data)
+                return
+            }
+            setResult(await followJob(data.job.id))
         } catch (error) {
             console.error('Error compressing file:', error)
             setResult({
@@ -80,6 +119,7 @@ export default function Home() {
                             <FileUpload
                                 onFileSelect={handleCompression}
                                 isLoading={isLoading}
+                                progress={progress}
                                 format={format}
                             />
                         </div>
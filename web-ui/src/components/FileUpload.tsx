@@ -3,13 +3,21 @@ import { useDropzone } from 'react-dropzone'
 import { FiUpload, FiFile } from 'react-icons/fi'
 import { motion } from 'framer-motion'
 
+// Progress of a compression job, sent by /api/jobs/{id}/events
+export interface JobProgress {
+    percentage: number
+    stage: string
+    fileName: string
+}
+
 interface FileUploadProps {
     onFileSelect: (file: File) => void
     isLoading: boolean
+    progress: JobProgress | null
     format: string
 }
 
-export default function FileUpload({ onFileSelect, isLoading, format }: FileUploadProps) {
+export default function FileUpload({ onFileSelect, isLoading, progress, format }: FileUploadProps) {
     const [fileHover, setFileHover] = useState(false)
     const [selectedFile, setSelectedFile] = useState<File | null>(null)
 
@@ -51,7 +59,24 @@ export default function FileUpload({ onFileSelect, isLoading, format }: FileUplo
             >
                 <input {...getInputProps()} />
 
-                {isLoading ? (
+                {isLoading && progress ? (
+                    <div className="py-10 flex flex-col items-center">
+                        <div className="w-full h-3 bg-gray-200 dark:bg-gray-700 rounded-full overflow-hidden mb-4">
+                            <div
+                                className="h-full bg-primary transition-all duration-500"
+                                style={{ width: `${progress.percentage}%` }}
+                            ></div>
+                        </div>
+                        <p className="text-gray-600 dark:text-gray-300">
+                            {progress.stage
+                                ? `${progress.stage.charAt(0).toUpperCase()}${progress.stage.slice(1)}... ${Math.floor(progress.percentage)}%`
+                                : 'Waiting for a free worker...'}
+                        </p>
+                        {progress.fileName && (
+                            <p className="text-xs text-gray-400 dark:text-gray-500 mt-1">{progress.fileName}</p>
+                        )}
+                    </div>
+                ) : isLoading ? (
                     <div className="py-10 flex flex-col items-center">
                         <div className="w-16 h-16 border-4 border-primary border-t-transparent rounded-full animate-spin mb-4"></div>
                         <p className="text-gray-600 dark:text-gray-300">